@@ -16,11 +16,31 @@ var (
 	LogPathDir string
 	AppSaveDir string
 	JdkSaveDir string
+	// DumpSaveDir 应用诊断文件(堆转储等)存储目录
+	DumpSaveDir string
+	// VolumeSaveDir 默认的应用数据卷存储目录
+	VolumeSaveDir string
+	// LogSpoolDir 应用输出中转目录
+	LogSpoolDir string
+	// PluginLibDir 插件库存储目录
+	PluginLibDir string
 )
 
 const currentUser = "{{ .UserName }}"
 //const currentUser = "slx"
 
+// SandboxInitEnv 沙箱初始化进程的环境变量名称
+const SandboxInitEnv = "BYPT_SANDBOX_INIT"
+
+// LogRelayEnv 输出中转进程的环境变量名称, 值为中转目录
+const LogRelayEnv = "BYPT_LOG_RELAY"
+
+// RunIdEnv 应用单次运行标识的环境变量名称
+const RunIdEnv = "BYPT_RUN_ID"
+
+// PluginStatusFdEnv 插件状态通道的环境变量名称, 值为插件可写入的文件描述符
+const PluginStatusFdEnv = "BYPT_PLUGIN_STATUS_FD"
+
 func init() {
 	var err error
 	if currentUser == "" {
@@ -40,8 +60,14 @@ func init() {
 	AppSaveDir = filepath.Join(HomeDir, ".devTools", "appData")
 	JdkSaveDir = filepath.Join(HomeDir, ".devTools", "jdkData")
 	LogPathDir = filepath.Join(HomeDir, ".devTools", "logs")
+	DumpSaveDir = filepath.Join(HomeDir, ".devTools", "dumps")
+	VolumeSaveDir = filepath.Join(HomeDir, ".devTools", "volumes")
+	LogSpoolDir = filepath.Join(HomeDir, ".devTools", ".spool")
+	PluginLibDir = filepath.Join(HomeDir, ".devTools", "pluginLib")
 
-	initBashConfig()
+	if os.Getenv(SandboxInitEnv) == "" && os.Getenv(LogRelayEnv) == "" {
+		initBashConfig()
+	}
 }
 
 func GetUidAndGid() (int, int, error) {
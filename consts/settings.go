@@ -11,4 +11,12 @@ const (
 	DbSettingAppSaveDir = "appSaveDir"
 	// DbSettingJdkSaveDir jdk存储目录
 	DbSettingJdkSaveDir = "jdkSaveDir"
+	// DbSettingPortRange 自动分配端口范围
+	DbSettingPortRange = "portRange"
+	// DbSettingVolumeDir 应用数据卷存储目录
+	DbSettingVolumeDir = "volumeDir"
+	// DbSettingJdkScanDirs 查找本地jdk时额外扫描的目录
+	DbSettingJdkScanDirs = "jdkScanDirs"
+	// DbSettingPluginLibDir 插件库存储目录
+	DbSettingPluginLibDir = "pluginLibDir"
 )
@@ -55,6 +55,15 @@ func initDataTable() {
 	mainSqlite3Db.AutoMigrate(&vos.DbAppStartInfo{})
 	mainSqlite3Db.AutoMigrate(&vos.DbJdkInfo{})
 	mainSqlite3Db.AutoMigrate(&vos.DbLogClearInfo{})
+	mainSqlite3Db.AutoMigrate(&vos.DbAppUpgradeRecord{})
+	mainSqlite3Db.AutoMigrate(&vos.DbAppJobRecord{})
+	mainSqlite3Db.AutoMigrate(&vos.DbSecret{})
+	mainSqlite3Db.AutoMigrate(&vos.DbAppEvent{})
+	mainSqlite3Db.AutoMigrate(&vos.DbNotifyRule{})
+	mainSqlite3Db.AutoMigrate(&vos.DbConfigBundle{})
+	mainSqlite3Db.AutoMigrate(&vos.DbAppProcess{})
+	mainSqlite3Db.AutoMigrate(&vos.DbLocalJdk{})
+	mainSqlite3Db.AutoMigrate(&vos.DbPluginLib{})
 
 	dbSettingModel := mainSqlite3Db.Model(&vos.DbSetting{})
 	whereSetting := dbSettingModel.Where(&vos.DbSetting{
@@ -103,6 +112,51 @@ func initDataTable() {
 			StopApp: true,
 		})
 	}
+
+	count = 0
+	if err := dbSettingModel.Where(&vos.DbSetting{
+		Name: consts.DbSettingPortRange,
+	}).Count(&count).Error; err == nil && count == 0 {
+		dbSettingModel.Create(&vos.DbSetting{
+			Name: consts.DbSettingPortRange,
+			Desc: "自动分配端口范围, 格式: 起始端口-结束端口 例: 20000-29999",
+			Val:  "20000-29999",
+		})
+	}
+
+	count = 0
+	if err := dbSettingModel.Where(&vos.DbSetting{
+		Name: consts.DbSettingVolumeDir,
+	}).Count(&count).Error; err == nil && count == 0 {
+		dbSettingModel.Create(&vos.DbSetting{
+			Name:    consts.DbSettingVolumeDir,
+			Desc:    "应用数据卷存放目录",
+			Val:     consts.VolumeSaveDir,
+			StopApp: true,
+		})
+	}
+
+	count = 0
+	if err := dbSettingModel.Where(&vos.DbSetting{
+		Name: consts.DbSettingJdkScanDirs,
+	}).Count(&count).Error; err == nil && count == 0 {
+		dbSettingModel.Create(&vos.DbSetting{
+			Name: consts.DbSettingJdkScanDirs,
+			Desc: "查找本地jdk时额外扫描的目录, 多个目录使用系统路径列表分隔符(linux为:, windows为;)分隔",
+		})
+	}
+
+	count = 0
+	if err := dbSettingModel.Where(&vos.DbSetting{
+		Name: consts.DbSettingPluginLibDir,
+	}).Count(&count).Error; err == nil && count == 0 {
+		dbSettingModel.Create(&vos.DbSetting{
+			Name:    consts.DbSettingPluginLibDir,
+			Desc:    "插件库存放目录",
+			Val:     consts.PluginLibDir,
+			StopApp: true,
+		})
+	}
 }
 
 func GetDb() *gorm.DB {
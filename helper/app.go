@@ -12,6 +12,7 @@ import (
 	"github.com/byzk-org/bypt-server/utils"
 	"github.com/byzk-org/bypt-server/vos"
 	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
 	"io"
 	"io/ioutil"
 	"os"
@@ -21,28 +22,36 @@ import (
 	"strconv"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
 )
 
 var AppStatusMgr = newAppRunMgr()
 
+// errAppContentDamaged 运行文件摘要与导入时不一致
+var errAppContentDamaged = errors.New("文件已被损坏")
+
 // appRunMgr app管理器
 type appRunMgr struct {
 	sync.RWMutex
 	startAppMap map[string]*AppStatusInfo
+	// upgradeAppMap 升级过程中与旧版本并行运行的新版本
+	upgradeAppMap map[string]*AppStatusInfo
+	// jobMap 以定时任务方式运行的应用
+	jobMap map[string]*appJob
 }
 
 // newAppRunMgr 创建一个app管理器
 func newAppRunMgr() *appRunMgr {
 	return &appRunMgr{
-		startAppMap: make(map[string]*AppStatusInfo),
+		startAppMap:   make(map[string]*AppStatusInfo),
+		upgradeAppMap: make(map[string]*AppStatusInfo),
+		jobMap:        make(map[string]*appJob),
 	}
 }
 
 // NowStartNum 现在启动的数量
 func (a *appRunMgr) NowStartNum() int {
-	return len(a.startAppMap)
+	return len(a.startAppMap) + len(a.jobMap)
 }
 
 // QueryStartAppInfo 查询App启动信息
@@ -55,6 +64,8 @@ func (a *appRunMgr) QueryStartAppInfo(appName string) ([]byte, error) {
 		return nil, errors.New("app未启动")
 	}
 	appStatusInfo.convertPluginsOutPut()
+	appStatusInfo.convertPluginsStatus()
+	appStatusInfo.Events, _ = QueryAppEvents(appStatusInfo.Name, time.Time{}, time.Time{}, appEventRecentLimit)
 	marshal, _ := json.Marshal(appStatusInfo)
 	return marshal, nil
 }
@@ -81,9 +92,12 @@ func (a *appRunMgr) QueryStartAppPluginInfo(appName string, appPluginName string
 			"sha1": plugin.Sha1,
 		}
 
-		buffer := appStatusInfo.pluginOutPutBuffer[plugin.Name]
-		if buffer != nil && buffer.Len() > 0 {
-			returnData["output"] = buffer.Bytes()
+		if output := appStatusInfo.pluginOutputBytes(plugin.Name); len(output) > 0 {
+			returnData["output"] = output
+		}
+
+		if status := appStatusInfo.pluginStatusInfo(plugin.Name); status != nil {
+			returnData["status"] = status
 		}
 
 		endList = append(endList, returnData)
@@ -113,6 +127,9 @@ func (a *appRunMgr) StartAppList() []byte {
 func (a *appRunMgr) IsStart(appName string) bool {
 	a.Lock()
 	defer a.Unlock()
+	if _, ok := a.jobMap[appName]; ok {
+		return true
+	}
 	status, ok := a.startAppMap[appName]
 	if !ok || status.isClose {
 		return false
@@ -151,14 +168,42 @@ func (a *appRunMgr) StopAppAndVersion(name, version string) error {
 
 // StopApp 停止app
 func (a *appRunMgr) StopApp(appName string) error {
-	a.Lock()
-	defer a.Unlock()
 	defer func() { recover() }()
+	a.Lock()
+	if job, ok := a.jobMap[appName]; ok {
+		defer a.Unlock()
+		return a.stopJob(job)
+	}
+
 	info, ok := a.startAppMap[appName]
 	if !ok {
+		a.Unlock()
 		return errors.New("app未启动")
 	}
 
+	if upgradeInfo, ok := a.upgradeAppMap[appName]; ok {
+		a.settingErrStatus("升级被取消", upgradeInfo, appRunErrTypeData)
+		delete(a.upgradeAppMap, appName)
+	}
+
+	// 停止插件可能运行较长时间, 运行期间不持有管理器锁, 已在停止中时直接结束应用
+	if a.markStopping(info) {
+		a.Unlock()
+		a.runStopPlugins(info)
+		a.Lock()
+	}
+	defer a.Unlock()
+
+	if a.startAppMap[appName] != info {
+		return nil
+	}
+
+	info.closeLock.Lock()
+	if !info.isClose {
+		info.stopByUser = true
+		info.recordEvent(vos.AppEventStopped, "用户停止")
+	}
+	info.closeLock.Unlock()
 	return db.GetDb().Transaction(func(tx *gorm.DB) error {
 		if err := tx.Model(&vos.DbAppStartInfo{}).Where(&vos.DbAppStartInfo{
 			Name:    info.Name,
@@ -182,9 +227,13 @@ func (a *appRunMgr) StartAppByPrevConfig() {
 		return
 	}
 
+	adopted := a.adoptRunningApps(allAppStartInfo)
+	if err := PruneJdkCache(); err != nil {
+		logrus.Error(err.Error())
+	}
 	if len(allAppStartInfo) > 0 {
 		for _, s := range allAppStartInfo {
-			if s.RunDir != "" {
+			if s.RunDir != "" && !adopted[s.Name] {
 				_ = os.RemoveAll(s.RunDir)
 			}
 		}
@@ -199,11 +248,23 @@ func (a *appRunMgr) StartAppByPrevConfig() {
 		return
 	}
 
+	for _, appStartInfo := range allAppStartInfo {
+		if len(appStartInfo.JobBytes) == 0 || adopted[appStartInfo.Name] {
+			continue
+		}
+		parseStartInfoBytes(appStartInfo)
+		_ = a.StartApp(appStartInfo)
+	}
+
 	if len(appStartInfos) == 0 {
 		return
 	}
 
 	for _, appStartInfo := range appStartInfos {
+		if len(appStartInfo.JobBytes) > 0 || adopted[appStartInfo.Name] {
+			continue
+		}
+		parseStartInfoBytes(appStartInfo)
 		_ = a.StartApp(appStartInfo)
 	}
 
@@ -223,6 +284,7 @@ func (a *appRunMgr) RestartApp(appName string) error {
 	}).First(&appStartInfo).Error; err != nil {
 		return errors.New("未识别的应用")
 	}
+	parseStartInfoBytes(appStartInfo)
 	if err := a.StopApp(appName); err != nil {
 		return err
 	}
@@ -263,6 +325,18 @@ func (a *appRunMgr) StartApp(appStartInfo *vos.DbAppStartInfo) (returnErr error)
 		return errors.New("应用已经启动, 请勿重复启动")
 	}
 
+	if appStartInfo.Job != nil {
+		return a.startJob(appStartInfo)
+	}
+
+	_, err := a.startApp(appStartInfo, appStartModeNormal)
+	return err
+}
+
+// startApp 启动App, 非普通启动模式下不保存启动信息也不跟随重启策略
+func (a *appRunMgr) startApp(appStartInfo *vos.DbAppStartInfo, startMode appStartMode) (statusInfo *AppStatusInfo, returnErr error) {
+	javaInfo := resolveStartJava(appStartInfo)
+
 	a.Lock()
 	defer a.Unlock()
 	defer func() {
@@ -277,7 +351,7 @@ func (a *appRunMgr) StartApp(appStartInfo *vos.DbAppStartInfo) (returnErr error)
 		if err := db.GetDb().Where(&vos.DbJdkInfo{
 			Name: appStartInfo.JdkPackName,
 		}).First(&appStartInfo.JdkPackInfo).Error; err != nil {
-			return errors.New("未识别的包内jdk名称")
+			return nil, errors.New("未识别的包内jdk名称")
 		}
 	}
 
@@ -286,21 +360,21 @@ func (a *appRunMgr) StartApp(appStartInfo *vos.DbAppStartInfo) (returnErr error)
 	if err := settingModel.Where(&vos.DbSetting{
 		Name: consts.DbSettingRunDir,
 	}).First(&settingRunDir).Error; err != nil {
-		return errors.New("获取运行目录失败")
+		return nil, errors.New("获取运行目录失败")
 	}
 
 	settingLogDir := &vos.DbSetting{}
 	if err := settingModel.Where(&vos.DbSetting{
 		Name: consts.DbSettingLogDir,
 	}).First(&settingLogDir).Error; err != nil {
-		return errors.New("获取日志目录失败")
+		return nil, errors.New("获取日志目录失败")
 	}
 
 	appInfo := &vos.DbAppInfo{}
 	if err := db.GetDb().Model(&vos.DbAppInfo{}).Where(&vos.DbAppInfo{
 		Name: appStartInfo.Name,
 	}).First(&appInfo).Error; err != nil || appInfo.Name == "" {
-		return errors.New("未查询到相关app信息")
+		return nil, errors.New("未查询到相关app信息")
 	}
 
 	appVersion := &vos.DbAppVersionInfo{}
@@ -308,18 +382,19 @@ func (a *appRunMgr) StartApp(appStartInfo *vos.DbAppStartInfo) (returnErr error)
 		Name:    appStartInfo.Version,
 		AppName: appInfo.Name,
 	}).First(&appVersion).Error; err != nil && appVersion.Name == "" {
-		return errors.New("获取要启动的版本名称失败")
+		return nil, errors.New("获取要启动的版本名称失败")
 	}
 
 	srcStartInfo := &vos.DbAppStartInfo{}
-	if err := db.GetDb().Model(&vos.DbAppStartInfo{
+	if err := db.GetDb().Model(&vos.DbAppStartInfo{}).Where(&vos.DbAppStartInfo{
 		Name:    appInfo.Name,
 		Version: appVersion.Name,
 	}).First(&srcStartInfo).Error; err != nil && err != gorm.ErrRecordNotFound {
-		return errors.New("查询数据信息失败")
+		return nil, errors.New("查询数据信息失败")
 	}
 
-	if srcStartInfo.RunDir != "" {
+	adoptProcess := appStartInfo.AdoptProcess
+	if srcStartInfo.RunDir != "" && adoptProcess == nil {
 		_ = os.RemoveAll(srcStartInfo.RunDir)
 	}
 
@@ -328,20 +403,25 @@ func (a *appRunMgr) StartApp(appStartInfo *vos.DbAppStartInfo) (returnErr error)
 		AppName:    appInfo.Name,
 		AppVersion: appVersion.Name,
 	}).Find(&plugins).Error; err != nil && err != gorm.ErrRecordNotFound {
-		return errors.New("查询应用版本信息失败")
+		return nil, errors.New("查询应用版本信息失败")
 	}
 
-	isHavePluginConfig := false
-	if len(appStartInfo.PluginEnvConfig) > 0 {
-		appStartInfo.PluginEnvConfigBytes, _ = json.Marshal(appStartInfo.PluginEnvConfigBytes)
-		isHavePluginConfig = true
+	isHavePluginConfig := len(appStartInfo.PluginEnvConfig) > 0
+
+	if selection := appStartInfo.PluginSelection; selection != nil {
+		for _, prefix := range append(append([]string{}, selection.Allow...), selection.Deny...) {
+			if prefix == "" {
+				return nil, errors.New("插件选择中的名称前缀不能为空")
+			}
+		}
 	}
 
+	var skippedPlugins []*SkippedPluginInfo
 	blockSize := md5.Size + sha1.Size
 	pluginDataFlagLen := len(appVersion.Plugins)
 	if pluginDataFlagLen > 0 {
 		if pluginDataFlagLen%blockSize != 0 {
-			return errors.New("解析插件信息失败, 数据可能已被篡改")
+			return nil, errors.New("解析插件信息失败, 数据可能已被篡改")
 		}
 		appVersion.PluginInfo = make([]*vos.DbAppPlugin, 0, pluginDataFlagLen/blockSize)
 		for i := 0; i < pluginDataFlagLen; i += blockSize {
@@ -355,17 +435,31 @@ func (a *appRunMgr) StartApp(appStartInfo *vos.DbAppStartInfo) (returnErr error)
 				AppName:    appInfo.Name,
 				AppVersion: appVersion.Name,
 			}).First(&plugin).Error; err != nil {
-				return errors.New("获取插件信息失败")
+				return nil, errors.New("获取插件信息失败")
 			}
 
 			if !utils.PubKeyVerifySign(consts.CaPubKey, plugin.Src(), plugin.Sign) {
-				return errors.New("插件已被篡改, 请尝试重新导入应用")
+				recordAppEvent(appInfo.Name, appVersion.Name, vos.AppEventSignFailed, "插件["+plugin.Name+"]签名校验失败")
+				return nil, errors.New("插件已被篡改, 请尝试重新导入应用")
+			}
+
+			if !appStartInfo.PluginSelection.Selected(plugin.Name) {
+				skippedPlugins = append(skippedPlugins, &SkippedPluginInfo{
+					Name: plugin.Name,
+					Desc: plugin.Desc,
+					Type: plugin.Type,
+				})
+				continue
 			}
 
 			if len(plugin.EnvConfigBytes) > 0 {
 				_ = json.Unmarshal(plugin.EnvConfigBytes, &plugin.EnvConfig)
 			}
 
+			if len(plugin.RunPolicyBytes) > 0 {
+				_ = json.Unmarshal(plugin.RunPolicyBytes, &plugin.RunPolicy)
+			}
+
 			if len(plugin.EnvConfig) > 0 {
 				for pi := range plugin.EnvConfig {
 					p := plugin.EnvConfig[pi]
@@ -394,10 +488,11 @@ func (a *appRunMgr) StartApp(appStartInfo *vos.DbAppStartInfo) (returnErr error)
 	}
 
 	if ok := utils.PubKeyVerifySign(consts.CaPubKey, appVersion.SignSrc(), appVersion.Sign); !ok {
-		return errors.New("数据可能已被篡改，请您重新导入进行尝试")
+		recordAppEvent(appInfo.Name, appVersion.Name, vos.AppEventSignFailed, "应用版本签名校验失败")
+		return nil, errors.New("数据可能已被篡改，请您重新导入进行尝试")
 	}
 
-	return db.GetDb().Transaction(func(tx *gorm.DB) error {
+	returnErr = db.GetDb().Transaction(func(tx *gorm.DB) error {
 		appVersionModel := tx.Model(&vos.DbAppVersionInfo{})
 
 		if appStartInfo.Version == "" {
@@ -452,13 +547,15 @@ func (a *appRunMgr) StartApp(appStartInfo *vos.DbAppStartInfo) (returnErr error)
 
 		}
 
-		appInfo.CurrentVersion = appVersion.Name
-		if err := tx.Model(&vos.DbAppInfo{}).Where(&vos.DbAppInfo{
-			Name: appInfo.Name,
-		}).Update(&vos.DbAppInfo{
-			CurrentVersion: appVersion.Name,
-		}).Error; err != nil {
-			return errors.New("更新应用信息失败")
+		if startMode == appStartModeNormal {
+			appInfo.CurrentVersion = appVersion.Name
+			if err := tx.Model(&vos.DbAppInfo{}).Where(&vos.DbAppInfo{
+				Name: appInfo.Name,
+			}).Update(&vos.DbAppInfo{
+				CurrentVersion: appVersion.Name,
+			}).Error; err != nil {
+				return errors.New("更新应用信息失败")
+			}
 		}
 
 		if err := appVersionModel.Where(&vos.DbAppVersionInfo{
@@ -468,9 +565,58 @@ func (a *appRunMgr) StartApp(appStartInfo *vos.DbAppStartInfo) (returnErr error)
 			return errors.New("更新应用配置失败")
 		}
 
-		javaCmd := "java"
-		if appStartInfo.JdkPath != "" {
-			javaCmd = appStartInfo.JdkPath
+		credential, err := resolveRunCredential(appStartInfo)
+		if err != nil {
+			return err
+		}
+
+		if err = checkSandboxConfig(appStartInfo.Sandbox); err != nil {
+			return err
+		}
+
+		if err = checkVolumeConfig(appStartInfo.Volumes); err != nil {
+			return err
+		}
+
+		readiness, err := checkReadinessConfig(appStartInfo.Readiness)
+		if err != nil {
+			return err
+		}
+
+		if err = checkJvmOptions(appStartInfo, appVersion.ExecType == vos.AppExecTypeCmd); err != nil {
+			return err
+		}
+
+		for k, policy := range appStartInfo.PluginRunPolicy {
+			if err = CheckPluginRunPolicy(policy); err != nil {
+				return errors.New("插件 [" + k + "] " + err.Error())
+			}
+		}
+
+		var ports []*vos.AppPortConfig
+		if adoptProcess != nil {
+			if len(adoptProcess.PortsBytes) > 0 {
+				_ = json.Unmarshal(adoptProcess.PortsBytes, &ports)
+			}
+		} else if ports, err = a.allocatePorts(appStartInfo); err != nil {
+			return err
+		}
+
+		if javaInfo.err != nil {
+			return javaInfo.err
+		}
+		javaCmd := javaInfo.javaCmd
+		javaMajor := javaInfo.major
+
+		// 包内jdk需解压后才能确定版本, 在启动时校验
+		if appVersion.ExecType != vos.AppExecTypeCmd && adoptProcess == nil && appStartInfo.JdkPackName == "" && hasJvmArgs(appStartInfo) {
+			if javaInfo.majorErr != nil {
+				return javaInfo.majorErr
+			}
+
+			if err = checkJvmVersion(appStartInfo, javaMajor); err != nil {
+				return err
+			}
 		}
 
 		//tmpRunDir, err := ioutil.TempDir(settingRunDir.Val, "appRun*")
@@ -478,9 +624,14 @@ func (a *appRunMgr) StartApp(appStartInfo *vos.DbAppStartInfo) (returnErr error)
 		//	return errors.New("创建运行目录失败")
 		//}
 		appStartInfo.RunDir = filepath.Join(settingRunDir.Val, appInfo.Name, appVersion.Name)
-		_ = os.RemoveAll(appStartInfo.RunDir)
-		if err := os.MkdirAll(appStartInfo.RunDir, 0777); err != nil {
-			return errors.New("创建运行目录失败")
+		if startMode == appStartModeJob {
+			appStartInfo.RunDir = filepath.Join(appStartInfo.RunDir, strconv.FormatInt(time.Now().UnixNano(), 10))
+		}
+		if adoptProcess == nil {
+			_ = os.RemoveAll(appStartInfo.RunDir)
+			if err := os.MkdirAll(appStartInfo.RunDir, 0777); err != nil {
+				return errors.New("创建运行目录失败")
+			}
 		}
 
 		appStartInfo.LogDir = settingLogDir.Val
@@ -490,87 +641,205 @@ func (a *appRunMgr) StartApp(appStartInfo *vos.DbAppStartInfo) (returnErr error)
 			}
 		}
 
-		if len(appStartInfo.EnvConfig) > 0 {
-			marshal, _ := json.Marshal(appStartInfo.EnvConfig)
-			appStartInfo.EnvConfigBytes = marshal
-		}
+		fillStartInfoBytes(appStartInfo)
 
 		appStartInfo.JdkArgsBytes = appVersion.JdkStartArgsBytes
 		if err := json.Unmarshal(appVersion.JdkStartArgsBytes, &appStartInfo.JdkArgs); err != nil {
 			return errors.New("转换java启动参数失败")
 		}
 
-		if len(appStartInfo.Args) > 0 {
-			marshal, _ := json.Marshal(appStartInfo.Args)
-			appStartInfo.ArgsBytes = marshal
+		statusInfo = &AppStatusInfo{
+			AppInfo:       appInfo,
+			StartArgs:     appStartInfo,
+			Name:          appInfo.Name,
+			Desc:          appInfo.Desc,
+			VersionStr:    appVersion.Name,
+			VersionInfo:   appVersion,
+			StartTime:     time.Now(),
+			JavaCmd:       javaCmd,
+			javaMajor:     javaMajor,
+			Status:        appRunStatusWaitRun,
+			exitChannel:   make(chan string, 1),
+			pluginsCmd:    make([]*exec.Cmd, 0, len(appVersion.PluginInfo)),
+			isClose:       false,
+			pluginOkChan:  make(chan bool, len(appVersion.PluginInfo)),
+			pluginOutputs: make(map[string]*pluginOutput),
+			pluginStatus:  make(map[string]*vos.AppPluginStatus),
+			runDir:        appStartInfo.RunDir,
+			startMode:     startMode,
+			credential:    credential,
+			runRootDir:    filepath.Clean(settingRunDir.Val),
+			Ports:         ports,
+			runId:         newRunId(appInfo.Name),
 		}
+		statusInfo.SkippedPlugins = skippedPlugins
 
-		if len(appStartInfo.CopyFiles) > 0 {
-			marshal, _ := json.Marshal(appStartInfo.CopyFiles)
-			appStartInfo.CopyFileBytes = marshal
+		if readiness != nil && startMode != appStartModeJob && adoptProcess == nil {
+			statusInfo.readiness = readiness
+			statusInfo.readyChan = make(chan struct{})
 		}
 
-		statusInfo := &AppStatusInfo{
-			AppInfo:            appInfo,
-			StartArgs:          appStartInfo,
-			Name:               appInfo.Name,
-			Desc:               appInfo.Desc,
-			VersionStr:         appVersion.Name,
-			VersionInfo:        appVersion,
-			StartTime:          time.Now(),
-			JavaCmd:            javaCmd,
-			Status:             appRunStatusWaitRun,
-			exitChannel:        make(chan string, 1),
-			pluginsCmd:         make([]*exec.Cmd, 0, len(appVersion.PluginInfo)),
-			isClose:            false,
-			pluginOkChan:       make(chan bool, len(appVersion.PluginInfo)),
-			pluginOutPutBuffer: make(map[string]*bytes.Buffer),
-			runDir:             appStartInfo.RunDir,
+		switch startMode {
+		case appStartModeNormal:
+			a.startAppMap[appStartInfo.Name] = statusInfo
+		case appStartModeUpgrade:
+			a.upgradeAppMap[appStartInfo.Name] = statusInfo
 		}
-
-		memArgs := make([]string, 0, 5)
-		if appStartInfo.Xmx != "" {
-			memArgs = append(memArgs, "-Xmx"+appStartInfo.Xmx)
+		if adoptProcess != nil {
+			statusInfo.runId = adoptProcess.RunId
+			if err := a.adoptAppExec(statusInfo, adoptProcess); err != nil {
+				return err
+			}
+			return a.saveStartInfo(tx, statusInfo.StartArgs)
 		}
 
-		if appStartInfo.Xms != "" {
-			memArgs = append(memArgs, "-Xms"+appStartInfo.Xms)
+		statusInfo.recordEvent(vos.AppEventStarting, "")
+		if err := a.startAppExec(statusInfo); err != nil {
+			if err == errAppContentDamaged {
+				statusInfo.recordEvent(vos.AppEventSignFailed, "运行文件摘要校验失败")
+			}
+			return err
 		}
 
-		if appStartInfo.Xmn != "" {
-			memArgs = append(memArgs, "-xmn"+appStartInfo.Xmn)
+		if startMode != appStartModeNormal {
+			return nil
 		}
+		return a.saveStartInfo(tx, statusInfo.StartArgs)
+	})
+	return statusInfo, returnErr
+}
 
-		if appStartInfo.PermSize != "" {
-			memArgs = append(memArgs, "-XX:PermSize="+appStartInfo.PermSize)
-		}
+// fillStartInfoBytes 将启动信息中的配置转换为存储格式
+func fillStartInfoBytes(appStartInfo *vos.DbAppStartInfo) {
+	if len(appStartInfo.EnvConfig) > 0 {
+		appStartInfo.EnvConfigBytes, _ = json.Marshal(appStartInfo.EnvConfig)
+	}
 
-		if appStartInfo.MaxPermSize != "" {
-			memArgs = append(memArgs, "-XX:MaxPermSize="+appStartInfo.MaxPermSize)
-		}
+	if len(appStartInfo.Args) > 0 {
+		appStartInfo.ArgsBytes, _ = json.Marshal(appStartInfo.Args)
+	}
 
-		if len(memArgs) > 0 {
-			appStartInfo.JdkArgs = append(memArgs, appStartInfo.JdkArgs...)
-		}
-		a.startAppMap[appStartInfo.Name] = statusInfo
-		if err := a.startAppExec(statusInfo); err != nil {
-			return err
-		}
+	if len(appStartInfo.CopyFiles) > 0 {
+		appStartInfo.CopyFileBytes, _ = json.Marshal(appStartInfo.CopyFiles)
+	}
 
-		appStartInfoModel := tx.Model(&vos.DbAppStartInfo{})
-		if err := appStartInfoModel.Where(&vos.DbAppStartInfo{
-			Name: appStartInfo.Name,
-		}).Delete(&vos.DbAppStartInfo{}).Error; err != nil {
-			return errors.New("删除缓存数据失败")
-		}
+	if len(appStartInfo.PluginEnvConfig) > 0 {
+		appStartInfo.PluginEnvConfigBytes, _ = json.Marshal(appStartInfo.PluginEnvConfig)
+	}
 
-		if err := appStartInfoModel.Create(&statusInfo.StartArgs).Error; err != nil {
-			return errors.New("保存配置缓存失败")
-		}
+	if len(appStartInfo.RunGroups) > 0 {
+		appStartInfo.RunGroupsBytes, _ = json.Marshal(appStartInfo.RunGroups)
+	}
 
-		return nil
-	})
+	if appStartInfo.Sandbox != nil {
+		appStartInfo.SandboxBytes, _ = json.Marshal(appStartInfo.Sandbox)
+	}
+
+	if len(appStartInfo.Ports) > 0 {
+		appStartInfo.PortsBytes, _ = json.Marshal(appStartInfo.Ports)
+	}
+
+	if len(appStartInfo.Volumes) > 0 {
+		appStartInfo.VolumesBytes, _ = json.Marshal(appStartInfo.Volumes)
+	}
+
+	if len(appStartInfo.ConfigBundles) > 0 {
+		appStartInfo.ConfigBundlesBytes, _ = json.Marshal(appStartInfo.ConfigBundles)
+	}
+
+	if appStartInfo.Job != nil {
+		appStartInfo.JobBytes, _ = json.Marshal(appStartInfo.Job)
+	}
+
+	if appStartInfo.Readiness != nil {
+		appStartInfo.ReadinessBytes, _ = json.Marshal(appStartInfo.Readiness)
+	}
+
+	if appStartInfo.JvmOptions != nil {
+		appStartInfo.JvmOptionsBytes, _ = json.Marshal(appStartInfo.JvmOptions)
+	}
+
+	if len(appStartInfo.PluginRunPolicy) > 0 {
+		appStartInfo.PluginRunPolicyBytes, _ = json.Marshal(appStartInfo.PluginRunPolicy)
+	}
+
+	if appStartInfo.PluginSelection != nil {
+		appStartInfo.PluginSelectionBytes, _ = json.Marshal(appStartInfo.PluginSelection)
+	}
+}
+
+// parseStartInfoBytes 将存储格式的启动信息还原
+func parseStartInfoBytes(appStartInfo *vos.DbAppStartInfo) {
+	if len(appStartInfo.EnvConfigBytes) > 0 {
+		_ = json.Unmarshal(appStartInfo.EnvConfigBytes, &appStartInfo.EnvConfig)
+	}
+
+	if len(appStartInfo.ArgsBytes) > 0 {
+		_ = json.Unmarshal(appStartInfo.ArgsBytes, &appStartInfo.Args)
+	}
+
+	if len(appStartInfo.CopyFileBytes) > 0 {
+		_ = json.Unmarshal(appStartInfo.CopyFileBytes, &appStartInfo.CopyFiles)
+	}
+
+	if len(appStartInfo.PluginEnvConfigBytes) > 0 {
+		_ = json.Unmarshal(appStartInfo.PluginEnvConfigBytes, &appStartInfo.PluginEnvConfig)
+	}
+
+	if len(appStartInfo.RunGroupsBytes) > 0 {
+		_ = json.Unmarshal(appStartInfo.RunGroupsBytes, &appStartInfo.RunGroups)
+	}
+
+	if len(appStartInfo.SandboxBytes) > 0 {
+		_ = json.Unmarshal(appStartInfo.SandboxBytes, &appStartInfo.Sandbox)
+	}
+
+	if len(appStartInfo.PortsBytes) > 0 {
+		_ = json.Unmarshal(appStartInfo.PortsBytes, &appStartInfo.Ports)
+	}
 
+	if len(appStartInfo.VolumesBytes) > 0 {
+		_ = json.Unmarshal(appStartInfo.VolumesBytes, &appStartInfo.Volumes)
+	}
+
+	if len(appStartInfo.ConfigBundlesBytes) > 0 {
+		_ = json.Unmarshal(appStartInfo.ConfigBundlesBytes, &appStartInfo.ConfigBundles)
+	}
+
+	if len(appStartInfo.JobBytes) > 0 {
+		_ = json.Unmarshal(appStartInfo.JobBytes, &appStartInfo.Job)
+	}
+
+	if len(appStartInfo.ReadinessBytes) > 0 {
+		_ = json.Unmarshal(appStartInfo.ReadinessBytes, &appStartInfo.Readiness)
+	}
+
+	if len(appStartInfo.JvmOptionsBytes) > 0 {
+		_ = json.Unmarshal(appStartInfo.JvmOptionsBytes, &appStartInfo.JvmOptions)
+	}
+
+	if len(appStartInfo.PluginRunPolicyBytes) > 0 {
+		_ = json.Unmarshal(appStartInfo.PluginRunPolicyBytes, &appStartInfo.PluginRunPolicy)
+	}
+
+	if len(appStartInfo.PluginSelectionBytes) > 0 {
+		_ = json.Unmarshal(appStartInfo.PluginSelectionBytes, &appStartInfo.PluginSelection)
+	}
+}
+
+// saveStartInfo 保存启动信息, 替换同名应用原有的启动信息
+func (a *appRunMgr) saveStartInfo(tx *gorm.DB, appStartInfo *vos.DbAppStartInfo) error {
+	appStartInfoModel := tx.Model(&vos.DbAppStartInfo{})
+	if err := appStartInfoModel.Where(&vos.DbAppStartInfo{
+		Name: appStartInfo.Name,
+	}).Delete(&vos.DbAppStartInfo{}).Error; err != nil {
+		return errors.New("删除缓存数据失败")
+	}
+
+	if err := appStartInfoModel.Create(&appStartInfo).Error; err != nil {
+		return errors.New("保存配置缓存失败")
+	}
+	return nil
 }
 
 func (a *appRunMgr) startAppExec(appStatusInfo *AppStatusInfo) error {
@@ -581,8 +850,14 @@ func (a *appRunMgr) startAppExec(appStatusInfo *AppStatusInfo) error {
 		return errors.New(err.Error())
 	}
 
+	isCmd := appStatusInfo.VersionInfo.ExecType == vos.AppExecTypeCmd
 	appExecName := "run"
-	if appStatusInfo.StartArgs.SaveAppSuffix {
+	switch {
+	case isCmd && appStatusInfo.VersionInfo.Entrypoint != "":
+		appExecName = ".run.tar.gz"
+	case isCmd:
+		appExecName = utils.PathAddSuffix(appExecName)
+	case appStatusInfo.StartArgs.SaveAppSuffix:
 		appExecName += ".jar"
 	}
 
@@ -603,7 +878,7 @@ func (a *appRunMgr) startAppExec(appStatusInfo *AppStatusInfo) error {
 
 	if bytes.Compare(md5Sum, appStatusInfo.VersionInfo.ContentMd5) != 0 {
 		a.settingErrStatus("", appStatusInfo, appRunErrTypeData)
-		return errors.New("文件已被损坏")
+		return errAppContentDamaged
 	}
 
 	sha1Sum, err := utils.CalcSha1(contentPath)
@@ -612,7 +887,12 @@ func (a *appRunMgr) startAppExec(appStatusInfo *AppStatusInfo) error {
 	}
 
 	if bytes.Compare(sha1Sum, appStatusInfo.VersionInfo.ContentSha1) != 0 {
-		return errors.New("文件已被损坏")
+		return errAppContentDamaged
+	}
+
+	if isCmd {
+		go a.startRun(contentPath, appStatusInfo, nil)
+		return nil
 	}
 
 	jarPass := appStatusInfo.VersionInfo.JarPass
@@ -657,11 +937,11 @@ func (a *appRunMgr) startAppExec(appStatusInfo *AppStatusInfo) error {
 	}
 
 	if bytes.Compare(md5Sum, xjarMd5) != 0 {
-		return errors.New("文件已被损坏")
+		return errAppContentDamaged
 	}
 
 	if bytes.Compare(sha1Sum, xjarSha1) != 0 {
-		return errors.New("文件已被损坏")
+		return errAppContentDamaged
 	}
 
 	runKey := bytes.Join([][]byte{
@@ -699,6 +979,13 @@ func (a *appRunMgr) settingErrStatus(errMsg string, appStatusInfo *AppStatusInfo
 	appStatusInfo.HaveErr = true
 	appStatusInfo.ErrMsg = errMsg
 	appStatusInfo.isClose = true
+	if !appStatusInfo.stopByUser {
+		if errType == appRunErrTypePlugin {
+			appStatusInfo.recordEvent(vos.AppEventPluginFailed, errMsg)
+		} else {
+			appStatusInfo.recordEvent(vos.AppEventExited, errMsg)
+		}
+	}
 	a.closeLogs(appStatusInfo.logCloser)
 	a.closePluginOkChan(appStatusInfo)
 	a.settingRestart(appStatusInfo, errType)
@@ -706,11 +993,35 @@ func (a *appRunMgr) settingErrStatus(errMsg string, appStatusInfo *AppStatusInfo
 
 }
 
+// settingFinishStatus 设置正常结束状态, 用于定时任务运行成功退出
+func (a *appRunMgr) settingFinishStatus(appStatusInfo *AppStatusInfo) {
+	appStatusInfo.closeLock.Lock()
+	defer appStatusInfo.closeLock.Unlock()
+	defer os.RemoveAll(appStatusInfo.runDir)
+	if len(appStatusInfo.pluginsCmd) > 0 {
+		for _, pluginCmd := range appStatusInfo.pluginsCmd {
+			if pluginCmd != nil && pluginCmd.Process != nil {
+				_ = pluginCmd.Process.Kill()
+			}
+		}
+	}
+
+	if appStatusInfo.isClose {
+		return
+	}
+	appStatusInfo.Status = appRunStatusFinish
+	appStatusInfo.isClose = true
+	appStatusInfo.recordEvent(vos.AppEventExited, "运行结束")
+	a.closeLogs(appStatusInfo.logCloser)
+	a.closePluginOkChan(appStatusInfo)
+	close(appStatusInfo.exitChannel)
+}
+
 // settingRestart 设置重启
 func (a *appRunMgr) settingRestart(appStatusInfo *AppStatusInfo, errType appRunErrType) {
 	appStatusInfo.IsRestart = false
 	restartMode := appStatusInfo.StartArgs.Restart
-	if errType == appRunErrTypeData || restartMode == vos.AppRestartTypeErrorAuto {
+	if appStatusInfo.startMode != appStartModeNormal || appStatusInfo.isStopping || errType == appRunErrTypeData || restartMode == vos.AppRestartTypeErrorAuto {
 		return
 	}
 
@@ -733,6 +1044,7 @@ func (a *appRunMgr) settingRestart(appStatusInfo *AppStatusInfo, errType appRunE
 		appStatusInfo.IsRestart = true
 		appStatusInfo.stopRestartChannel = make(chan bool, 1)
 		appStatusInfo.Status = appRunStatusWaitRestart
+		appStatusInfo.recordEvent(vos.AppEventRestartScheduled, "60秒后重启")
 		go func() {
 			timeOut := time.NewTimer(60 * time.Second)
 			defer timeOut.Stop()
@@ -818,15 +1130,13 @@ func (a *appRunMgr) startRun(contentPath string, appStatusInfo *AppStatusInfo, r
 		abs      string
 		fd       os.FileInfo
 		javaPath string
-
-		uid int
-		gid int
 	)
 
 	runDir := filepath.Dir(contentPath)
+	isCmd := appStatusInfo.VersionInfo.ExecType == vos.AppExecTypeCmd
 
-	if appStatusInfo.StartArgs.JdkPackInfo != nil {
-		javaPath, err = a.parsePackJdk(runDir, appStatusInfo.StartArgs.JdkPackInfo)
+	if !isCmd && appStatusInfo.StartArgs.JdkPackInfo != nil {
+		javaPath, err = packJdkCache.acquire(appStatusInfo, appStatusInfo.StartArgs.JdkPackInfo)
 		if err != nil {
 			a.settingErrStatus(err.Error(), appStatusInfo, appRunErrTypeData)
 			return
@@ -834,14 +1144,28 @@ func (a *appRunMgr) startRun(contentPath string, appStatusInfo *AppStatusInfo, r
 		appStatusInfo.JavaCmd = javaPath
 	}
 
+	started := false
+	defer func() {
+		if !started {
+			packJdkCache.release(appStatusInfo)
+		}
+	}()
+
 	//logsWriter := os.Stdout
 	logsWriter, err := newAppLogs(appStatusInfo.StartArgs.Name, appStatusInfo.StartArgs.Version, appStatusInfo.StartArgs.LogDir)
 	if err != nil {
 		a.settingErrStatus(err.Error(), appStatusInfo, appRunErrTypeData)
 		return
 	}
+	logsWriter.lineHandler = appStatusInfo.handleLogLine
+	logsWriter.outputHandler = appStatusInfo.handleAttachOutput
 	appStatusInfo.logCloser = logsWriter
 
+	if err = appStatusInfo.credential.chown(filepath.Join(appStatusInfo.StartArgs.LogDir, appStatusInfo.StartArgs.Name, appStatusInfo.StartArgs.Version)); err != nil {
+		a.settingErrStatus(err.Error(), appStatusInfo, appRunErrTypeData)
+		return
+	}
+
 	copyFiles := appStatusInfo.StartArgs.CopyFiles
 	if len(copyFiles) > 0 {
 		for _, fileName := range copyFiles {
@@ -871,6 +1195,38 @@ func (a *appRunMgr) startRun(contentPath string, appStatusInfo *AppStatusInfo, r
 		}
 	}
 
+	execPath := contentPath
+	if isCmd {
+		if execPath, err = a.prepareCmdExec(contentPath, appStatusInfo); err != nil {
+			a.settingErrStatus(err.Error(), appStatusInfo, appRunErrTypeData)
+			return
+		}
+		appStatusInfo.JavaCmd = execPath
+	}
+
+	if err = a.materializeConfigBundles(appStatusInfo); err != nil {
+		a.settingErrStatus(err.Error(), appStatusInfo, appRunErrTypeData)
+		return
+	}
+
+	if err = a.linkVolumes(appStatusInfo); err != nil {
+		a.settingErrStatus(err.Error(), appStatusInfo, appRunErrTypeData)
+		return
+	}
+
+	if err = appStatusInfo.credential.chown(runDir); err != nil {
+		a.settingErrStatus(err.Error(), appStatusInfo, appRunErrTypeData)
+		return
+	}
+
+	var jvmArgs []string
+	if !isCmd {
+		if jvmArgs, err = renderJvmArgs(appStatusInfo); err != nil {
+			a.settingErrStatus(err.Error(), appStatusInfo, appRunErrTypeData)
+			return
+		}
+	}
+
 	pluginTotalLen := len(appStatusInfo.VersionInfo.PluginInfo)
 	beforePlugins := make([]*vos.DbAppPlugin, 0, pluginTotalLen)
 	afterPlugins := make([]*vos.DbAppPlugin, 0, pluginTotalLen)
@@ -886,12 +1242,17 @@ func (a *appRunMgr) startRun(contentPath string, appStatusInfo *AppStatusInfo, r
 			beforePlugins = append(beforePlugins, plugin)
 		case vos.AppPluginTypeAfter:
 			afterPlugins = append(afterPlugins, plugin)
+		case vos.AppPluginTypePreStop, vos.AppPluginTypeStop, vos.AppPluginTypePostStop:
 		default:
 			a.settingErrStatus("无法处理的插件类型", appStatusInfo, appRunErrTypeData)
 			return
 		}
 	}
 
+	appStatusInfo.setPluginsPending(beforePlugins)
+	appStatusInfo.setPluginsPending(listenerAndNormalPlugins)
+	appStatusInfo.setPluginsPending(afterPlugins)
+
 	beforePluginLen := len(beforePlugins)
 	if beforePluginLen > 0 {
 		for _, plugin := range beforePlugins {
@@ -924,38 +1285,70 @@ func (a *appRunMgr) startRun(contentPath string, appStatusInfo *AppStatusInfo, r
 			if c.Val == "" {
 				c.Val = c.DefaultVal
 			}
-			env = append(env, c.Name+"="+c.Val)
+
+			val, err := resolveSecretRefs(c.Val)
+			if err != nil {
+				a.settingErrStatus(err.Error(), appStatusInfo, appRunErrTypeData)
+				return
+			}
+			env = append(env, c.Name+"="+val)
 		}
 	}
 
 	env = append(env, "now_os="+runtime.GOOS)
 	env = append(env, "now_arch="+runtime.GOARCH)
 	env = append(env, "run_dir="+runDir)
-	cmdArgs := make([]string, 0, len(appStatusInfo.StartArgs.JdkArgs)+len(appStatusInfo.StartArgs.Args)+1)
-	cmdArgs = append(cmdArgs, appStatusInfo.StartArgs.JdkArgs...)
-	cmdArgs = append(cmdArgs, contentPath)
-	cmdArgs = append(cmdArgs, appStatusInfo.StartArgs.Args...)
-
-	cmd := exec.Command(appStatusInfo.JavaCmd, cmdArgs...)
-	cmd.Stdin = bytes.NewReader(runKey)
+	env = append(env, appStatusInfo.portEnv()...)
+	env = append(env, appStatusInfo.runIdEnv())
+	var cmd *exec.Cmd
+	if isCmd {
+		cmd = exec.Command(execPath, appStatusInfo.StartArgs.Args...)
+	} else {
+		cmdArgs := make([]string, 0, len(jvmArgs)+len(appStatusInfo.StartArgs.JdkArgs)+len(appStatusInfo.StartArgs.Args)+1)
+		cmdArgs = append(cmdArgs, jvmArgs...)
+		cmdArgs = append(cmdArgs, appStatusInfo.StartArgs.JdkArgs...)
+		cmdArgs = append(cmdArgs, contentPath)
+		cmdArgs = append(cmdArgs, appStatusInfo.StartArgs.Args...)
+
+		cmd = exec.Command(appStatusInfo.JavaCmd, cmdArgs...)
+		cmd.Stdin = bytes.NewReader(runKey)
+	}
 	if runtime.GOOS == "linux" {
-		uid, err = strconv.Atoi(consts.User.Uid)
-		gid, err = strconv.Atoi(consts.User.Gid)
-		if err != nil {
-			a.settingErrStatus("获取用户["+consts.User.Username+"]失败", appStatusInfo, appRunErrTypePlugin)
-			return
-		}
-		cmd.SysProcAttr = &syscall.SysProcAttr{
-			Credential: &syscall.Credential{
-				Uid: uint32(uid),
-				Gid: uint32(gid),
-			},
-		}
+		cmd.SysProcAttr = appStatusInfo.credential.sysProcAttr()
 	}
 	cmd.Stdout = logsWriter
 	cmd.Stderr = logsWriter
 	cmd.Dir = runDir
 	cmd.Env = env
+	appStatusInfo.CommandLine = append([]string{}, cmd.Args...)
+
+	var stdin io.WriteCloser
+	if appStatusInfo.StartArgs.OpenStdin {
+		cmd.Stdin = nil
+		if stdin, err = cmd.StdinPipe(); err != nil {
+			a.settingErrStatus("创建标准输入失败", appStatusInfo, appRunErrTypeData)
+			return
+		}
+	}
+
+	if appStatusInfo.StartArgs.Sandbox != nil {
+		if err = a.wrapSandboxCmd(cmd, appStatusInfo); err != nil {
+			a.settingErrStatus(err.Error(), appStatusInfo, appRunErrTypeData)
+			return
+		}
+	}
+	setProcessGroup(cmd)
+
+	var relay *appOutputRelay
+	if appStatusInfo.startMode != appStartModeJob {
+		if relay, err = startOutputRelay(appStatusInfo); err != nil {
+			logrus.Error("应用[" + appStatusInfo.Name + "]" + err.Error() + ", 输出直接写入日志")
+		} else if relay != nil {
+			cmd.Stdout = relay.writer
+			cmd.Stderr = relay.writer
+			go relay.tail(logsWriter)
+		}
+	}
 	appStatusInfo.runCmd = cmd
 	go func() {
 		msg := <-appStatusInfo.exitChannel
@@ -964,18 +1357,57 @@ func (a *appRunMgr) startRun(contentPath string, appStatusInfo *AppStatusInfo, r
 	}()
 
 	appStatusInfo.Status = appRunStatusRunner
-	if err = cmd.Start(); err != nil {
+	readinessWatch := appStatusInfo.newReadinessWatch()
+	if readinessWatch != nil {
+		appStatusInfo.Status = appRunStatusWaitReady
+	}
+	err = cmd.Start()
+	if relay != nil {
+		relay.closeWriter()
+	}
+	if err != nil {
+		if readinessWatch != nil {
+			readinessWatch.close()
+		}
 		//fmt.Println("程序结束3 => " + err.Error())
 		a.settingErrStatus("运行异常 => "+err.Error(), appStatusInfo, appRunErrTypeApp)
 		return
 	}
+	appStatusInfo.recordEvent(vos.AppEventRunning, "")
+	if readinessWatch != nil {
+		go a.waitReadiness(appStatusInfo, readinessWatch)
+	}
+	if relay != nil {
+		saveAppProcess(appStatusInfo.processRecord(relay))
+	}
+
+	if stdin != nil {
+		if _, err = stdin.Write(runKey); err != nil {
+			a.settingErrStatus("写入运行密钥失败", appStatusInfo, appRunErrTypeApp)
+			return
+		}
+		appStatusInfo.setAttachStdin(stdin)
+	}
 
+	started = true
 	defer func() {
 		defer os.RemoveAll(appStatusInfo.runDir)
-		if err = cmd.Wait(); err != nil {
+		err = cmd.Wait()
+		packJdkCache.release(appStatusInfo)
+		if cmd.ProcessState != nil {
+			appStatusInfo.ExitCode = cmd.ProcessState.ExitCode()
+		}
+		if relay != nil {
+			relay.waitDrained(logRelayDrainTimeout)
+			removeAppProcess(appStatusInfo.runId)
+		}
+		if err != nil {
 			a.settingErrStatus("运行异常 => "+err.Error(), appStatusInfo, appRunErrTypeApp)
 			return
 		}
+		if appStatusInfo.startMode == appStartModeJob {
+			a.settingFinishStatus(appStatusInfo)
+		}
 	}()
 
 	afterPluginLen := len(afterPlugins)
@@ -991,41 +1423,22 @@ func (a *appRunMgr) startRun(contentPath string, appStatusInfo *AppStatusInfo, r
 	//fmt.Println("程序结束2")
 }
 
-func (a *appRunMgr) parsePackJdk(runDir string, jdkInfo *vos.DbJdkInfo) (string, error) {
-	dir, err := utils.TmpDir()
-	if err != nil {
-		return "", errors.New("创建临时存储目录失败")
-	}
-	defer os.RemoveAll(dir)
-	if !utils.PubKeyVerifySign(consts.CaPubKey, jdkInfo.SignSrc(), jdkInfo.Sign) {
-		return "", errors.New("jdk已被篡改, 请重新导入然后再次尝试")
-	}
-
-	path, key, err := utils.Sm4DecryptContentPath(consts.CaPrivateKey, jdkInfo.Content)
-	if err != nil {
-		return "", errors.New("获取jdk文件路径失败")
-	}
-
-	tmpDecryptFile := filepath.Join(dir, "j")
-	file, err := os.OpenFile(path, os.O_RDONLY, 0666)
-	if err != nil {
-		return "", errors.New("打开jdk文件失败")
-	}
-	defer file.Close()
-	if err = utils.Sm4Decrypt2File(key, file, tmpDecryptFile); err != nil {
-		return "", errors.New("解析内部jdk失败")
+// prepareCmdExec 准备非java程序的可执行文件, 压缩包形式的程序解压到运行目录后返回入口文件
+func (a *appRunMgr) prepareCmdExec(contentPath string, appStatusInfo *AppStatusInfo) (string, error) {
+	execPath := contentPath
+	if entrypoint := appStatusInfo.VersionInfo.Entrypoint; entrypoint != "" {
+		runDir := filepath.Dir(contentPath)
+		if err := utils.DeCompressGzip(contentPath, runDir); err != nil {
+			return "", errors.New("解压程序文件失败")
+		}
+		_ = os.Remove(contentPath)
+		execPath = filepath.Join(runDir, filepath.FromSlash(entrypoint))
 	}
 
-	jdkSavePath := filepath.Join(runDir, "._j")
-	if err = utils.DeCompressGzip(tmpDecryptFile, jdkSavePath); err != nil {
-		return "", errors.New("解压包内jdk到运行目录失败")
+	if err := os.Chmod(execPath, 0777); err != nil {
+		return "", errors.New("更改程序执行权限失败")
 	}
-	javaPath := filepath.Join(jdkSavePath, "bin", "java")
-	if runtime.GOOS == "windows" {
-		javaPath += ".exe"
-	}
-	os.Chmod(javaPath, 0777)
-	return javaPath, nil
+	return execPath, nil
 }
 
 func (a *appRunMgr) startPlugin(plugin *vos.DbAppPlugin, appStatusInfo *AppStatusInfo) {
@@ -1048,11 +1461,6 @@ func (a *appRunMgr) startPlugin(plugin *vos.DbAppPlugin, appStatusInfo *AppStatu
 	//	appStatusInfo.pluginOkChan <- true
 	//}()
 
-	var (
-		uid int
-		gid int
-	)
-
 	if !utils.PubKeyVerifySign(consts.CaPubKey, plugin.Src(), plugin.Sign) {
 		a.settingErrStatus("插件已被损坏", appStatusInfo, appRunErrTypeData)
 		return
@@ -1063,9 +1471,16 @@ func (a *appRunMgr) startPlugin(plugin *vos.DbAppPlugin, appStatusInfo *AppStatu
 	env = append(env, "now_arch="+runtime.GOARCH)
 	env = append(env, "run_dir="+appStatusInfo.runDir)
 	env = append(env, "__cmd__=start")
+	env = append(env, appStatusInfo.portEnv()...)
+	env = append(env, appStatusInfo.runIdEnv())
 	if len(plugin.EnvConfig) > 0 {
 		for _, e := range plugin.EnvConfig {
-			env = append(env, e.Name+"="+e.Val)
+			val, err := resolveSecretRefs(e.Val)
+			if err != nil {
+				a.settingErrStatus(err.Error(), appStatusInfo, appRunErrTypeData)
+				return
+			}
+			env = append(env, e.Name+"="+val)
 		}
 	}
 
@@ -1122,31 +1537,24 @@ func (a *appRunMgr) startPlugin(plugin *vos.DbAppPlugin, appStatusInfo *AppStatu
 		return
 	}
 
-	buffer := &bytes.Buffer{}
+	if err = appStatusInfo.credential.chown(pluginDirs); err != nil {
+		a.settingErrStatus(err.Error(), appStatusInfo, appRunErrTypeData)
+		return
+	}
+
+	output := appStatusInfo.pluginOutput(pluginName)
 
 	command := exec.Command(pluginFileName)
 	appStatusInfo.pluginsCmd = append(appStatusInfo.pluginsCmd, command)
 	if runtime.GOOS == "linux" {
-		uid, err = strconv.Atoi(consts.User.Uid)
-		gid, err = strconv.Atoi(consts.User.Gid)
-		if err != nil {
-			a.settingErrStatus("获取用户["+consts.User.Username+"]失败", appStatusInfo, appRunErrTypePlugin)
-			return
-		}
-		command.SysProcAttr = &syscall.SysProcAttr{
-			Credential: &syscall.Credential{
-				Uid: uint32(uid),
-				Gid: uint32(gid),
-			},
-		}
+		command.SysProcAttr = appStatusInfo.credential.sysProcAttr()
 	}
 	command.Dir = pluginDirs
 	command.Env = env
-	command.Stdout = buffer
-
-	appStatusInfo.pluginOutPutBuffer[pluginName] = buffer
+	command.Stdout = output
+	command.Stderr = output
 
-	if err = command.Start(); err != nil {
+	if err = a.startPluginCmd(command, pluginName, appStatusInfo); err != nil {
 		a.settingErrStatus("插件("+pluginName+")启动失败 => "+err.Error(), appStatusInfo, appRunErrTypePlugin)
 		return
 	}
@@ -1155,12 +1563,12 @@ func (a *appRunMgr) startPlugin(plugin *vos.DbAppPlugin, appStatusInfo *AppStatu
 	switch plugin.Type {
 	case vos.AppPluginTypeListener:
 		errMsg := "监听插件(" + pluginName + ")提前退出"
-		if err = command.Wait(); err != nil {
+		if err = appStatusInfo.waitPluginCmd(command, pluginName); err != nil {
 			errMsg += " => " + err.Error()
 		}
 		a.settingErrStatus(errMsg, appStatusInfo, appRunErrTypePlugin)
 	case vos.AppPluginTypeNormal:
-		if err = command.Wait(); err != nil {
+		if err = appStatusInfo.waitPluginCmd(command, pluginName); err != nil {
 			a.settingErrStatus("插件("+pluginName+")运行失败 => "+err.Error(), appStatusInfo, appRunErrTypePlugin)
 		}
 	default:
@@ -1170,41 +1578,11 @@ func (a *appRunMgr) startPlugin(plugin *vos.DbAppPlugin, appStatusInfo *AppStatu
 
 }
 
-func (a *appRunMgr) startSyncPlugin(plugin *vos.DbAppPlugin, appStatusInfo *AppStatusInfo) (returnErr error) {
+// preparePluginCmd 校验并写出插件, 返回以指定命令运行插件的进程, 输出记录到插件输出中, 调用方需持有管理器锁
+func (a *appRunMgr) preparePluginCmd(plugin *vos.DbAppPlugin, appStatusInfo *AppStatusInfo, cmdName string) (*exec.Cmd, error) {
 	pluginName := plugin.Name
-	defer func() {
-		e := recover()
-		if e != nil {
-			switch err := e.(type) {
-			case error:
-				returnErr = errors.New(err.Error())
-			case string:
-				returnErr = errors.New(err)
-			default:
-				returnErr = errors.New("插件运行失败, 未知异常")
-			}
-		}
-	}()
-
-	var (
-		uid int
-		gid int
-	)
-
-	isUnlock := false
-	a.Lock()
-	defer func() {
-		if !isUnlock {
-			a.Unlock()
-		}
-	}()
-
-	if appStatusInfo.isClose {
-		return errors.New("应用已关闭")
-	}
-
 	if !utils.PubKeyVerifySign(consts.CaPubKey, plugin.Src(), plugin.Sign) {
-		return errors.New("插件已被损坏")
+		return nil, errors.New("插件已被损坏")
 	}
 
 	pluginDirs := filepath.Join(appStatusInfo.runDir, "p")
@@ -1212,80 +1590,72 @@ func (a *appRunMgr) startSyncPlugin(plugin *vos.DbAppPlugin, appStatusInfo *AppS
 
 	pluginSrcPath, sm4Key, err := decryptPath(plugin.Content)
 	if err != nil {
-		return errors.New("插件已被损坏, 请尝试重新导入")
+		return nil, errors.New("插件已被损坏, 请尝试重新导入")
 	}
 
 	file, err := os.OpenFile(pluginSrcPath, os.O_RDONLY, 0666)
 	if err != nil {
-		return errors.New("插件源文件打开失败")
+		return nil, errors.New("插件源文件打开失败")
 	}
 	defer file.Close()
 
 	pluginFileName := filepath.Join(pluginDirs, utils.PathAddSuffix(pluginName))
 	if err = utils.Sm4Decrypt2File(sm4Key, file, pluginFileName); err != nil {
-		return errors.New("写出插件信息失败")
+		return nil, errors.New("写出插件信息失败")
 	}
 
 	pMd5, err := utils.CalcMd5(pluginFileName)
 	if err != nil {
-		return errors.New("获取运行插件MD5摘要失败")
+		return nil, errors.New("获取运行插件MD5摘要失败")
 	}
 
 	pSha1, err := utils.CalcSha1(pluginFileName)
 	if err != nil {
-		return errors.New("获取运行插件SHA1摘要失败")
+		return nil, errors.New("获取运行插件SHA1摘要失败")
 	}
 
 	if !bytes.Equal(pSha1, plugin.Sha1) || !bytes.Equal(pMd5, plugin.Md5) {
-		return errors.New("插件数据已被篡改")
+		return nil, errors.New("插件数据已被篡改")
 	}
 
 	if err = os.Chmod(pluginFileName, 0777); err != nil {
-		return errors.New("更改插件权限失败")
+		return nil, errors.New("更改插件权限失败")
+	}
+
+	if err = appStatusInfo.credential.chown(pluginDirs); err != nil {
+		return nil, err
 	}
 
 	env := os.Environ()
 	env = append(env, "now_os="+runtime.GOOS)
-	env = append(env, "now_arch"+runtime.GOARCH)
-	env = append(env, "run_dir"+appStatusInfo.runDir)
-	env = append(env, "__cmd__=start")
+	env = append(env, "now_arch="+runtime.GOARCH)
+	env = append(env, "run_dir="+appStatusInfo.runDir)
+	env = append(env, "__cmd__="+cmdName)
+	env = append(env, appStatusInfo.portEnv()...)
+	env = append(env, appStatusInfo.runIdEnv())
 	if len(plugin.EnvConfig) > 0 {
 		for _, e := range plugin.EnvConfig {
-			env = append(env, e.Name+"="+e.Val)
+			val, err := resolveSecretRefs(e.Val)
+			if err != nil {
+				return nil, err
+			}
+			env = append(env, e.Name+"="+val)
 		}
 	}
 
-	buffer := &bytes.Buffer{}
+	output := appStatusInfo.pluginOutput(pluginName)
 
 	command := exec.Command(pluginFileName)
 	if runtime.GOOS == "linux" {
-		uid, err = strconv.Atoi(consts.User.Uid)
-		gid, err = strconv.Atoi(consts.User.Gid)
-		if err != nil {
-			a.settingErrStatus("获取用户["+consts.User.Username+"]失败", appStatusInfo, appRunErrTypePlugin)
-			return
-		}
-		command.SysProcAttr = &syscall.SysProcAttr{
-			Credential: &syscall.Credential{
-				Uid: uint32(uid),
-				Gid: uint32(gid),
-			},
-		}
+		command.SysProcAttr = appStatusInfo.credential.sysProcAttr()
 	}
+	// 使用单独的进程组, 超时时连同插件启动的子进程一起结束
+	setProcessGroup(command)
 	command.Dir = pluginDirs
 	command.Env = env
-	command.Stdout = buffer
-	command.Stderr = buffer
-
-	appStatusInfo.pluginOutPutBuffer[pluginName] = buffer
-	isUnlock = true
-	a.Unlock()
-
-	if err = command.Run(); err != nil {
-		return errors.New("插件(" + pluginName + ") 运行异常 =>" + err.Error())
-	}
-
-	return nil
+	command.Stdout = output
+	command.Stderr = output
+	return command, nil
 }
 
 func copyFile(src, dst string) error {
@@ -0,0 +1,129 @@
+package helper
+
+import (
+	"errors"
+	"io"
+)
+
+// attachOutputBufferSize 每个attach客户端缓存的输出块数量, 客户端读取过慢时丢弃新的输出
+const attachOutputBufferSize = 256
+
+// AppAttach 连接到运行中应用的控制台, 可以有多个查看者, 但同时只能有一个写入者
+type AppAttach struct {
+	statusInfo *AppStatusInfo
+	isWriter   bool
+	outputChan chan []byte
+}
+
+// AttachApp 连接到运行中的应用, isWriter为true时可以向应用的标准输入写入数据
+func (a *appRunMgr) AttachApp(appName string, isWriter bool) (*AppAttach, error) {
+	a.Lock()
+	statusInfo, ok := a.startAppMap[appName]
+	a.Unlock()
+	if !ok {
+		return nil, errors.New("app未启动")
+	}
+
+	if isWriter && !statusInfo.StartArgs.OpenStdin {
+		return nil, errors.New("应用未开启标准输入, 仅能以只读方式连接")
+	}
+
+	if isWriter && statusInfo.Adopted {
+		return nil, errors.New("服务重启后接管的应用无法写入标准输入, 仅能以只读方式连接")
+	}
+
+	statusInfo.closeLock.Lock()
+	isClose := statusInfo.isClose
+	statusInfo.closeLock.Unlock()
+	if isClose {
+		return nil, errors.New("应用已停止运行")
+	}
+
+	attach := &AppAttach{
+		statusInfo: statusInfo,
+		isWriter:   isWriter,
+		outputChan: make(chan []byte, attachOutputBufferSize),
+	}
+
+	statusInfo.attachLock.Lock()
+	defer statusInfo.attachLock.Unlock()
+	if isWriter {
+		if statusInfo.attachWriter != nil {
+			return nil, errors.New("已有其他客户端以写入方式连接")
+		}
+		statusInfo.attachWriter = attach
+	}
+	statusInfo.attachViewers = append(statusInfo.attachViewers, attach)
+	return attach, nil
+}
+
+// Output 应用的输出
+func (t *AppAttach) Output() <-chan []byte {
+	return t.outputChan
+}
+
+// Exit 应用退出时关闭
+func (t *AppAttach) Exit() <-chan string {
+	return t.statusInfo.exitChannel
+}
+
+// Write 向应用的标准输入写入数据
+func (t *AppAttach) Write(p []byte) error {
+	if !t.isWriter {
+		return errors.New("当前连接为只读方式")
+	}
+
+	t.statusInfo.attachLock.Lock()
+	stdin := t.statusInfo.attachStdin
+	t.statusInfo.attachLock.Unlock()
+	if stdin == nil {
+		return errors.New("应用尚未完成启动")
+	}
+
+	if _, err := stdin.Write(p); err != nil {
+		return errors.New("写入应用标准输入失败")
+	}
+	return nil
+}
+
+// Close 断开连接
+func (t *AppAttach) Close() {
+	statusInfo := t.statusInfo
+	statusInfo.attachLock.Lock()
+	defer statusInfo.attachLock.Unlock()
+	if statusInfo.attachWriter == t {
+		statusInfo.attachWriter = nil
+	}
+
+	for i, v := range statusInfo.attachViewers {
+		if v == t {
+			statusInfo.attachViewers = append(statusInfo.attachViewers[:i], statusInfo.attachViewers[i+1:]...)
+			return
+		}
+	}
+}
+
+// setAttachStdin 设置运行密钥写入完成后的标准输入
+func (a *AppStatusInfo) setAttachStdin(stdin io.WriteCloser) {
+	a.attachLock.Lock()
+	defer a.attachLock.Unlock()
+	a.attachStdin = stdin
+}
+
+// handleAttachOutput 将应用输出分发给所有attach客户端
+func (a *AppStatusInfo) handleAttachOutput(p []byte) {
+	a.attachLock.Lock()
+	defer a.attachLock.Unlock()
+	if len(a.attachViewers) == 0 {
+		return
+	}
+
+	output := make([]byte, len(p))
+	copy(output, p)
+	for _, v := range a.attachViewers {
+		select {
+		case v.outputChan <- output:
+		default:
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package helper
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"github.com/byzk-org/bypt-server/db"
+	"github.com/byzk-org/bypt-server/utils"
+	"github.com/byzk-org/bypt-server/vos"
+	"github.com/jinzhu/gorm"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// configBundleTemplateData 配置包模板的渲染数据
+type configBundleTemplateData struct {
+	App     string
+	Version string
+	RunDir  string
+	Env     map[string]string
+	Ports   map[string]int
+}
+
+// SaveConfigBundle 保存配置包, 返回新生成的版本
+func SaveConfigBundle(name, desc string, content []byte) (*vos.DbConfigBundle, error) {
+	if err := checkConfigBundleName(name); err != nil {
+		return nil, err
+	}
+
+	fileCount, size, err := checkConfigBundleContent(content)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &vos.DbConfigBundle{
+		Name:       name,
+		Desc:       desc,
+		Content:    content,
+		FileCount:  fileCount,
+		Size:       size,
+		CreateTime: time.Now(),
+	}
+
+	return bundle, db.GetDb().Transaction(func(tx *gorm.DB) error {
+		last := &vos.DbConfigBundle{}
+		if err := tx.Model(&vos.DbConfigBundle{}).Where(&vos.DbConfigBundle{
+			Name: name,
+		}).Order("revision desc").First(&last).Error; err != nil && err != gorm.ErrRecordNotFound {
+			return errors.New("查询配置包版本失败")
+		}
+
+		bundle.Revision = last.Revision + 1
+		bundle.Id = name + "-" + strconv.Itoa(bundle.Revision)
+		if err := tx.Create(bundle).Error; err != nil {
+			return errors.New("保存配置包失败")
+		}
+		return nil
+	})
+}
+
+// QueryConfigBundles 查询配置包版本列表, name为空时查询全部
+func QueryConfigBundles(name string) ([]*vos.DbConfigBundle, error) {
+	query := db.GetDb().Model(&vos.DbConfigBundle{})
+	if name != "" {
+		query = query.Where(&vos.DbConfigBundle{
+			Name: name,
+		})
+	}
+
+	bundles := make([]*vos.DbConfigBundle, 0)
+	if err := query.Order("name").Order("revision desc").Find(&bundles).Error; err != nil && err != gorm.ErrRecordNotFound {
+		return nil, errors.New("查询配置包失败")
+	}
+
+	for _, bundle := range bundles {
+		bundle.Content = nil
+	}
+	return bundles, nil
+}
+
+// GetConfigBundle 获取配置包, revision为0时获取最新版本
+func GetConfigBundle(name string, revision int) (*vos.DbConfigBundle, error) {
+	bundle := &vos.DbConfigBundle{}
+	if err := db.GetDb().Model(&vos.DbConfigBundle{}).Where(&vos.DbConfigBundle{
+		Name:     name,
+		Revision: revision,
+	}).Order("revision desc").First(&bundle).Error; err != nil {
+		if revision == 0 {
+			return nil, errors.New("未找到配置包 [" + name + "]")
+		}
+		return nil, errors.New("未找到配置包 [" + name + "] 的版本 " + strconv.Itoa(revision))
+	}
+	return bundle, nil
+}
+
+// RemoveConfigBundle 删除配置包, revision为0时删除全部版本, 正在被运行中的应用使用的版本不能删除
+func (a *appRunMgr) RemoveConfigBundle(name string, revision int) error {
+	a.Lock()
+	defer a.Unlock()
+	for _, statusMap := range []map[string]*AppStatusInfo{a.startAppMap, a.upgradeAppMap} {
+		for appName, statusInfo := range statusMap {
+			for _, ref := range statusInfo.ConfigBundles {
+				if ref.Name == name && (revision == 0 || ref.Revision == revision) {
+					return errors.New("配置包正在被应用 [" + appName + "] 使用, 请先停止应用")
+				}
+			}
+		}
+	}
+
+	bundleWhere := db.GetDb().Model(&vos.DbConfigBundle{}).Where(&vos.DbConfigBundle{
+		Name:     name,
+		Revision: revision,
+	})
+
+	count := 0
+	if err := bundleWhere.Count(&count).Error; err != nil || count == 0 {
+		return errors.New("未找到对应的配置包")
+	}
+
+	if err := bundleWhere.Delete(&vos.DbConfigBundle{}).Error; err != nil {
+		return errors.New("删除配置包失败")
+	}
+	return nil
+}
+
+// checkConfigBundleName 配置包名称不能包含路径分隔符及空白字符
+func checkConfigBundleName(name string) error {
+	if name == "" || strings.ContainsAny(name, "/\\ \t\r\n") {
+		return errors.New("配置包名称 [" + name + "] 不合法")
+	}
+	return nil
+}
+
+// checkConfigBundleContent 校验配置包内容, 仅允许运行目录内的普通文件及目录
+func checkConfigBundleContent(content []byte) (int, int64, error) {
+	gr, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return 0, 0, errors.New("配置包不是合法的tar.gz格式")
+	}
+	defer gr.Close()
+
+	var (
+		fileCount int
+		size      int64
+	)
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return 0, 0, errors.New("读取配置包内文件失败")
+		}
+
+		for _, part := range strings.Split(strings.ReplaceAll(hdr.Name, "\\", "/"), "/") {
+			if part == ".." {
+				return 0, 0, errors.New("配置包内的文件路径 [" + hdr.Name + "] 不合法")
+			}
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+		case tar.TypeReg:
+			if path.Clean("/"+hdr.Name) == "/" {
+				return 0, 0, errors.New("配置包内的文件路径 [" + hdr.Name + "] 不合法")
+			}
+			fileCount++
+			size += hdr.Size
+		default:
+			return 0, 0, errors.New("配置包内仅允许普通文件及目录 [" + hdr.Name + "]")
+		}
+	}
+
+	if fileCount == 0 {
+		return 0, 0, errors.New("配置包内没有文件")
+	}
+	return fileCount, size, nil
+}
+
+// materializeConfigBundles 将引用的配置包写出到运行目录, 记录实际使用的版本
+func (a *appRunMgr) materializeConfigBundles(appStatusInfo *AppStatusInfo) error {
+	refs := appStatusInfo.StartArgs.ConfigBundles
+	if len(refs) == 0 {
+		return nil
+	}
+
+	var templateData *configBundleTemplateData
+	resolved := make([]*vos.AppConfigBundleRef, 0, len(refs))
+	for _, ref := range refs {
+		bundle, err := GetConfigBundle(ref.Name, ref.Revision)
+		if err != nil {
+			return err
+		}
+
+		dest := appStatusInfo.runDir
+		if ref.Path != "" {
+			p := filepath.Clean(filepath.FromSlash(ref.Path))
+			if filepath.IsAbs(p) || p == ".." || strings.HasPrefix(p, ".."+string(filepath.Separator)) {
+				return errors.New("配置包 [" + ref.Name + "] 的路径必须为运行目录内的相对路径")
+			}
+			dest = filepath.Join(dest, p)
+		}
+
+		if ref.Template && templateData == nil {
+			if templateData, err = newConfigBundleTemplateData(appStatusInfo); err != nil {
+				return err
+			}
+		}
+
+		if err = writeConfigBundle(bundle, dest, ref.Template, templateData); err != nil {
+			return err
+		}
+
+		resolved = append(resolved, &vos.AppConfigBundleRef{
+			Name:     bundle.Name,
+			Revision: bundle.Revision,
+			Path:     ref.Path,
+			Template: ref.Template,
+		})
+	}
+
+	a.Lock()
+	appStatusInfo.ConfigBundles = resolved
+	a.Unlock()
+	return nil
+}
+
+// writeConfigBundle 解压配置包, 需要时渲染模板, 然后复制到目标目录
+func writeConfigBundle(bundle *vos.DbConfigBundle, dest string, isTemplate bool, templateData *configBundleTemplateData) error {
+	bundleName := bundle.Name + ":" + strconv.Itoa(bundle.Revision)
+	if _, _, err := checkConfigBundleContent(bundle.Content); err != nil {
+		return errors.New("配置包 [" + bundleName + "] 已损坏 => " + err.Error())
+	}
+
+	tmpDir, err := utils.TmpDir()
+	if err != nil {
+		return errors.New("创建临时存储目录失败")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err = utils.DeCompressGzipByReader(bytes.NewReader(bundle.Content), tmpDir); err != nil {
+		return errors.New("解压配置包 [" + bundleName + "] 失败")
+	}
+
+	if isTemplate {
+		if err = filepath.Walk(tmpDir, func(p string, info os.FileInfo, err error) error {
+			if err != nil || !info.Mode().IsRegular() {
+				return err
+			}
+			return renderConfigBundleFile(p, info, templateData)
+		}); err != nil {
+			return errors.New("渲染配置包 [" + bundleName + "] 失败 => " + err.Error())
+		}
+	}
+
+	if err = copyDir(tmpDir, dest); err != nil {
+		return errors.New("写出配置包 [" + bundleName + "] 失败 => " + err.Error())
+	}
+	return os.Chmod(dest, 0755)
+}
+
+// renderConfigBundleFile 使用模板数据渲染文件, 引用不存在的配置时报错
+func renderConfigBundleFile(p string, info os.FileInfo, templateData *configBundleTemplateData) error {
+	content, err := ioutil.ReadFile(p)
+	if err != nil {
+		return err
+	}
+
+	tmpl, err := template.New(info.Name()).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return err
+	}
+
+	buf := &bytes.Buffer{}
+	if err = tmpl.Execute(buf, templateData); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p, buf.Bytes(), info.Mode().Perm())
+}
+
+// newConfigBundleTemplateData 获取应用的环境配置及端口作为模板数据
+func newConfigBundleTemplateData(appStatusInfo *AppStatusInfo) (*configBundleTemplateData, error) {
+	data := &configBundleTemplateData{
+		App:     appStatusInfo.Name,
+		Version: appStatusInfo.VersionStr,
+		RunDir:  appStatusInfo.runDir,
+		Env:     make(map[string]string),
+		Ports:   make(map[string]int),
+	}
+
+	for _, c := range appStatusInfo.StartArgs.EnvConfig {
+		val := c.Val
+		if val == "" {
+			val = c.DefaultVal
+		}
+
+		val, err := resolveSecretRefs(val)
+		if err != nil {
+			return nil, err
+		}
+		data.Env[c.Name] = val
+	}
+
+	for _, p := range appStatusInfo.Ports {
+		data.Ports[p.Name] = p.Port
+	}
+	return data, nil
+}
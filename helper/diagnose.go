@@ -0,0 +1,245 @@
+package helper
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/byzk-org/bypt-server/consts"
+	"github.com/byzk-org/bypt-server/vos"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	// diagnoseCmdTimeout jcmd命令执行超时时间
+	diagnoseCmdTimeout = 60 * time.Second
+	// diagnoseHeapDumpTimeout 堆转储超时时间
+	diagnoseHeapDumpTimeout = 10 * time.Minute
+	// diagnoseSigQuitTimeout 通过SIGQUIT获取线程栈时等待输出的超时时间
+	diagnoseSigQuitTimeout = 10 * time.Second
+)
+
+// threadDumpEndPattern SIGQUIT线程栈输出的结束行
+var threadDumpEndPattern = regexp.MustCompile(`^JNI global ref`)
+
+// jcmdAllowCommands 允许透传的jcmd子命令, 不包含会写入文件或改变运行状态的命令
+var jcmdAllowCommands = map[string]bool{
+	"Thread.print":                true,
+	"VM.version":                  true,
+	"VM.uptime":                   true,
+	"VM.flags":                    true,
+	"VM.command_line":             true,
+	"VM.system_properties":        true,
+	"VM.native_memory":            true,
+	"VM.metaspace":                true,
+	"VM.classloader_stats":        true,
+	"GC.heap_info":                true,
+	"GC.class_histogram":          true,
+	"Compiler.codecache":          true,
+	"Compiler.CodeHeap_Analytics": true,
+}
+
+// jcmdAllowArgs 限制参数的jcmd子命令, 如 VM.native_memory 的 baseline、shutdown 会改变运行状态
+var jcmdAllowArgs = map[string]map[string]bool{
+	"VM.native_memory": {
+		"summary":      true,
+		"detail":       true,
+		"summary.diff": true,
+	},
+}
+
+// ThreadDump 获取应用线程栈, 优先使用应用自身jdk中的jcmd, 失败时发送SIGQUIT并从应用输出中截取
+func (a *appRunMgr) ThreadDump(appName string) ([]byte, error) {
+	statusInfo, pid, err := a.diagnoseApp(appName)
+	if err != nil {
+		return nil, err
+	}
+
+	if output, err := statusInfo.runJcmd(pid, diagnoseCmdTimeout, "Thread.print"); err == nil {
+		return output, nil
+	}
+
+	return a.threadDumpBySigQuit(appName, statusInfo, pid)
+}
+
+// HeapDump 将应用堆转储到诊断文件目录, 返回转储文件路径
+func (a *appRunMgr) HeapDump(appName string) (string, error) {
+	statusInfo, pid, err := a.diagnoseApp(appName)
+	if err != nil {
+		return "", err
+	}
+
+	if statusInfo.StartArgs.Sandbox != nil && statusInfo.StartArgs.Sandbox.MountNs {
+		return "", errors.New("应用运行在独立的挂载命名空间中, 无法转储到诊断文件目录")
+	}
+
+	dumpDir := filepath.Join(consts.DumpSaveDir, statusInfo.Name, statusInfo.VersionStr)
+	if err = os.MkdirAll(dumpDir, 0755); err != nil {
+		return "", errors.New("创建诊断文件目录失败")
+	}
+
+	if err = statusInfo.credential.chown(dumpDir); err != nil {
+		return "", err
+	}
+
+	dumpFile := filepath.Join(dumpDir, statusInfo.Name+"-"+time.Now().Format("20060102150405")+".hprof")
+	output, err := statusInfo.runJcmd(pid, diagnoseHeapDumpTimeout, "GC.heap_dump", dumpFile)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err = os.Stat(dumpFile); err != nil {
+		return "", errors.New("堆转储失败 => " + string(bytes.TrimSpace(output)))
+	}
+	return dumpFile, nil
+}
+
+// Jcmd 在应用上执行白名单中的jcmd子命令
+func (a *appRunMgr) Jcmd(appName string, args []string) ([]byte, error) {
+	if len(args) == 0 {
+		return nil, errors.New("缺失jcmd子命令")
+	}
+
+	if !jcmdAllowCommands[args[0]] {
+		return nil, errors.New("不支持的jcmd子命令 [" + args[0] + "]")
+	}
+
+	if allowArgs, ok := jcmdAllowArgs[args[0]]; ok {
+		for _, arg := range args[1:] {
+			if !allowArgs[arg] {
+				return nil, errors.New("jcmd子命令 [" + args[0] + "] 不支持参数 [" + arg + "]")
+			}
+		}
+	}
+
+	statusInfo, pid, err := a.diagnoseApp(appName)
+	if err != nil {
+		return nil, err
+	}
+	return statusInfo.runJcmd(pid, diagnoseCmdTimeout, args...)
+}
+
+// diagnoseApp 获取可诊断的运行中java应用及其进程号
+func (a *appRunMgr) diagnoseApp(appName string) (*AppStatusInfo, int, error) {
+	a.Lock()
+	statusInfo, ok := a.startAppMap[appName]
+	a.Unlock()
+	if !ok {
+		return nil, 0, errors.New("app未启动")
+	}
+
+	if statusInfo.VersionInfo.ExecType == vos.AppExecTypeCmd {
+		return nil, 0, errors.New("仅支持诊断java应用")
+	}
+
+	statusInfo.closeLock.Lock()
+	defer statusInfo.closeLock.Unlock()
+	if statusInfo.isClose || statusInfo.runCmd == nil || statusInfo.runCmd.Process == nil {
+		return nil, 0, errors.New("应用未处于运行状态")
+	}
+	return statusInfo, statusInfo.runCmd.Process.Pid, nil
+}
+
+// threadDumpBySigQuit 向应用发送SIGQUIT, 截取jvm打印到控制台的线程栈
+func (a *appRunMgr) threadDumpBySigQuit(appName string, statusInfo *AppStatusInfo, pid int) ([]byte, error) {
+	if runtime.GOOS == "windows" {
+		return nil, errors.New("当前系统不支持通过信号获取线程栈")
+	}
+
+	attach, err := a.AttachApp(appName, false)
+	if err != nil {
+		return nil, err
+	}
+	defer attach.Close()
+
+	watcher := statusInfo.addLogWatcher(threadDumpEndPattern)
+	defer statusInfo.removeLogWatcher(watcher)
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return nil, errors.New("获取应用进程失败")
+	}
+
+	if err = process.Signal(syscall.SIGQUIT); err != nil {
+		return nil, errors.New("发送SIGQUIT信号失败 => " + err.Error())
+	}
+
+	timer := time.NewTimer(diagnoseSigQuitTimeout)
+	defer timer.Stop()
+
+	buf := &bytes.Buffer{}
+	for {
+		select {
+		case output := <-attach.Output():
+			buf.Write(output)
+		case <-watcher.matchChan:
+			// 结束行与其所在的输出块可能还在通道中
+			for {
+				select {
+				case output := <-attach.Output():
+					buf.Write(output)
+				default:
+					return buf.Bytes(), nil
+				}
+			}
+		case <-attach.Exit():
+			return nil, errors.New("应用已停止运行")
+		case <-timer.C:
+			if buf.Len() == 0 {
+				return nil, errors.New("等待线程栈输出超时")
+			}
+			return buf.Bytes(), nil
+		}
+	}
+}
+
+// jcmdPath 获取应用所使用jdk中的jcmd路径
+func (a *AppStatusInfo) jcmdPath() (string, error) {
+	jcmdName := "jcmd"
+	if runtime.GOOS == "windows" {
+		jcmdName += ".exe"
+	}
+
+	if a.JavaCmd == "" || a.JavaCmd == "java" {
+		p, err := exec.LookPath(jcmdName)
+		if err != nil {
+			return "", errors.New("未找到jcmd命令")
+		}
+		return p, nil
+	}
+
+	p := filepath.Join(filepath.Dir(a.JavaCmd), jcmdName)
+	if _, err := os.Stat(p); err != nil {
+		return "", errors.New("应用所使用的jdk中未找到jcmd命令")
+	}
+	return p, nil
+}
+
+// runJcmd 以应用运行用户的身份执行jcmd, jvm仅接受相同用户的attach请求
+func (a *AppStatusInfo) runJcmd(pid int, timeout time.Duration, args ...string) ([]byte, error) {
+	jcmd, err := a.jcmdPath()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, jcmd, append([]string{strconv.Itoa(pid)}, args...)...)
+	cmd.SysProcAttr = a.credential.sysProcAttr()
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, errors.New("jcmd执行超时")
+	}
+
+	if err != nil {
+		return nil, errors.New("jcmd执行失败 => " + string(bytes.TrimSpace(output)))
+	}
+	return output, nil
+}
@@ -0,0 +1,118 @@
+package helper
+
+import (
+	"fmt"
+	"github.com/byzk-org/bypt-server/db"
+	"github.com/byzk-org/bypt-server/vos"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	// appEventRecentLimit 应用详情中展示的最近事件数量
+	appEventRecentLimit = 10
+	// appEventSaveRetry 数据库被事务锁定时的重试次数
+	appEventSaveRetry = 20
+)
+
+var (
+	// appEventChan 事件由单独的协程顺序写入并分发通知, 避免在持有事务或状态锁时直接写库
+	appEventChan = make(chan *vos.DbAppEvent, 1024)
+	// appEventSeq 事件序号, 避免同一时钟刻度内的事件id重复
+	appEventSeq uint64
+)
+
+func init() {
+	go saveAppEvents()
+}
+
+// saveAppEvents 保存应用事件
+func saveAppEvents() {
+	for event := range appEventChan {
+		var err error
+		for i := 0; i < appEventSaveRetry; i++ {
+			// 仅数据库被锁定时重试, 其他错误(如主键冲突)重试无意义
+			if err = db.GetDb().Create(event).Error; err == nil || !strings.Contains(err.Error(), "locked") {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		if err != nil {
+			logrus.Error("保存应用[" + event.AppName + "]事件失败 => " + err.Error())
+		}
+
+		appNotifier.notifyAppEvent(event)
+	}
+}
+
+// recordAppEvent 记录应用事件
+func recordAppEvent(appName, appVersion string, eventType vos.AppEventType, msg string) {
+	recordEvent(&vos.DbAppEvent{
+		AppName:    appName,
+		AppVersion: appVersion,
+		Type:       eventType,
+		Msg:        msg,
+	})
+}
+
+func recordEvent(event *vos.DbAppEvent) {
+	now := time.Now()
+	event.Id = fmt.Sprintf("%s-%d-%d", event.AppName, now.UnixNano(), atomic.AddUint64(&appEventSeq, 1))
+	event.CreateTime = now
+	select {
+	case appEventChan <- event:
+	default:
+		logrus.Error("应用[" + event.AppName + "]事件队列已满, 丢弃事件 => " + string(event.Type))
+	}
+}
+
+// recordEvent 记录应用事件, 进程已结束时同时记录退出码及信号, 定时任务有单独的运行记录, 不记录事件
+func (a *AppStatusInfo) recordEvent(eventType vos.AppEventType, msg string) {
+	if a.startMode == appStartModeJob {
+		return
+	}
+
+	event := &vos.DbAppEvent{
+		AppName:    a.Name,
+		AppVersion: a.VersionStr,
+		Type:       eventType,
+		Msg:        msg,
+	}
+
+	if a.runCmd != nil && a.runCmd.ProcessState != nil {
+		event.ExitCode = a.runCmd.ProcessState.ExitCode()
+		if status, ok := a.runCmd.ProcessState.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			event.Signal = status.Signal().String()
+		}
+	}
+	recordEvent(event)
+}
+
+// QueryAppEvents 查询应用事件, appName为空时查询全部应用, 时间为零值时不限制
+func QueryAppEvents(appName string, startTime, endTime time.Time, limit int) ([]*vos.DbAppEvent, error) {
+	query := db.GetDb().Model(&vos.DbAppEvent{})
+	if appName != "" {
+		query = query.Where(&vos.DbAppEvent{
+			AppName: appName,
+		})
+	}
+
+	if !startTime.IsZero() {
+		query = query.Where("create_time >= ?", startTime)
+	}
+
+	if !endTime.IsZero() {
+		query = query.Where("create_time <= ?", endTime)
+	}
+
+	events := make([]*vos.DbAppEvent, 0)
+	if err := query.Order("create_time desc").Limit(limit).Find(&events).Error; err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	return events, nil
+}
@@ -0,0 +1,388 @@
+package helper
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"github.com/byzk-org/bypt-server/consts"
+	"github.com/byzk-org/bypt-server/db"
+	"github.com/byzk-org/bypt-server/vos"
+	"github.com/jinzhu/gorm"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jdkConstraintPattern jdk版本约束, 如 17、1.8、>=11、11+
+var jdkConstraintPattern = regexp.MustCompile(`^(>=|<=|>|<|=)?\s*(?:1\.)?([0-9]+)(\+)?$`)
+
+// jdkNamePattern 生成jdk名称时需要替换的字符
+var jdkNamePattern = regexp.MustCompile(`[^a-z0-9._]+`)
+
+// jdkScanLock 扫描在独立的协程中执行, 同一时间只允许一次扫描, 避免重复登记
+var jdkScanLock sync.Mutex
+
+// ScanLocalJdks 在常用位置及配置的目录中查找jdk并登记, 已登记但不存在的jdk一并删除
+func ScanLocalJdks() ([]*vos.DbLocalJdk, error) {
+	jdkScanLock.Lock()
+	defer jdkScanLock.Unlock()
+
+	found := make([]*vos.DbLocalJdk, 0)
+	homes := make(map[string]bool)
+	for _, root := range jdkScanRoots() {
+		for _, home := range jdkHomeCandidates(root) {
+			if realHome, err := filepath.EvalSymlinks(home); err == nil {
+				home = realHome
+			}
+
+			if homes[home] {
+				continue
+			}
+			homes[home] = true
+
+			if jdk, err := probeJdk(home); err == nil {
+				found = append(found, jdk)
+			}
+		}
+	}
+
+	result := make([]*vos.DbLocalJdk, 0)
+	err := db.GetDb().Transaction(func(tx *gorm.DB) error {
+		registered := make([]*vos.DbLocalJdk, 0)
+		if err := tx.Model(&vos.DbLocalJdk{}).Find(&registered).Error; err != nil && err != gorm.ErrRecordNotFound {
+			return errors.New("查询本地jdk信息失败")
+		}
+
+		names := make(map[string]bool, len(registered))
+		byHome := make(map[string]*vos.DbLocalJdk, len(registered))
+		for _, r := range registered {
+			names[r.Name] = true
+			byHome[r.Home] = r
+		}
+
+		now := time.Now()
+		for _, jdk := range found {
+			jdk.UpdateTime = now
+			if r, ok := byHome[jdk.Home]; ok {
+				jdk.Name = r.Name
+				jdk.CreateTime = r.CreateTime
+				delete(byHome, jdk.Home)
+			} else {
+				jdk.Name = uniqueJdkName(jdk, names)
+				jdk.CreateTime = now
+			}
+
+			names[jdk.Name] = true
+			if err := tx.Save(jdk).Error; err != nil {
+				return errors.New("登记jdk [" + jdk.Home + "] 失败")
+			}
+			result = append(result, jdk)
+		}
+
+		// 不在扫描目录中但仍然存在的jdk保留登记
+		for _, r := range byHome {
+			if _, err := os.Stat(r.JavaCmd); err == nil {
+				result = append(result, r)
+				continue
+			}
+
+			if err := tx.Where(&vos.DbLocalJdk{
+				Name: r.Name,
+			}).Delete(&vos.DbLocalJdk{}).Error; err != nil {
+				return errors.New("删除jdk [" + r.Name + "] 的登记信息失败")
+			}
+		}
+
+		sort.Slice(result, func(i, j int) bool {
+			return result[i].Name < result[j].Name
+		})
+		return nil
+	})
+	return result, err
+}
+
+// QueryLocalJdks 查询已登记的本地jdk
+func QueryLocalJdks() ([]*vos.DbLocalJdk, error) {
+	jdks := make([]*vos.DbLocalJdk, 0)
+	if err := db.GetDb().Model(&vos.DbLocalJdk{}).Order("name").Find(&jdks).Error; err != nil && err != gorm.ErrRecordNotFound {
+		return nil, errors.New("查询本地jdk信息失败")
+	}
+	return jdks, nil
+}
+
+// RemoveLocalJdk 删除本地jdk的登记信息, 不删除jdk文件
+func RemoveLocalJdk(name string) error {
+	jdkWhere := db.GetDb().Model(&vos.DbLocalJdk{}).Where(&vos.DbLocalJdk{
+		Name: name,
+	})
+
+	count := 0
+	if err := jdkWhere.Count(&count).Error; err != nil || count == 0 {
+		return errors.New("未找到本地jdk [" + name + "]")
+	}
+
+	if err := jdkWhere.Delete(&vos.DbLocalJdk{}).Error; err != nil {
+		return errors.New("删除本地jdk登记信息失败")
+	}
+	return nil
+}
+
+// resolveLocalJdk 根据名称或版本约束查找已登记的本地jdk, 多个jdk满足约束时使用版本最高的
+func resolveLocalJdk(ref string) (*vos.DbLocalJdk, error) {
+	jdks, err := QueryLocalJdks()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, jdk := range jdks {
+		if jdk.Name == ref {
+			return jdk, nil
+		}
+	}
+
+	m := jdkConstraintPattern.FindStringSubmatch(strings.TrimSpace(ref))
+	if m == nil {
+		return nil, errors.New("未找到名称为 [" + ref + "] 的本地jdk, 请先执行jdk扫描")
+	}
+
+	op := m[1]
+	if m[3] != "" {
+		if op != "" {
+			return nil, errors.New("jdk版本约束 [" + ref + "] 格式不正确")
+		}
+		op = ">="
+	}
+	major, _ := strconv.Atoi(m[2])
+
+	var matched *vos.DbLocalJdk
+	for _, jdk := range jdks {
+		if !matchJdkConstraint(jdk.Major, op, major) {
+			continue
+		}
+
+		if matched == nil || jdk.Major > matched.Major || (jdk.Major == matched.Major && compareJavaVersion(jdk.Version, matched.Version) > 0) {
+			matched = jdk
+		}
+	}
+
+	if matched == nil {
+		return nil, errors.New("未找到满足版本约束 [" + ref + "] 的本地jdk")
+	}
+
+	if _, err = os.Stat(matched.JavaCmd); err != nil {
+		return nil, errors.New("本地jdk [" + matched.Name + "] 已不存在, 请重新执行jdk扫描")
+	}
+	return matched, nil
+}
+
+func matchJdkConstraint(jdkMajor int, op string, major int) bool {
+	switch op {
+	case ">=":
+		return jdkMajor >= major
+	case "<=":
+		return jdkMajor <= major
+	case ">":
+		return jdkMajor > major
+	case "<":
+		return jdkMajor < major
+	default:
+		return jdkMajor == major
+	}
+}
+
+// compareJavaVersion 按数字段比较版本, 如 1.8.0_292 与 1.8.0_312
+func compareJavaVersion(a, b string) int {
+	splitFn := func(r rune) bool { return r < '0' || r > '9' }
+	as := strings.FieldsFunc(a, splitFn)
+	bs := strings.FieldsFunc(b, splitFn)
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, _ := strconv.Atoi(as[i])
+		bn, _ := strconv.Atoi(bs[i])
+		if an != bn {
+			if an > bn {
+				return 1
+			}
+			return -1
+		}
+	}
+	return len(as) - len(bs)
+}
+
+// jdkScanRoots 要扫描的目录, 包含常用安装位置、JAVA_HOME、PATH中的java及配置的目录
+func jdkScanRoots() []string {
+	roots := make([]string, 0, 16)
+	switch runtime.GOOS {
+	case "darwin":
+		roots = append(roots,
+			"/Library/Java/JavaVirtualMachines",
+			filepath.Join(consts.HomeDir, "Library", "Java", "JavaVirtualMachines"),
+		)
+	case "windows":
+		for _, env := range []string{"ProgramFiles", "ProgramFiles(x86)"} {
+			programFiles := os.Getenv(env)
+			if programFiles == "" {
+				continue
+			}
+
+			for _, vendor := range []string{"Java", "Eclipse Adoptium", "Eclipse Foundation", "Zulu", "Microsoft", "Amazon Corretto", "BellSoft"} {
+				roots = append(roots, filepath.Join(programFiles, vendor))
+			}
+		}
+	default:
+		roots = append(roots, "/usr/lib/jvm", "/usr/lib64/jvm", "/usr/java", "/usr/local/java", "/opt/java", "/opt/jdk", "/opt", "/usr/local")
+	}
+
+	roots = append(roots,
+		filepath.Join(consts.HomeDir, ".sdkman", "candidates", "java"),
+		filepath.Join(consts.HomeDir, ".jdks"),
+	)
+
+	if javaHome := os.Getenv("JAVA_HOME"); javaHome != "" {
+		roots = append(roots, javaHome)
+	}
+
+	if p, err := exec.LookPath("java"); err == nil {
+		if realPath, err := filepath.EvalSymlinks(p); err == nil {
+			p = realPath
+		}
+		roots = append(roots, filepath.Dir(filepath.Dir(p)))
+	}
+
+	setting := &vos.DbSetting{}
+	if err := db.GetDb().Model(&vos.DbSetting{}).Where(&vos.DbSetting{
+		Name: consts.DbSettingJdkScanDirs,
+	}).First(&setting).Error; err == nil {
+		for _, dir := range filepath.SplitList(setting.Val) {
+			if dir = strings.TrimSpace(dir); dir != "" {
+				roots = append(roots, dir)
+			}
+		}
+	}
+	return roots
+}
+
+// jdkHomeCandidates 目录本身及其下一级目录中包含 bin/java 的目录
+func jdkHomeCandidates(root string) []string {
+	candidates := make([]string, 0)
+	if isJdkHome(root) {
+		candidates = append(candidates, root)
+	}
+
+	fileInfos, err := ioutil.ReadDir(root)
+	if err != nil {
+		return candidates
+	}
+
+	for _, f := range fileInfos {
+		home := filepath.Join(root, f.Name())
+		if runtime.GOOS == "darwin" {
+			if macHome := filepath.Join(home, "Contents", "Home"); isJdkHome(macHome) {
+				candidates = append(candidates, macHome)
+				continue
+			}
+		}
+
+		if isJdkHome(home) {
+			candidates = append(candidates, home)
+		}
+	}
+	return candidates
+}
+
+func isJdkHome(home string) bool {
+	stat, err := os.Stat(jdkJavaCmd(home))
+	return err == nil && stat.Mode().IsRegular()
+}
+
+func jdkJavaCmd(home string) string {
+	javaName := "java"
+	if runtime.GOOS == "windows" {
+		javaName += ".exe"
+	}
+	return filepath.Join(home, "bin", javaName)
+}
+
+// probeJdk 获取jdk的版本、厂商及架构, 优先读取release文件, 信息不全时通过java命令获取
+func probeJdk(home string) (*vos.DbLocalJdk, error) {
+	jdk := &vos.DbLocalJdk{
+		Home:    home,
+		JavaCmd: jdkJavaCmd(home),
+	}
+
+	if content, err := ioutil.ReadFile(filepath.Join(home, "release")); err == nil {
+		release := parseJdkProperties(content, "=")
+		jdk.Version = release["JAVA_VERSION"]
+		jdk.Vendor = release["IMPLEMENTOR"]
+		jdk.Arch = release["OS_ARCH"]
+	}
+
+	if jdk.Version == "" || jdk.Vendor == "" || jdk.Arch == "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		output, _ := exec.CommandContext(ctx, jdk.JavaCmd, "-XshowSettings:properties", "-version").CombinedOutput()
+		properties := parseJdkProperties(output, " = ")
+		if jdk.Version == "" {
+			jdk.Version = properties["java.version"]
+		}
+
+		if jdk.Vendor == "" {
+			jdk.Vendor = properties["java.vendor"]
+		}
+
+		if jdk.Arch == "" {
+			jdk.Arch = properties["os.arch"]
+		}
+	}
+
+	if jdk.Version == "" {
+		return nil, errors.New("获取jdk [" + home + "] 的版本失败")
+	}
+
+	major, err := parseJavaMajorVersion(jdk.Version)
+	if err != nil {
+		return nil, err
+	}
+	jdk.Major = major
+	return jdk, nil
+}
+
+// parseJdkProperties 解析 key=value 格式的内容, 去除值两侧的引号
+func parseJdkProperties(content []byte, sep string) map[string]string {
+	result := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		kv := strings.SplitN(strings.TrimSpace(scanner.Text()), sep, 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		key := strings.TrimSpace(kv[0])
+		if _, ok := result[key]; !ok {
+			result[key] = strings.Trim(strings.TrimSpace(kv[1]), `"`)
+		}
+	}
+	return result
+}
+
+// uniqueJdkName 生成 厂商-版本 格式的名称, 重名时追加序号
+func uniqueJdkName(jdk *vos.DbLocalJdk, names map[string]bool) string {
+	vendor := strings.Trim(jdkNamePattern.ReplaceAllString(strings.ToLower(jdk.Vendor), "-"), "-")
+	if vendor == "" {
+		vendor = "jdk"
+	}
+
+	name := vendor + "-" + jdk.Version
+	for i := 2; names[name]; i++ {
+		name = vendor + "-" + jdk.Version + "-" + strconv.Itoa(i)
+	}
+	return name
+}
@@ -0,0 +1,539 @@
+package helper
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/byzk-org/bypt-server/consts"
+	"github.com/byzk-org/bypt-server/db"
+	"github.com/byzk-org/bypt-server/utils"
+	"github.com/byzk-org/bypt-server/vos"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	// jdkCacheDirName 运行根目录下的jdk缓存目录, 运行用户可以访问, 沙箱中重新挂载为只读
+	jdkCacheDirName = ".jdkCache"
+	// jdkCacheManifestSuffix 缓存的文件清单, 与缓存目录同级, 存在时表示解压完成
+	jdkCacheManifestSuffix = ".manifest"
+	// jdkCacheTmpPrefix 正在解压的临时目录前缀
+	jdkCacheTmpPrefix = ".tmp-"
+)
+
+// jdkCacheFile 缓存中文件的状态, 复用缓存时校验
+type jdkCacheFile struct {
+	Size    int64       `json:"size"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime int64       `json:"modTime"`
+	// Sha1 普通文件内容的摘要
+	Sha1 string `json:"sha1,omitempty"`
+	// Link 符号链接的目标
+	Link string `json:"link,omitempty"`
+}
+
+// jdkCacheManifest 缓存的文件清单
+type jdkCacheManifest struct {
+	Files map[string]*jdkCacheFile `json:"files"`
+}
+
+// jdkCache 包内jdk的解压缓存, 以jdk的摘要为键, 校验一次后以只读方式在应用之间共享
+type jdkCache struct {
+	sync.Mutex
+	refs map[string]int
+	// removing 对应的jdk已被删除, 引用全部释放后删除缓存
+	removing map[string]bool
+	// extracting 正在解压或校验摘要的缓存, 结束后关闭, 期间不持有锁
+	extracting map[string]chan struct{}
+	// verified 本次服务启动后已校验过文件摘要的缓存, 之后复用时只校验文件状态
+	verified map[string]bool
+}
+
+var packJdkCache = &jdkCache{
+	refs:       make(map[string]int),
+	removing:   make(map[string]bool),
+	extracting: make(map[string]chan struct{}),
+	verified:   make(map[string]bool),
+}
+
+func jdkCacheKey(jdkInfo *vos.DbJdkInfo) string {
+	return hex.EncodeToString(jdkInfo.MD5) + "-" + hex.EncodeToString(jdkInfo.SHA1)
+}
+
+// jdkCacheRoot 运行根目录对应的jdk缓存目录
+func jdkCacheRoot(runRoot string) string {
+	return filepath.Join(runRoot, jdkCacheDirName)
+}
+
+// settingJdkCacheRoot 当前运行目录配置对应的jdk缓存目录
+func settingJdkCacheRoot() (string, error) {
+	settingRunDir := &vos.DbSetting{}
+	if err := db.GetDb().Model(&vos.DbSetting{}).Where(&vos.DbSetting{
+		Name: consts.DbSettingRunDir,
+	}).First(&settingRunDir).Error; err != nil {
+		return "", errors.New("获取运行目录失败")
+	}
+	return jdkCacheRoot(filepath.Clean(settingRunDir.Val)), nil
+}
+
+// ensureJdkCacheRoot 创建缓存目录, 目录需属于服务用户, 避免运行根目录中的缓存被其他用户替换
+func ensureJdkCacheRoot(root string) error {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return errors.New("创建jdk缓存目录失败")
+	}
+
+	if err := checkOwnedDir(root); err != nil {
+		return errors.New("jdk缓存目录 [" + root + "] 不可用 => " + err.Error())
+	}
+
+	if err := os.Chmod(root, 0755); err != nil {
+		return errors.New("设置jdk缓存目录权限失败")
+	}
+	return nil
+}
+
+// acquire 获取应用使用的jdk缓存并增加引用, 缓存不存在或校验失败时重新解压, 返回java命令路径
+func (c *jdkCache) acquire(appStatusInfo *AppStatusInfo, jdkInfo *vos.DbJdkInfo) (string, error) {
+	if !utils.PubKeyVerifySign(consts.CaPubKey, jdkInfo.SignSrc(), jdkInfo.Sign) {
+		return "", errors.New("jdk已被篡改, 请重新导入然后再次尝试")
+	}
+
+	root := jdkCacheRoot(appStatusInfo.runRootDir)
+	if err := ensureJdkCacheRoot(root); err != nil {
+		return "", err
+	}
+
+	key := jdkCacheKey(jdkInfo)
+	dir := filepath.Join(root, key)
+	extracted := false
+	for {
+		c.Lock()
+		if done, ok := c.extracting[key]; ok {
+			c.Unlock()
+			<-done
+			continue
+		}
+
+		err := verifyJdkCache(dir)
+		if err == nil && !c.verified[key] {
+			// 本次服务启动后首次使用该缓存, 校验文件摘要, 校验期间不持有锁
+			done := make(chan struct{})
+			c.extracting[key] = done
+			c.Unlock()
+
+			err = verifyJdkCacheDigest(dir)
+
+			c.Lock()
+			delete(c.extracting, key)
+			close(done)
+			if err == nil {
+				c.verified[key] = true
+			}
+		}
+
+		if err == nil {
+			delete(c.removing, key)
+			c.refs[key]++
+			appStatusInfo.jdkCacheKey = key
+			c.Unlock()
+			return jdkJavaCmd(dir), nil
+		}
+
+		if extracted {
+			c.Unlock()
+			return "", errors.New("jdk缓存校验失败 => " + err.Error())
+		}
+
+		if c.refs[key] > 0 {
+			c.Unlock()
+			return "", errors.New("jdk缓存校验失败 => " + err.Error() + ", 请停止使用该jdk的应用后重试")
+		}
+
+		delete(c.verified, key)
+		if err = removeJdkCacheDir(root, key); err != nil {
+			c.Unlock()
+			return "", errors.New("删除损坏的jdk缓存失败")
+		}
+
+		done := make(chan struct{})
+		c.extracting[key] = done
+		c.Unlock()
+
+		err = extractJdkCache(root, jdkInfo, key)
+
+		c.Lock()
+		delete(c.extracting, key)
+		close(done)
+		if err == nil {
+			// 清单中的摘要由刚解压的文件生成, 无需再次校验
+			c.verified[key] = true
+		}
+		c.Unlock()
+		if err != nil {
+			return "", err
+		}
+		extracted = true
+	}
+}
+
+// retain 接管的应用仍在使用上次的jdk缓存时增加引用
+func (c *jdkCache) retain(appStatusInfo *AppStatusInfo, javaCmd string) {
+	rel, err := filepath.Rel(jdkCacheRoot(appStatusInfo.runRootDir), javaCmd)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	key := strings.SplitN(rel, string(filepath.Separator), 2)[0]
+	c.refs[key]++
+	appStatusInfo.jdkCacheKey = key
+}
+
+// release 应用进程退出后释放jdk缓存的引用
+func (c *jdkCache) release(appStatusInfo *AppStatusInfo) {
+	c.Lock()
+	defer c.Unlock()
+	key := appStatusInfo.jdkCacheKey
+	if key == "" {
+		return
+	}
+	appStatusInfo.jdkCacheKey = ""
+
+	if c.refs[key]--; c.refs[key] > 0 {
+		return
+	}
+	delete(c.refs, key)
+
+	if c.removing[key] {
+		delete(c.removing, key)
+		delete(c.verified, key)
+		if err := removeJdkCacheDir(jdkCacheRoot(appStatusInfo.runRootDir), key); err != nil {
+			logrus.Error("删除jdk缓存 [" + key + "] 失败 => " + err.Error())
+		}
+	}
+}
+
+// remove 删除缓存, 正在被使用或正在解压时在引用全部释放后删除
+func (c *jdkCache) remove(root, key string) error {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.extracting[key]; ok || c.refs[key] > 0 {
+		c.removing[key] = true
+		return nil
+	}
+	delete(c.verified, key)
+	return removeJdkCacheDir(root, key)
+}
+
+// RemoveJdkCache 删除jdk对应的解压缓存
+func RemoveJdkCache(jdkInfo *vos.DbJdkInfo) error {
+	root, err := settingJdkCacheRoot()
+	if err != nil {
+		return err
+	}
+
+	if err = packJdkCache.remove(root, jdkCacheKey(jdkInfo)); err != nil {
+		return errors.New("删除jdk缓存失败")
+	}
+	return nil
+}
+
+// ClearJdkCache 删除全部jdk缓存
+func ClearJdkCache() error {
+	return pruneJdkCache(func(key string) bool { return false })
+}
+
+// PruneJdkCache 删除已不存在的jdk的缓存及未完成的解压目录
+func PruneJdkCache() error {
+	jdkInfos := make([]*vos.DbJdkInfo, 0)
+	if err := db.GetDb().Model(&vos.DbJdkInfo{}).Find(&jdkInfos).Error; err != nil {
+		return errors.New("查询jdk信息失败")
+	}
+
+	keys := make(map[string]bool, len(jdkInfos))
+	for _, jdkInfo := range jdkInfos {
+		keys[jdkCacheKey(jdkInfo)] = true
+	}
+	return pruneJdkCache(func(key string) bool { return keys[key] })
+}
+
+func pruneJdkCache(keep func(key string) bool) error {
+	root, err := settingJdkCacheRoot()
+	if err != nil {
+		return err
+	}
+
+	fileInfos, err := ioutil.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.New("读取jdk缓存目录失败")
+	}
+
+	if err = checkOwnedDir(root); err != nil {
+		return errors.New("jdk缓存目录 [" + root + "] 不可用 => " + err.Error())
+	}
+
+	for _, f := range fileInfos {
+		name := f.Name()
+		if strings.HasPrefix(name, jdkCacheTmpPrefix) {
+			packJdkCache.Lock()
+			if _, ok := packJdkCache.extracting[strings.TrimPrefix(name, jdkCacheTmpPrefix)]; !ok {
+				err = removeReadOnlyDir(filepath.Join(root, name))
+			}
+			packJdkCache.Unlock()
+		} else if f.IsDir() && !keep(name) {
+			err = packJdkCache.remove(root, name)
+		}
+
+		if err != nil {
+			return errors.New("删除jdk缓存 [" + name + "] 失败")
+		}
+	}
+	return nil
+}
+
+// extractJdkCache 解密并解压jdk到临时目录, 生成文件清单并设置为只读后移动到缓存目录
+func extractJdkCache(root string, jdkInfo *vos.DbJdkInfo, key string) error {
+	tmpDir, err := utils.TmpDir()
+	if err != nil {
+		return errors.New("创建临时存储目录失败")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path, sm4Key, err := utils.Sm4DecryptContentPath(consts.CaPrivateKey, jdkInfo.Content)
+	if err != nil {
+		return errors.New("获取jdk文件路径失败")
+	}
+
+	file, err := os.OpenFile(path, os.O_RDONLY, 0666)
+	if err != nil {
+		return errors.New("打开jdk文件失败")
+	}
+	defer file.Close()
+
+	tmpDecryptFile := filepath.Join(tmpDir, "j")
+	if err = utils.Sm4Decrypt2File(sm4Key, file, tmpDecryptFile); err != nil {
+		return errors.New("解析内部jdk失败")
+	}
+
+	extractDir := filepath.Join(root, jdkCacheTmpPrefix+key)
+	if err = removeReadOnlyDir(extractDir); err != nil {
+		return errors.New("清理jdk缓存临时目录失败")
+	}
+	defer removeReadOnlyDir(extractDir)
+
+	if err = utils.DeCompressGzip(tmpDecryptFile, extractDir); err != nil {
+		return errors.New("解压包内jdk失败")
+	}
+
+	if _, err = os.Stat(jdkJavaCmd(extractDir)); err != nil {
+		return errors.New("包内jdk中未找到java命令")
+	}
+
+	manifest, err := makeJdkCacheReadOnly(extractDir)
+	if err != nil {
+		return errors.New("设置jdk缓存只读失败 => " + err.Error())
+	}
+
+	manifestBytes, _ := json.Marshal(manifest)
+	dir := filepath.Join(root, key)
+	if err = os.Rename(extractDir, dir); err != nil {
+		return errors.New("移动jdk缓存失败")
+	}
+
+	tmpManifest := dir + jdkCacheManifestSuffix + jdkCacheTmpPrefix
+	if err = ioutil.WriteFile(tmpManifest, manifestBytes, 0644); err != nil {
+		return errors.New("写出jdk缓存清单失败")
+	}
+	return os.Rename(tmpManifest, dir+jdkCacheManifestSuffix)
+}
+
+// makeJdkCacheReadOnly 去除所有文件的写权限并允许其他用户读取及执行, 返回文件清单
+func makeJdkCacheReadOnly(dir string) (*jdkCacheManifest, error) {
+	manifest := &jdkCacheManifest{
+		Files: make(map[string]*jdkCacheFile),
+	}
+
+	// 先处理文件再处理目录, 目录只读后其中的文件仍可修改权限
+	dirs := make([]string, 0)
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			dirs = append(dirs, p)
+			return nil
+		}
+
+		rel, _ := filepath.Rel(dir, p)
+		if info.Mode().IsRegular() {
+			mode := info.Mode().Perm()&^0222 | 0444
+			if mode&0100 != 0 {
+				mode |= 0111
+			}
+
+			if err = os.Chmod(p, mode); err != nil {
+				return err
+			}
+
+			if info, err = os.Stat(p); err != nil {
+				return err
+			}
+		}
+
+		f := &jdkCacheFile{
+			Size:    info.Size(),
+			Mode:    info.Mode(),
+			ModTime: info.ModTime().UnixNano(),
+		}
+		switch {
+		case info.Mode().IsRegular():
+			if f.Sha1, err = jdkCacheFileSha1(p); err != nil {
+				return err
+			}
+		case info.Mode()&os.ModeSymlink != 0:
+			if f.Link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+		manifest.Files[filepath.ToSlash(rel)] = f
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range dirs {
+		if err = os.Chmod(d, 0555); err != nil {
+			return nil, err
+		}
+	}
+	return manifest, nil
+}
+
+// loadJdkCacheManifest 读取缓存的文件清单
+func loadJdkCacheManifest(dir string) (*jdkCacheManifest, error) {
+	manifestBytes, err := ioutil.ReadFile(dir + jdkCacheManifestSuffix)
+	if err != nil {
+		return nil, errors.New("缓存不存在")
+	}
+
+	manifest := &jdkCacheManifest{}
+	if err = json.Unmarshal(manifestBytes, manifest); err != nil || len(manifest.Files) == 0 {
+		return nil, errors.New("缓存清单已损坏")
+	}
+	return manifest, nil
+}
+
+// verifyJdkCache 校验缓存中的文件状态及符号链接与清单一致
+func verifyJdkCache(dir string) error {
+	manifest, err := loadJdkCacheManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	err = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, _ := filepath.Rel(dir, p)
+		f, ok := manifest.Files[filepath.ToSlash(rel)]
+		if !ok {
+			return errors.New("多出文件 [" + rel + "]")
+		}
+
+		if f.Size != info.Size() || f.Mode != info.Mode() || f.ModTime != info.ModTime().UnixNano() {
+			return errors.New("文件 [" + rel + "] 已被修改")
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err := os.Readlink(p); err != nil || link != f.Link {
+				return errors.New("文件 [" + rel + "] 已被修改")
+			}
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if count != len(manifest.Files) {
+		return errors.New("缓存中的文件不完整")
+	}
+	return nil
+}
+
+// verifyJdkCacheDigest 校验缓存中普通文件的内容摘要与清单一致
+func verifyJdkCacheDigest(dir string) error {
+	manifest, err := loadJdkCacheManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	for rel, f := range manifest.Files {
+		if !f.Mode.IsRegular() {
+			continue
+		}
+
+		if f.Sha1 == "" {
+			return errors.New("缓存清单缺少文件 [" + rel + "] 的摘要")
+		}
+
+		sum, err := jdkCacheFileSha1(filepath.Join(dir, filepath.FromSlash(rel)))
+		if err != nil || sum != f.Sha1 {
+			return errors.New("文件 [" + rel + "] 的内容已被修改")
+		}
+	}
+	return nil
+}
+
+// jdkCacheFileSha1 计算文件内容的sha1摘要
+func jdkCacheFileSha1(p string) (string, error) {
+	file, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	sum, err := utils.CalcHashByReader(file, sha1.New())
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sum), nil
+}
+
+// removeJdkCacheDir 删除缓存目录及其清单, 清单先删除以免留下不完整的缓存
+func removeJdkCacheDir(root, key string) error {
+	dir := filepath.Join(root, key)
+	if err := os.Remove(dir + jdkCacheManifestSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return removeReadOnlyDir(dir)
+}
+
+// removeReadOnlyDir 恢复目录的写权限后删除
+func removeReadOnlyDir(dir string) error {
+	_ = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err == nil && info.IsDir() {
+			_ = os.Chmod(p, 0755)
+		}
+		return nil
+	})
+	return os.RemoveAll(dir)
+}
@@ -0,0 +1,319 @@
+package helper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/byzk-org/bypt-server/db"
+	"github.com/byzk-org/bypt-server/utils"
+	"github.com/byzk-org/bypt-server/vos"
+	"github.com/jinzhu/gorm"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// appJob 定时任务
+type appJob struct {
+	sync.Mutex
+	startInfo   *vos.DbAppStartInfo
+	schedule    *utils.CronSchedule
+	runs        map[string]*appJobRun
+	isStarting  bool
+	isStop      bool
+	stopChannel chan bool
+	prevTime    time.Time
+	nextTime    time.Time
+}
+
+// appJobRun 定时任务的单次运行
+type appJobRun struct {
+	record     *vos.DbAppJobRecord
+	statusInfo *AppStatusInfo
+	isReplaced bool
+	isTimeout  bool
+	isStop     bool
+}
+
+// JobList 定时任务列表
+func (a *appRunMgr) JobList() []byte {
+	a.Lock()
+	defer a.Unlock()
+	endList := make([]*AppJobInfo, 0, len(a.jobMap))
+	for name, job := range a.jobMap {
+		job.Lock()
+		endList = append(endList, &AppJobInfo{
+			Name:       name,
+			Version:    job.startInfo.Version,
+			Job:        job.startInfo.Job,
+			PrevTime:   job.prevTime,
+			NextTime:   job.nextTime,
+			RunningNum: len(job.runs),
+		})
+		job.Unlock()
+	}
+	marshal, _ := json.Marshal(endList)
+	return marshal
+}
+
+// TriggerJob 手动触发定时任务
+func (a *appRunMgr) TriggerJob(appName string) error {
+	a.Lock()
+	job, ok := a.jobMap[appName]
+	a.Unlock()
+	if !ok {
+		return errors.New("未找到对应的定时任务")
+	}
+	return a.triggerJob(job, vos.AppJobTriggerManual)
+}
+
+// startJob 注册定时任务
+func (a *appRunMgr) startJob(appStartInfo *vos.DbAppStartInfo) error {
+	jobConfig := appStartInfo.Job
+	schedule, err := utils.ParseCron(jobConfig.Schedule)
+	if err != nil {
+		return err
+	}
+
+	switch jobConfig.ConcurrencyPolicy {
+	case "":
+		jobConfig.ConcurrencyPolicy = vos.AppJobConcurrencyForbid
+	case vos.AppJobConcurrencyForbid:
+	case vos.AppJobConcurrencyReplace:
+	case vos.AppJobConcurrencyAllow:
+	default:
+		return errors.New("未识别的任务并发策略")
+	}
+
+	if jobConfig.Timeout < 0 {
+		return errors.New("任务超时时间不能小于0")
+	}
+
+	if _, err = resolveRunCredential(appStartInfo); err != nil {
+		return err
+	}
+
+	if err = checkSandboxConfig(appStartInfo.Sandbox); err != nil {
+		return err
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	appInfo := &vos.DbAppInfo{}
+	if err = db.GetDb().Model(&vos.DbAppInfo{}).Where(&vos.DbAppInfo{
+		Name: appStartInfo.Name,
+	}).First(&appInfo).Error; err != nil || appInfo.Name == "" {
+		return errors.New("未查询到相关app信息")
+	}
+
+	if appStartInfo.Version == "" {
+		appStartInfo.Version = appInfo.CurrentVersion
+	}
+
+	count := 0
+	if err = db.GetDb().Model(&vos.DbAppVersionInfo{}).Where(&vos.DbAppVersionInfo{
+		AppName: appInfo.Name,
+		Name:    appStartInfo.Version,
+	}).Count(&count).Error; err != nil || count == 0 {
+		return errors.New("获取要启动的版本名称失败")
+	}
+
+	appStartInfo.RunDir = ""
+	fillStartInfoBytes(appStartInfo)
+	if err = db.GetDb().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&vos.DbAppInfo{}).Where(&vos.DbAppInfo{
+			Name: appInfo.Name,
+		}).Update(&vos.DbAppInfo{
+			CurrentVersion: appStartInfo.Version,
+		}).Error; err != nil {
+			return errors.New("更新应用信息失败")
+		}
+		return a.saveStartInfo(tx, appStartInfo)
+	}); err != nil {
+		return err
+	}
+
+	job := &appJob{
+		startInfo:   appStartInfo,
+		schedule:    schedule,
+		runs:        make(map[string]*appJobRun),
+		stopChannel: make(chan bool),
+	}
+	a.jobMap[appStartInfo.Name] = job
+	go a.runJobSchedule(job)
+	return nil
+}
+
+// stopJob 停止定时任务以及正在运行的任务, 调用方需持有管理器锁
+func (a *appRunMgr) stopJob(job *appJob) error {
+	appName := job.startInfo.Name
+	if err := db.GetDb().Model(&vos.DbAppStartInfo{}).Where(&vos.DbAppStartInfo{
+		Name: appName,
+	}).Delete(&vos.DbAppStartInfo{}).Error; err != nil {
+		return errors.New("删除应用启动信息失败")
+	}
+
+	job.Lock()
+	defer job.Unlock()
+	if !job.isStop {
+		job.isStop = true
+		close(job.stopChannel)
+	}
+
+	for _, run := range job.runs {
+		run.isStop = true
+		a.settingErrStatus("任务被停止", run.statusInfo, appRunErrTypeData)
+	}
+	delete(a.jobMap, appName)
+	return nil
+}
+
+// runJobSchedule 按照cron表达式调度任务
+func (a *appRunMgr) runJobSchedule(job *appJob) {
+	defer func() { recover() }()
+	for {
+		next := job.schedule.Next(time.Now())
+		if next.IsZero() {
+			return
+		}
+
+		job.Lock()
+		job.nextTime = next
+		job.Unlock()
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-job.stopChannel:
+			timer.Stop()
+			return
+		case <-timer.C:
+			go func() { _ = a.triggerJob(job, vos.AppJobTriggerSchedule) }()
+		}
+	}
+}
+
+// triggerJob 按照并发策略运行一次任务
+func (a *appRunMgr) triggerJob(job *appJob, trigger vos.AppJobTriggerType) error {
+	job.Lock()
+	if job.isStop {
+		job.Unlock()
+		return errors.New("定时任务已停止")
+	}
+
+	if job.isStarting {
+		job.Unlock()
+		return errors.New("任务正在启动, 跳过本次运行")
+	}
+
+	if len(job.runs) > 0 {
+		switch job.startInfo.Job.ConcurrencyPolicy {
+		case vos.AppJobConcurrencyForbid:
+			job.Unlock()
+			return errors.New("任务上一次运行尚未结束, 跳过本次运行")
+		case vos.AppJobConcurrencyReplace:
+			for _, run := range job.runs {
+				run.isReplaced = true
+				a.settingErrStatus("被新的运行替换", run.statusInfo, appRunErrTypeData)
+			}
+		}
+	}
+
+	job.isStarting = true
+	job.prevTime = time.Now()
+	runStartInfo := *job.startInfo
+	runStartInfo.JdkArgs = nil
+	job.Unlock()
+
+	now := time.Now()
+	record := &vos.DbAppJobRecord{
+		Id:         fmt.Sprintf("%s-%d", runStartInfo.Name, now.UnixNano()),
+		AppName:    runStartInfo.Name,
+		AppVersion: runStartInfo.Version,
+		Trigger:    trigger,
+		Status:     vos.AppJobRunStatusRunning,
+		ExitCode:   -1,
+		StartTime:  now,
+	}
+
+	statusInfo, err := a.startApp(&runStartInfo, appStartModeJob)
+
+	job.Lock()
+	defer job.Unlock()
+	job.isStarting = false
+	if err == nil && job.isStop {
+		err = errors.New("定时任务已停止")
+	}
+
+	if err != nil {
+		if statusInfo != nil {
+			a.settingErrStatus(err.Error(), statusInfo, appRunErrTypeData)
+		}
+		record.Status = vos.AppJobRunStatusFail
+		record.Msg = err.Error()
+		record.EndTime = time.Now()
+		_ = db.GetDb().Create(record).Error
+		return err
+	}
+
+	record.LogPath = filepath.Join(runStartInfo.LogDir, runStartInfo.Name, runStartInfo.Version, "main.log")
+	if err = db.GetDb().Create(record).Error; err != nil {
+		a.settingErrStatus("保存任务运行记录失败", statusInfo, appRunErrTypeData)
+		return errors.New("保存任务运行记录失败")
+	}
+
+	run := &appJobRun{
+		record:     record,
+		statusInfo: statusInfo,
+	}
+	job.runs[record.Id] = run
+	go a.waitJobRun(job, run)
+	return nil
+}
+
+// waitJobRun 等待任务运行结束并记录结果
+func (a *appRunMgr) waitJobRun(job *appJob, run *appJobRun) {
+	defer func() { recover() }()
+
+	var timeoutChan <-chan time.Time
+	if timeout := job.startInfo.Job.Timeout; timeout > 0 {
+		timer := time.NewTimer(time.Duration(timeout) * time.Second)
+		defer timer.Stop()
+		timeoutChan = timer.C
+	}
+
+	select {
+	case <-run.statusInfo.exitChannel:
+	case <-timeoutChan:
+		job.Lock()
+		run.isTimeout = true
+		job.Unlock()
+		a.settingErrStatus("运行超时", run.statusInfo, appRunErrTypeData)
+	}
+
+	job.Lock()
+	defer job.Unlock()
+	delete(job.runs, run.record.Id)
+
+	record := run.record
+	record.EndTime = time.Now()
+	record.ExitCode = run.statusInfo.ExitCode
+	switch {
+	case run.isReplaced:
+		record.Status = vos.AppJobRunStatusReplaced
+		record.ExitCode = -1
+	case run.isTimeout:
+		record.Status = vos.AppJobRunStatusTimeout
+		record.ExitCode = -1
+	case run.isStop:
+		record.Status = vos.AppJobRunStatusFail
+		record.Msg = "任务被停止"
+		record.ExitCode = -1
+	case run.statusInfo.HaveErr:
+		record.Status = vos.AppJobRunStatusFail
+		record.Msg = run.statusInfo.ErrMsg
+	default:
+		record.Status = vos.AppJobRunStatusSuccess
+	}
+	_ = db.GetDb().Save(record).Error
+}
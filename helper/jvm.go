@@ -0,0 +1,347 @@
+package helper
+
+import (
+	"errors"
+	"github.com/byzk-org/bypt-server/vos"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// jvmSizePattern 内存大小格式, 如 512m、2g
+var jvmSizePattern = regexp.MustCompile(`^[0-9]+[kKmMgGtT]?$`)
+
+// jvmGcInfo 垃圾收集器参数及支持的java版本
+type jvmGcInfo struct {
+	flag       string
+	minVersion int
+	// maxVersion 最后支持的版本, 0为不限制
+	maxVersion int
+	// experimentalBefore 低于该版本时为实验特性, 需要解锁实验参数
+	experimentalBefore int
+}
+
+var jvmGcInfos = map[string]*jvmGcInfo{
+	"serial":     {flag: "-XX:+UseSerialGC"},
+	"parallel":   {flag: "-XX:+UseParallelGC"},
+	"cms":        {flag: "-XX:+UseConcMarkSweepGC", maxVersion: 13},
+	"g1":         {flag: "-XX:+UseG1GC", minVersion: 7},
+	"zgc":        {flag: "-XX:+UseZGC", minVersion: 11, experimentalBefore: 15},
+	"shenandoah": {flag: "-XX:+UseShenandoahGC", minVersion: 12, experimentalBefore: 15},
+}
+
+// checkJvmOptions 校验JVM参数格式, 与jdk版本相关的校验在确定所用jdk后进行
+func checkJvmOptions(startInfo *vos.DbAppStartInfo, isCmd bool) error {
+	if isCmd {
+		if startInfo.JvmOptions != nil {
+			return errors.New("非java程序不支持JVM参数")
+		}
+		return nil
+	}
+
+	opts := effectiveJvmOptions(startInfo)
+	sizes := []struct {
+		name string
+		val  string
+	}{
+		{"xms", opts.Xms},
+		{"xmx", opts.Xmx},
+		{"xmn", opts.Xmn},
+		{"xss", opts.Xss},
+		{"metaspaceSize", opts.MetaspaceSize},
+		{"maxMetaspaceSize", opts.MaxMetaspaceSize},
+		{"permSize", startInfo.PermSize},
+		{"maxPermSize", startInfo.MaxPermSize},
+	}
+	for _, s := range sizes {
+		if s.val != "" && !jvmSizePattern.MatchString(s.val) {
+			return errors.New("JVM参数 [" + s.name + "] 的内存大小 [" + s.val + "] 格式不正确, 如 512m、2g")
+		}
+	}
+
+	if opts.Xms != "" && opts.Xmx != "" && parseJvmSize(opts.Xms) > parseJvmSize(opts.Xmx) {
+		return errors.New("初始堆内存(xms)不能大于最大堆内存(xmx)")
+	}
+
+	if opts.Xmn != "" && opts.Xmx != "" && parseJvmSize(opts.Xmn) >= parseJvmSize(opts.Xmx) {
+		return errors.New("新生代大小(xmn)必须小于最大堆内存(xmx)")
+	}
+
+	if opts.MetaspaceSize != "" && opts.MaxMetaspaceSize != "" && parseJvmSize(opts.MetaspaceSize) > parseJvmSize(opts.MaxMetaspaceSize) {
+		return errors.New("元空间初始大小不能大于元空间最大大小")
+	}
+
+	if opts.Gc != "" {
+		if _, ok := jvmGcInfos[opts.Gc]; !ok {
+			return errors.New("未识别的垃圾收集器 [" + opts.Gc + "], 可选 serial、parallel、cms、g1、zgc、shenandoah")
+		}
+	}
+
+	for k := range opts.Properties {
+		if k == "" || strings.ContainsAny(k, "= \t\r\n") {
+			return errors.New("系统属性名称 [" + k + "] 不合法")
+		}
+	}
+
+	for _, agent := range opts.JavaAgents {
+		if strings.SplitN(agent, "=", 2)[0] == "" {
+			return errors.New("javaagent [" + agent + "] 缺少jar路径")
+		}
+	}
+
+	for _, flag := range opts.ExtraFlags {
+		if !strings.HasPrefix(flag, "-") {
+			return errors.New("JVM参数 [" + flag + "] 必须以 - 开头")
+		}
+	}
+	return nil
+}
+
+// effectiveJvmOptions 合并结构化参数与单独设置的内存参数, 结构化参数优先
+func effectiveJvmOptions(startInfo *vos.DbAppStartInfo) *vos.AppJvmOptions {
+	opts := &vos.AppJvmOptions{}
+	if startInfo.JvmOptions != nil {
+		*opts = *startInfo.JvmOptions
+	}
+
+	if opts.Xms == "" {
+		opts.Xms = startInfo.Xms
+	}
+
+	if opts.Xmx == "" {
+		opts.Xmx = startInfo.Xmx
+	}
+
+	if opts.Xmn == "" {
+		opts.Xmn = startInfo.Xmn
+	}
+	return opts
+}
+
+// startJavaInfo 启动前确定的java命令及主版本
+type startJavaInfo struct {
+	javaCmd string
+	// major java主版本, 0为未确定
+	major int
+	// err 指定的jdk无效
+	err error
+	// majorErr 获取java主版本失败, 仅校验java应用的JVM参数时需要
+	majorErr error
+}
+
+// resolveStartJava 获取启动信息指定的java命令, 设置了JVM参数时同时获取其主版本,
+// 获取主版本可能需要执行 java -version, 需在加锁前调用
+func resolveStartJava(appStartInfo *vos.DbAppStartInfo) *startJavaInfo {
+	info := &startJavaInfo{javaCmd: "java"}
+	if appStartInfo.JdkPath != "" {
+		info.javaCmd = appStartInfo.JdkPath
+	}
+
+	if appStartInfo.Jdk != "" {
+		if appStartInfo.JdkPath != "" || appStartInfo.JdkPackName != "" {
+			info.err = errors.New("本地jdk不能与jdk路径或包内jdk同时指定")
+			return info
+		}
+
+		localJdk, err := resolveLocalJdk(appStartInfo.Jdk)
+		if err != nil {
+			info.err = err
+			return info
+		}
+		info.javaCmd = localJdk.JavaCmd
+		info.major = localJdk.Major
+	}
+
+	// 包内jdk需解压后才能确定版本, 在启动时获取
+	if info.major == 0 && appStartInfo.AdoptProcess == nil && appStartInfo.JdkPackName == "" && hasJvmArgs(appStartInfo) {
+		info.major, info.majorErr = javaMajorVersion(info.javaCmd)
+	}
+	return info
+}
+
+// hasJvmArgs 是否设置了需要渲染的JVM参数
+func hasJvmArgs(startInfo *vos.DbAppStartInfo) bool {
+	opts := effectiveJvmOptions(startInfo)
+	return startInfo.PermSize != "" || startInfo.MaxPermSize != "" ||
+		opts.Xms != "" || opts.Xmx != "" || opts.Xmn != "" || opts.Xss != "" ||
+		opts.MetaspaceSize != "" || opts.MaxMetaspaceSize != "" || opts.Gc != "" ||
+		len(opts.Properties) > 0 || len(opts.JavaAgents) > 0 || len(opts.ExtraFlags) > 0
+}
+
+// checkJvmVersion 根据所用jdk的主版本校验JVM参数
+func checkJvmVersion(startInfo *vos.DbAppStartInfo, major int) error {
+	opts := effectiveJvmOptions(startInfo)
+	majorStr := strconv.Itoa(major)
+	if (opts.MetaspaceSize != "" || opts.MaxMetaspaceSize != "") && major < 8 {
+		return errors.New("java" + majorStr + "不支持元空间参数, 请使用permSize/maxPermSize")
+	}
+
+	if opts.Gc != "" {
+		gc := jvmGcInfos[opts.Gc]
+		if major < gc.minVersion {
+			return errors.New("垃圾收集器 [" + opts.Gc + "] 需要java" + strconv.Itoa(gc.minVersion) + "及以上版本, 当前为java" + majorStr)
+		}
+
+		if gc.maxVersion > 0 && major > gc.maxVersion {
+			return errors.New("垃圾收集器 [" + opts.Gc + "] 已在java" + strconv.Itoa(gc.maxVersion+1) + "中移除, 当前为java" + majorStr)
+		}
+	}
+	return nil
+}
+
+// renderJvmArgs 将JVM参数渲染为命令行参数, 启动前未能确定jdk版本(包内jdk)时在此校验,
+// java8及以上已移除永久代, permSize/maxPermSize将被忽略并记录告警事件
+func renderJvmArgs(appStatusInfo *AppStatusInfo) ([]string, error) {
+	startInfo := appStatusInfo.StartArgs
+	if !hasJvmArgs(startInfo) {
+		return nil, nil
+	}
+
+	major := appStatusInfo.javaMajor
+	if major == 0 {
+		var err error
+		if major, err = javaMajorVersion(appStatusInfo.JavaCmd); err != nil {
+			return nil, err
+		}
+
+		if err = checkJvmVersion(startInfo, major); err != nil {
+			return nil, err
+		}
+		appStatusInfo.javaMajor = major
+	}
+
+	opts := effectiveJvmOptions(startInfo)
+	usePerm := major < 8
+	if !usePerm && (startInfo.PermSize != "" || startInfo.MaxPermSize != "") {
+		appStatusInfo.recordEvent(vos.AppEventJvmWarning, "java"+strconv.Itoa(major)+"已移除永久代, 已忽略permSize/maxPermSize, 请使用元空间参数(metaspaceSize/maxMetaspaceSize)代替")
+	}
+
+	args := make([]string, 0, 16)
+	if opts.Xms != "" {
+		args = append(args, "-Xms"+opts.Xms)
+	}
+
+	if opts.Xmx != "" {
+		args = append(args, "-Xmx"+opts.Xmx)
+	}
+
+	if opts.Xmn != "" {
+		args = append(args, "-Xmn"+opts.Xmn)
+	}
+
+	if opts.Xss != "" {
+		args = append(args, "-Xss"+opts.Xss)
+	}
+
+	if usePerm && startInfo.PermSize != "" {
+		args = append(args, "-XX:PermSize="+startInfo.PermSize)
+	}
+
+	if usePerm && startInfo.MaxPermSize != "" {
+		args = append(args, "-XX:MaxPermSize="+startInfo.MaxPermSize)
+	}
+
+	if opts.MetaspaceSize != "" {
+		args = append(args, "-XX:MetaspaceSize="+opts.MetaspaceSize)
+	}
+
+	if opts.MaxMetaspaceSize != "" {
+		args = append(args, "-XX:MaxMetaspaceSize="+opts.MaxMetaspaceSize)
+	}
+
+	if opts.Gc != "" {
+		gc := jvmGcInfos[opts.Gc]
+		if major < gc.experimentalBefore {
+			args = append(args, "-XX:+UnlockExperimentalVMOptions")
+		}
+		args = append(args, gc.flag)
+	}
+
+	keys := make([]string, 0, len(opts.Properties))
+	for k := range opts.Properties {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		args = append(args, "-D"+k+"="+opts.Properties[k])
+	}
+
+	for _, agent := range opts.JavaAgents {
+		agentInfo := strings.SplitN(agent, "=", 2)
+		agentPath := agentInfo[0]
+		if !filepath.IsAbs(agentPath) {
+			agentPath = filepath.Join(appStatusInfo.runDir, agentPath)
+		}
+
+		if _, err := os.Stat(agentPath); err != nil {
+			return nil, errors.New("javaagent [" + agentInfo[0] + "] 不存在")
+		}
+
+		agentArg := "-javaagent:" + agentPath
+		if len(agentInfo) == 2 {
+			agentArg += "=" + agentInfo[1]
+		}
+		args = append(args, agentArg)
+	}
+
+	return append(args, opts.ExtraFlags...), nil
+}
+
+// parseJvmSize 将内存大小转换为字节数
+func parseJvmSize(size string) int64 {
+	unit := int64(1)
+	switch size[len(size)-1] {
+	case 'k', 'K':
+		unit = 1 << 10
+	case 'm', 'M':
+		unit = 1 << 20
+	case 'g', 'G':
+		unit = 1 << 30
+	case 't', 'T':
+		unit = 1 << 40
+	}
+
+	if unit > 1 {
+		size = size[:len(size)-1]
+	}
+
+	n, _ := strconv.ParseInt(size, 10, 64)
+	return n * unit
+}
+
+// javaMajorVersion 获取java命令的主版本
+func javaMajorVersion(javaCmd string) (int, error) {
+	p, err := exec.LookPath(javaCmd)
+	if err != nil {
+		return 0, errors.New("未找到java命令 [" + javaCmd + "]")
+	}
+
+	if realPath, err := filepath.EvalSymlinks(p); err == nil {
+		p = realPath
+	}
+
+	jdk, err := probeJdk(filepath.Dir(filepath.Dir(p)))
+	if err != nil {
+		return 0, errors.New("获取java版本失败")
+	}
+	return jdk.Major, nil
+}
+
+// parseJavaMajorVersion 解析主版本, 兼容 1.8.0_292 及 17.0.1 两种格式
+func parseJavaMajorVersion(version string) (int, error) {
+	version = strings.TrimPrefix(version, "1.")
+	end := 0
+	for end < len(version) && version[end] >= '0' && version[end] <= '9' {
+		end++
+	}
+
+	major, err := strconv.Atoi(version[:end])
+	if err != nil {
+		return 0, errors.New("无法识别的java版本 [" + version + "]")
+	}
+	return major, nil
+}
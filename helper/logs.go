@@ -9,6 +9,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sync"
 	"time"
 )
@@ -90,20 +91,17 @@ type appLogs struct {
 	tmpBuf         []byte
 	tmpLogInfo     *vos.DbLog
 	LogRefreshChan chan time.Time
+	// lineHandler 每一行完整日志的回调
+	lineHandler func(line []byte)
+	// outputHandler 原始输出的回调
+	outputHandler func(p []byte)
 }
 
 func (a *appLogs) Write(p []byte) (int, error) {
 	a.Lock()
 	defer a.Unlock()
-	dataIsClose := false
-	if a.dbLog.DB().Stats().OpenConnections == 0 {
-		open, err := gorm.Open("sqlite3", a.openPath)
-		if err != nil {
-			dataIsClose = true
-		} else {
-			a.dbLog = open
-			defer a.dbLog.Close()
-		}
+	if a.outputHandler != nil {
+		a.outputHandler(p)
 	}
 	split := bytes.Split(p, splitRune)
 	endLen := len(split) - 1
@@ -124,10 +122,44 @@ func (a *appLogs) Write(p []byte) (int, error) {
 		}, nil)
 	}
 
+	if a.lineHandler != nil {
+		for i := 0; i < endLen; i++ {
+			a.lineHandler(split[i])
+		}
+	}
+	a.writeLines("", split[:endLen])
+
+	return len(p), nil
+}
+
+// saveLines 保存其他来源(如插件)的日志行
+func (a *appLogs) saveLines(source string, lines [][]byte) {
+	a.Lock()
+	defer a.Unlock()
+	a.writeLines(source, lines)
+}
+
+// writeLines 保存日志行, 调用方需持有锁
+func (a *appLogs) writeLines(source string, lines [][]byte) {
+	if len(lines) == 0 {
+		return
+	}
+
+	dataIsClose := false
+	if a.dbLog.DB().Stats().OpenConnections == 0 {
+		open, err := gorm.Open("sqlite3", a.openPath)
+		if err != nil {
+			dataIsClose = true
+		} else {
+			a.dbLog = open
+			defer a.dbLog.Close()
+		}
+	}
+
+	a.tmpLogInfo.Source = source
 	a.dbLog.Transaction(func(tx *gorm.DB) error {
 		logModel := tx.Model(&vos.DbLog{})
-		for i := 0; i < endLen; i++ {
-			content := split[i]
+		for _, content := range lines {
 			a.tmpLogInfo.Content = content
 			a.tmpLogInfo.AtDate = time.Now().UnixNano()
 			if dataIsClose {
@@ -142,8 +174,6 @@ func (a *appLogs) Write(p []byte) (int, error) {
 		}
 		return nil
 	})
-
-	return len(p), nil
 }
 
 func (a *appLogs) Close() error {
@@ -155,3 +185,48 @@ func (a *appLogs) Close() error {
 	return nil
 	//return nil
 }
+
+// logWatcher 日志匹配监听
+type logWatcher struct {
+	pattern   *regexp.Regexp
+	matchChan chan []byte
+}
+
+// addLogWatcher 添加日志匹配监听, 日志行匹配时向返回的通道发送该行
+func (a *AppStatusInfo) addLogWatcher(pattern *regexp.Regexp) *logWatcher {
+	a.logWatcherLock.Lock()
+	defer a.logWatcherLock.Unlock()
+	watcher := &logWatcher{
+		pattern:   pattern,
+		matchChan: make(chan []byte, 1),
+	}
+	a.logWatchers = append(a.logWatchers, watcher)
+	return watcher
+}
+
+// removeLogWatcher 移除日志匹配监听
+func (a *AppStatusInfo) removeLogWatcher(watcher *logWatcher) {
+	a.logWatcherLock.Lock()
+	defer a.logWatcherLock.Unlock()
+	for i, w := range a.logWatchers {
+		if w == watcher {
+			a.logWatchers = append(a.logWatchers[:i], a.logWatchers[i+1:]...)
+			return
+		}
+	}
+}
+
+// handleLogLine 将日志行分发给日志匹配监听
+func (a *AppStatusInfo) handleLogLine(line []byte) {
+	a.logWatcherLock.Lock()
+	defer a.logWatcherLock.Unlock()
+	for _, w := range a.logWatchers {
+		if !w.pattern.Match(line) {
+			continue
+		}
+		select {
+		case w.matchChan <- line:
+		default:
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package helper
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/byzk-org/bypt-server/db"
+	"github.com/byzk-org/bypt-server/vos"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// notifyDefaultDedupWindow 默认去重时间, 单位秒
+	notifyDefaultDedupWindow int64 = 300
+	// notifyWebhookRetry webhook发送失败时的重试次数
+	notifyWebhookRetry = 3
+	// notifySendTimeout 单次发送超时时间
+	notifySendTimeout = 30 * time.Second
+)
+
+// notifyDefaultEventTypes 规则未指定事件类型时通知的事件
+var notifyDefaultEventTypes = []vos.AppEventType{
+	vos.AppEventExited,
+	vos.AppEventPluginFailed,
+	vos.AppEventRestartScheduled,
+	vos.AppEventSignFailed,
+}
+
+var appNotifier = &notifier{
+	lastSent: make(map[string]time.Time),
+	sentLogs: make(map[string][]time.Time),
+}
+
+// notifier 按规则分发事件通知, 负责去重和限流
+type notifier struct {
+	sync.Mutex
+	// lastSent 规则、应用、事件及消息最后一次通知时间
+	lastSent map[string]time.Time
+	// sentLogs 规则及应用最近一小时的通知时间
+	sentLogs map[string][]time.Time
+}
+
+// SaveNotifyRule 保存通知规则, 同名规则将被替换
+func SaveNotifyRule(rule *vos.DbNotifyRule) error {
+	if err := checkNotifyRule(rule); err != nil {
+		return err
+	}
+
+	rule.EventTypesBytes = nil
+	if len(rule.EventTypes) > 0 {
+		rule.EventTypesBytes, _ = json.Marshal(rule.EventTypes)
+	}
+
+	rule.SmtpBytes = nil
+	if rule.Smtp != nil {
+		rule.SmtpBytes, _ = json.Marshal(rule.Smtp)
+	}
+	rule.CreateTime = time.Now()
+
+	return db.GetDb().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where(&vos.DbNotifyRule{
+			Name: rule.Name,
+		}).Delete(&vos.DbNotifyRule{}).Error; err != nil {
+			return errors.New("删除原有通知规则失败")
+		}
+
+		if err := tx.Create(rule).Error; err != nil {
+			return errors.New("保存通知规则失败")
+		}
+		return nil
+	})
+}
+
+// QueryNotifyRules 查询全部通知规则
+func QueryNotifyRules() ([]*vos.DbNotifyRule, error) {
+	rules := make([]*vos.DbNotifyRule, 0)
+	if err := db.GetDb().Model(&vos.DbNotifyRule{}).Order("name").Find(&rules).Error; err != nil && err != gorm.ErrRecordNotFound {
+		return nil, errors.New("查询通知规则失败")
+	}
+
+	for _, rule := range rules {
+		if len(rule.EventTypesBytes) > 0 {
+			_ = json.Unmarshal(rule.EventTypesBytes, &rule.EventTypes)
+		}
+
+		if len(rule.SmtpBytes) > 0 {
+			_ = json.Unmarshal(rule.SmtpBytes, &rule.Smtp)
+		}
+	}
+	return rules, nil
+}
+
+// TestNotifyRule 使用测试事件立即发送一次通知, 不经过去重及限流
+func TestNotifyRule(name string) error {
+	rules, err := QueryNotifyRules()
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range rules {
+		if rule.Name != name {
+			continue
+		}
+		return sendNotify(rule, newNotifyPayload(rule, &vos.DbAppEvent{
+			AppName:    rule.AppName,
+			Type:       vos.AppEventExited,
+			Msg:        "测试通知",
+			CreateTime: time.Now(),
+		}))
+	}
+	return errors.New("未找到对应的通知规则")
+}
+
+// checkNotifyRule 校验通知规则
+func checkNotifyRule(rule *vos.DbNotifyRule) error {
+	if rule.Name == "" {
+		return errors.New("通知规则名称不能为空")
+	}
+
+	switch rule.Channel {
+	case vos.NotifyChannelWebhook:
+		if !strings.HasPrefix(rule.Target, "http://") && !strings.HasPrefix(rule.Target, "https://") {
+			return errors.New("webhook地址必须以http://或https://开头")
+		}
+	case vos.NotifyChannelExec:
+		if rule.Target == "" {
+			return errors.New("通知命令不能为空")
+		}
+	case vos.NotifyChannelEmail:
+		if rule.Smtp == nil || rule.Smtp.Host == "" || rule.Smtp.From == "" || len(rule.Smtp.To) == 0 {
+			return errors.New("邮件通知缺失smtp地址、发件人或收件人")
+		}
+	default:
+		return errors.New("不支持的通知方式 [" + string(rule.Channel) + "]")
+	}
+
+	if rule.DedupWindow < 0 || rule.MaxPerHour < 0 {
+		return errors.New("去重时间及通知次数不能小于0")
+	}
+	return nil
+}
+
+// notifyAppEvent 将事件分发给匹配的通知规则
+func (n *notifier) notifyAppEvent(event *vos.DbAppEvent) {
+	rules, err := QueryNotifyRules()
+	if err != nil || len(rules) == 0 {
+		return
+	}
+
+	for _, rule := range rules {
+		if !notifyRuleMatch(rule, event) || !n.allow(rule, event) {
+			continue
+		}
+
+		go func(rule *vos.DbNotifyRule) {
+			if err := sendNotify(rule, newNotifyPayload(rule, event)); err != nil {
+				logrus.Error("通知规则[" + rule.Name + "]发送失败 => " + err.Error())
+			}
+		}(rule)
+	}
+}
+
+// allow 检查事件是否在去重时间内已通知或超过每小时通知次数, 允许发送时记录本次通知
+func (n *notifier) allow(rule *vos.DbNotifyRule, event *vos.DbAppEvent) bool {
+	n.Lock()
+	defer n.Unlock()
+
+	now := time.Now()
+	dedupWindow := rule.DedupWindow
+	if dedupWindow == 0 {
+		dedupWindow = notifyDefaultDedupWindow
+	}
+
+	dedupKey := strings.Join([]string{rule.Name, event.AppName, string(event.Type), event.Msg}, "\x00")
+	if last, ok := n.lastSent[dedupKey]; ok && now.Sub(last) < time.Duration(dedupWindow)*time.Second {
+		return false
+	}
+
+	rateKey := rule.Name + "\x00" + event.AppName
+	sentLogs := n.sentLogs[rateKey]
+	for len(sentLogs) > 0 && now.Sub(sentLogs[0]) >= time.Hour {
+		sentLogs = sentLogs[1:]
+	}
+
+	if rule.MaxPerHour > 0 && len(sentLogs) >= rule.MaxPerHour {
+		n.sentLogs[rateKey] = sentLogs
+		return false
+	}
+
+	n.lastSent[dedupKey] = now
+	n.sentLogs[rateKey] = append(sentLogs, now)
+
+	for k, t := range n.lastSent {
+		if now.Sub(t) >= time.Hour && now.Sub(t) >= time.Duration(dedupWindow)*time.Second {
+			delete(n.lastSent, k)
+		}
+	}
+	return true
+}
+
+// notifyRuleMatch 判断规则是否匹配事件
+func notifyRuleMatch(rule *vos.DbNotifyRule, event *vos.DbAppEvent) bool {
+	if rule.AppName != "" && rule.AppName != event.AppName {
+		return false
+	}
+
+	eventTypes := rule.EventTypes
+	if len(eventTypes) == 0 {
+		eventTypes = notifyDefaultEventTypes
+	}
+
+	for _, t := range eventTypes {
+		if t == event.Type {
+			return true
+		}
+	}
+	return false
+}
+
+func newNotifyPayload(rule *vos.DbNotifyRule, event *vos.DbAppEvent) *vos.NotifyPayload {
+	hostname, _ := os.Hostname()
+	return &vos.NotifyPayload{
+		Rule:       rule.Name,
+		Host:       hostname,
+		AppName:    event.AppName,
+		AppVersion: event.AppVersion,
+		Type:       event.Type,
+		ExitCode:   event.ExitCode,
+		Signal:     event.Signal,
+		Msg:        event.Msg,
+		Time:       event.CreateTime,
+	}
+}
+
+// sendNotify 按规则的通知方式发送
+func sendNotify(rule *vos.DbNotifyRule, payload *vos.NotifyPayload) error {
+	switch rule.Channel {
+	case vos.NotifyChannelWebhook:
+		return sendNotifyWebhook(rule.Target, payload)
+	case vos.NotifyChannelExec:
+		return sendNotifyExec(rule.Target, payload)
+	case vos.NotifyChannelEmail:
+		return sendNotifyEmail(rule.Smtp, payload)
+	default:
+		return errors.New("不支持的通知方式")
+	}
+}
+
+// sendNotifyWebhook 发送webhook, 失败时按1s、2s、4s间隔重试
+func sendNotifyWebhook(url string, payload *vos.NotifyPayload) error {
+	body, _ := json.Marshal(payload)
+	client := &http.Client{Timeout: notifySendTimeout}
+
+	var err error
+	for i := 0; i <= notifyWebhookRetry; i++ {
+		if i > 0 {
+			time.Sleep(time.Duration(1<<(i-1)) * time.Second)
+		}
+
+		var resp *http.Response
+		resp, err = client.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			continue
+		}
+		_ = resp.Body.Close()
+
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil
+		}
+		err = errors.New("webhook返回状态码 " + strconv.Itoa(resp.StatusCode))
+	}
+	return err
+}
+
+// sendNotifyExec 执行本地命令, 事件内容以json写入标准输入
+func sendNotifyExec(command string, payload *vos.NotifyPayload) error {
+	body, _ := json.Marshal(payload)
+	ctx, cancel := context.WithTimeout(context.Background(), notifySendTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, command)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(),
+		"BYPT_NOTIFY_RULE="+payload.Rule,
+		"BYPT_EVENT_APP="+payload.AppName,
+		"BYPT_EVENT_VERSION="+payload.AppVersion,
+		"BYPT_EVENT_TYPE="+string(payload.Type),
+		"BYPT_EVENT_EXIT_CODE="+strconv.Itoa(payload.ExitCode),
+		"BYPT_EVENT_SIGNAL="+payload.Signal,
+		"BYPT_EVENT_MSG="+payload.Msg,
+	)
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return errors.New(err.Error() + " => " + string(bytes.TrimSpace(output)))
+	}
+	return nil
+}
+
+// sendNotifyEmail 发送邮件通知
+func sendNotifyEmail(smtpConfig *vos.NotifySmtpConfig, payload *vos.NotifyPayload) error {
+	if smtpConfig == nil {
+		return errors.New("缺失smtp配置")
+	}
+
+	port := smtpConfig.Port
+	if port == 0 {
+		port = 25
+	}
+	addr := net.JoinHostPort(smtpConfig.Host, strconv.Itoa(port))
+
+	var auth smtp.Auth
+	if smtpConfig.Username != "" {
+		password, err := resolveSecretRefs(smtpConfig.Password)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", smtpConfig.Username, password, smtpConfig.Host)
+	}
+
+	subject := fmt.Sprintf("[bypt] %s 应用 %s 事件: %s", payload.Host, payload.AppName, payload.Type)
+	msg := &bytes.Buffer{}
+	msg.WriteString("From: " + smtpConfig.From + "\r\n")
+	msg.WriteString("To: " + strings.Join(smtpConfig.To, ", ") + "\r\n")
+	msg.WriteString("Subject: " + mime.BEncoding.Encode("UTF-8", subject) + "\r\n")
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	fmt.Fprintf(msg, "主机: %s\r\n应用: %s\r\n版本: %s\r\n事件: %s\r\n退出码: %d\r\n", payload.Host, payload.AppName, payload.AppVersion, payload.Type, payload.ExitCode)
+	if payload.Signal != "" {
+		fmt.Fprintf(msg, "信号: %s\r\n", payload.Signal)
+	}
+	fmt.Fprintf(msg, "时间: %s\r\n消息: %s\r\n", payload.Time.Format("2006-01-02 15:04:05"), payload.Msg)
+
+	return smtp.SendMail(addr, auth, smtpConfig.From, smtpConfig.To, msg.Bytes())
+}
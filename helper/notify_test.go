@@ -0,0 +1,320 @@
+package helper
+
+import (
+	"bufio"
+	"github.com/byzk-org/bypt-server/vos"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeSmtpServer 仅实现 SendMail 所需指令的smtp服务, 记录收到的信封及邮件内容
+type fakeSmtpServer struct {
+	listener net.Listener
+	from     string
+	to       []string
+	data     string
+	done     chan struct{}
+}
+
+func newFakeSmtpServer(t *testing.T) *fakeSmtpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听smtp端口失败 => %v", err)
+	}
+
+	s := &fakeSmtpServer{
+		listener: listener,
+		done:     make(chan struct{}),
+	}
+	go s.serve()
+	return s
+}
+
+func (s *fakeSmtpServer) serve() {
+	defer close(s.done)
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	tc := textproto.NewConn(conn)
+	_ = tc.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := tc.ReadLine()
+		if err != nil {
+			return
+		}
+
+		cmd := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			_ = tc.PrintfLine("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			s.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+			_ = tc.PrintfLine("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			s.to = append(s.to, strings.Trim(line[len("RCPT TO:"):], "<>"))
+			_ = tc.PrintfLine("250 OK")
+		case cmd == "DATA":
+			_ = tc.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := tc.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.data = string(data)
+			_ = tc.PrintfLine("250 OK")
+		case cmd == "QUIT":
+			_ = tc.PrintfLine("221 Bye")
+			return
+		default:
+			_ = tc.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+func TestSendNotifyEmail(t *testing.T) {
+	server := newFakeSmtpServer(t)
+	defer server.listener.Close()
+
+	addr := server.listener.Addr().(*net.TCPAddr)
+	err := sendNotifyEmail(&vos.NotifySmtpConfig{
+		Host: "127.0.0.1",
+		Port: addr.Port,
+		From: "bypt@example.com",
+		To:   []string{"ops@example.com", "dev@example.com"},
+	}, &vos.NotifyPayload{
+		Rule:       "email",
+		Host:       "host1",
+		AppName:    "demo",
+		AppVersion: "1.0.0",
+		Type:       vos.AppEventExited,
+		ExitCode:   137,
+		Signal:     "killed",
+		Msg:        "进程退出",
+		Time:       time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("发送邮件失败 => %v", err)
+	}
+
+	select {
+	case <-server.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("等待smtp会话结束超时")
+	}
+
+	if server.from != "bypt@example.com" {
+		t.Errorf("发件人为 %q", server.from)
+	}
+
+	if strings.Join(server.to, ",") != "ops@example.com,dev@example.com" {
+		t.Errorf("收件人为 %v", server.to)
+	}
+
+	for _, want := range []string{
+		"From: bypt@example.com",
+		"To: ops@example.com, dev@example.com",
+		"Subject: =?UTF-8?b?",
+		"应用: demo",
+		"退出码: 137",
+		"信号: killed",
+		"消息: 进程退出",
+	} {
+		if !strings.Contains(server.data, want) {
+			t.Errorf("邮件内容缺失 %q:\n%s", want, server.data)
+		}
+	}
+}
+
+func TestSendNotifyEmailRejected(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听smtp端口失败 => %v", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		w := bufio.NewWriter(conn)
+		_, _ = w.WriteString("554 No SMTP service here\r\n")
+		_ = w.Flush()
+	}()
+
+	err = sendNotifyEmail(&vos.NotifySmtpConfig{
+		Host: "127.0.0.1",
+		Port: listener.Addr().(*net.TCPAddr).Port,
+		From: "bypt@example.com",
+		To:   []string{"ops@example.com"},
+	}, &vos.NotifyPayload{AppName: "demo", Time: time.Now()})
+	if err == nil {
+		t.Fatal("smtp服务拒绝时应返回错误")
+	}
+}
+
+func TestSendNotifyWebhookRetry(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Content-Type为 %q", r.Header.Get("Content-Type"))
+		}
+
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	if err := sendNotifyWebhook(server.URL, &vos.NotifyPayload{AppName: "demo"}); err != nil {
+		t.Fatalf("重试后应发送成功 => %v", err)
+	}
+
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Errorf("请求次数为 %d, 期望 3", n)
+	}
+}
+
+func TestSendNotifyWebhookGiveUp(t *testing.T) {
+	if testing.Short() {
+		t.Skip("重试间隔共7秒")
+	}
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	err := sendNotifyWebhook(server.URL, &vos.NotifyPayload{AppName: "demo"})
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Fatalf("重试耗尽后应返回最后的状态码错误, 实际为 %v", err)
+	}
+
+	if n := atomic.LoadInt32(&calls); n != notifyWebhookRetry+1 {
+		t.Errorf("请求次数为 %d, 期望 %d", n, notifyWebhookRetry+1)
+	}
+}
+
+func newTestNotifier() *notifier {
+	return &notifier{
+		lastSent: make(map[string]time.Time),
+		sentLogs: make(map[string][]time.Time),
+	}
+}
+
+func TestNotifierAllowDedup(t *testing.T) {
+	n := newTestNotifier()
+	rule := &vos.DbNotifyRule{Name: "rule"}
+	event := &vos.DbAppEvent{AppName: "demo", Type: vos.AppEventExited, Msg: "退出"}
+
+	if !n.allow(rule, event) {
+		t.Fatal("首次通知应被允许")
+	}
+
+	if n.allow(rule, event) {
+		t.Fatal("去重时间内相同事件不应重复通知")
+	}
+
+	tests := []struct {
+		name  string
+		rule  *vos.DbNotifyRule
+		event *vos.DbAppEvent
+	}{
+		{"不同消息", rule, &vos.DbAppEvent{AppName: "demo", Type: vos.AppEventExited, Msg: "再次退出"}},
+		{"不同事件类型", rule, &vos.DbAppEvent{AppName: "demo", Type: vos.AppEventPluginFailed, Msg: "退出"}},
+		{"不同应用", rule, &vos.DbAppEvent{AppName: "other", Type: vos.AppEventExited, Msg: "退出"}},
+		{"不同规则", &vos.DbNotifyRule{Name: "rule2"}, event},
+	}
+	for _, tt := range tests {
+		if !n.allow(tt.rule, tt.event) {
+			t.Errorf("%s: 应被允许", tt.name)
+		}
+	}
+
+	// 超过去重时间后允许再次通知
+	key := strings.Join([]string{rule.Name, event.AppName, string(event.Type), event.Msg}, "\x00")
+	n.lastSent[key] = time.Now().Add(-time.Duration(notifyDefaultDedupWindow+1) * time.Second)
+	if !n.allow(rule, event) {
+		t.Fatal("超过默认去重时间后应允许再次通知")
+	}
+}
+
+func TestNotifierAllowCustomDedupWindow(t *testing.T) {
+	n := newTestNotifier()
+	rule := &vos.DbNotifyRule{Name: "rule", DedupWindow: 10}
+	event := &vos.DbAppEvent{AppName: "demo", Type: vos.AppEventExited}
+
+	if !n.allow(rule, event) {
+		t.Fatal("首次通知应被允许")
+	}
+
+	key := strings.Join([]string{rule.Name, event.AppName, string(event.Type), event.Msg}, "\x00")
+	n.lastSent[key] = time.Now().Add(-5 * time.Second)
+	if n.allow(rule, event) {
+		t.Fatal("去重时间内不应重复通知")
+	}
+
+	n.lastSent[key] = time.Now().Add(-11 * time.Second)
+	if !n.allow(rule, event) {
+		t.Fatal("超过规则去重时间后应允许再次通知")
+	}
+}
+
+func TestNotifierAllowRateLimit(t *testing.T) {
+	n := newTestNotifier()
+	rule := &vos.DbNotifyRule{Name: "rule", MaxPerHour: 2}
+	newEvent := func(app, msg string) *vos.DbAppEvent {
+		return &vos.DbAppEvent{AppName: app, Type: vos.AppEventExited, Msg: msg}
+	}
+
+	if !n.allow(rule, newEvent("demo", "1")) || !n.allow(rule, newEvent("demo", "2")) {
+		t.Fatal("未超过每小时次数时应被允许")
+	}
+
+	if n.allow(rule, newEvent("demo", "3")) {
+		t.Fatal("超过每小时次数后不应通知")
+	}
+
+	if !n.allow(rule, newEvent("other", "1")) {
+		t.Fatal("限流按应用计算, 其他应用应被允许")
+	}
+
+	// 一小时前的通知不计入限流
+	rateKey := rule.Name + "\x00" + "demo"
+	n.sentLogs[rateKey][0] = time.Now().Add(-time.Hour - time.Second)
+	if !n.allow(rule, newEvent("demo", "4")) {
+		t.Fatal("最早的通知超过一小时后应允许通知")
+	}
+
+	if n.allow(rule, newEvent("demo", "5")) {
+		t.Fatal("再次达到每小时次数后不应通知")
+	}
+
+	if l := len(n.sentLogs[rateKey]); l != 2 {
+		t.Errorf("限流记录数为 %d, 期望 2", l)
+	}
+}
+
+func TestNotifierAllowUnlimited(t *testing.T) {
+	n := newTestNotifier()
+	rule := &vos.DbNotifyRule{Name: "rule"}
+	for i := 0; i < 100; i++ {
+		if !n.allow(rule, &vos.DbAppEvent{AppName: "demo", Type: vos.AppEventExited, Msg: time.Duration(i).String()}) {
+			t.Fatalf("未设置每小时次数时第 %d 次通知被拒绝", i+1)
+		}
+	}
+}
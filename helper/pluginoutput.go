@@ -0,0 +1,203 @@
+package helper
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+)
+
+const (
+	// pluginOutputBufferSize 每个插件在内存中保留的最近输出大小
+	pluginOutputBufferSize = 64 * 1024
+	// pluginFollowBufferSize 每个跟踪客户端缓存的输出块数量, 客户端读取过慢时丢弃新的输出
+	pluginFollowBufferSize = 256
+)
+
+// ringBuffer 固定大小的环形缓冲区, 写满后覆盖最早的数据
+type ringBuffer struct {
+	buf   []byte
+	start int
+	size  int
+}
+
+func newRingBuffer(capacity int) *ringBuffer {
+	return &ringBuffer{buf: make([]byte, capacity)}
+}
+
+func (r *ringBuffer) Write(p []byte) {
+	capacity := len(r.buf)
+	if len(p) >= capacity {
+		copy(r.buf, p[len(p)-capacity:])
+		r.start = 0
+		r.size = capacity
+		return
+	}
+
+	end := (r.start + r.size) % capacity
+	n := copy(r.buf[end:], p)
+	copy(r.buf, p[n:])
+	r.size += len(p)
+	if r.size > capacity {
+		r.start = (r.start + r.size - capacity) % capacity
+		r.size = capacity
+	}
+}
+
+// Bytes 按写入顺序返回缓冲区中的数据
+func (r *ringBuffer) Bytes() []byte {
+	out := make([]byte, r.size)
+	end := r.start + r.size
+	if end > len(r.buf) {
+		end = len(r.buf)
+	}
+	n := copy(out, r.buf[r.start:end])
+	copy(out[n:], r.buf[:r.size-n])
+	return out
+}
+
+// pluginOutput 插件的输出, 内存中保留最近的输出, 完整的输出按行写入应用日志并分发给跟踪的客户端
+type pluginOutput struct {
+	sync.Mutex
+	name       string
+	statusInfo *AppStatusInfo
+	ring       *ringBuffer
+	tmpBuf     []byte
+	followers  []*PluginOutputFollow
+}
+
+// pluginOutput 获取插件的输出, 不存在时创建
+func (a *AppStatusInfo) pluginOutput(pluginName string) *pluginOutput {
+	a.pluginOutputLock.Lock()
+	defer a.pluginOutputLock.Unlock()
+	output, ok := a.pluginOutputs[pluginName]
+	if !ok {
+		output = &pluginOutput{
+			name:       pluginName,
+			statusInfo: a,
+			ring:       newRingBuffer(pluginOutputBufferSize),
+		}
+		a.pluginOutputs[pluginName] = output
+	}
+	return output
+}
+
+// pluginOutputBytes 插件最近的输出, 插件未运行过时返回nil
+func (a *AppStatusInfo) pluginOutputBytes(pluginName string) []byte {
+	a.pluginOutputLock.Lock()
+	output, ok := a.pluginOutputs[pluginName]
+	a.pluginOutputLock.Unlock()
+	if !ok {
+		return nil
+	}
+
+	output.Lock()
+	defer output.Unlock()
+	return output.ring.Bytes()
+}
+
+func (p *pluginOutput) Write(b []byte) (int, error) {
+	p.Lock()
+	defer p.Unlock()
+	p.ring.Write(b)
+
+	lines := bytes.Split(append(p.tmpBuf, b...), splitRune)
+	last := len(lines) - 1
+	p.tmpBuf = append([]byte(nil), lines[last]...)
+	// 长时间没有换行的输出按缓冲区大小截断为一行, 避免未完成的行无限增长
+	if len(p.tmpBuf) >= pluginOutputBufferSize {
+		lines[last] = p.tmpBuf
+		p.tmpBuf = nil
+		last++
+	}
+	if last > 0 {
+		if logs, ok := p.statusInfo.logCloser.(*appLogs); ok {
+			logs.saveLines(p.name, lines[:last])
+		}
+	}
+
+	if len(p.followers) > 0 {
+		output := make([]byte, len(b))
+		copy(output, b)
+		for _, f := range p.followers {
+			select {
+			case f.outputChan <- output:
+			default:
+			}
+		}
+	}
+	return len(b), nil
+}
+
+// writeMsg 记录插件运行的异常信息
+func (p *pluginOutput) writeMsg(msg string) {
+	_, _ = p.Write([]byte("\n" + strings.TrimSpace(msg) + "\n"))
+}
+
+// PluginOutputFollow 跟踪插件的实时输出
+type PluginOutputFollow struct {
+	output     *pluginOutput
+	outputChan chan []byte
+}
+
+// FollowPluginOutput 跟踪运行中应用的插件输出, 返回跟踪对象及插件最近的输出
+func (a *appRunMgr) FollowPluginOutput(appName, pluginName string) (*PluginOutputFollow, []byte, error) {
+	a.Lock()
+	statusInfo, ok := a.startAppMap[appName]
+	a.Unlock()
+	if !ok {
+		return nil, nil, errors.New("app未启动")
+	}
+
+	havePlugin := false
+	for _, plugin := range statusInfo.VersionInfo.PluginInfo {
+		if plugin.Name == pluginName {
+			havePlugin = true
+			break
+		}
+	}
+	if !havePlugin {
+		return nil, nil, errors.New("未找到插件 [" + pluginName + "]")
+	}
+
+	statusInfo.closeLock.Lock()
+	isClose := statusInfo.isClose
+	statusInfo.closeLock.Unlock()
+	if isClose {
+		return nil, nil, errors.New("应用已停止运行")
+	}
+
+	output := statusInfo.pluginOutput(pluginName)
+	follow := &PluginOutputFollow{
+		output:     output,
+		outputChan: make(chan []byte, pluginFollowBufferSize),
+	}
+
+	output.Lock()
+	defer output.Unlock()
+	output.followers = append(output.followers, follow)
+	return follow, output.ring.Bytes(), nil
+}
+
+// Output 插件的实时输出
+func (f *PluginOutputFollow) Output() <-chan []byte {
+	return f.outputChan
+}
+
+// Exit 应用退出时关闭
+func (f *PluginOutputFollow) Exit() <-chan string {
+	return f.output.statusInfo.exitChannel
+}
+
+// Close 停止跟踪
+func (f *PluginOutputFollow) Close() {
+	output := f.output
+	output.Lock()
+	defer output.Unlock()
+	for i, v := range output.followers {
+		if v == f {
+			output.followers = append(output.followers[:i], output.followers[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"github.com/byzk-org/bypt-server/vos"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// defaultPluginTimeout 默认before/after插件单次运行的超时时间, 单位秒
+const defaultPluginTimeout int64 = 300
+
+// pluginKillWait 超时结束插件后等待进程回收的时间, 脱离进程组的子进程仍持有输出时不再等待
+const pluginKillWait = 5 * time.Second
+
+// errPluginAppClosed 插件运行前应用已关闭, 不再重试
+var errPluginAppClosed = errors.New("应用已关闭")
+
+// CheckPluginRunPolicy 校验插件的超时时间及失败策略
+func CheckPluginRunPolicy(policy *vos.AppPluginRunPolicy) error {
+	if policy == nil {
+		return nil
+	}
+
+	if policy.Timeout < 0 {
+		return errors.New("插件超时时间不能小于0")
+	}
+
+	switch policy.FailPolicy {
+	case "", vos.PluginFailPolicyFail, vos.PluginFailPolicyWarn:
+		if policy.Retry != 0 {
+			return errors.New("仅失败策略为retry时可以设置重试次数")
+		}
+	case vos.PluginFailPolicyRetry:
+		if policy.Retry <= 0 {
+			return errors.New("失败策略为retry时重试次数必须大于0")
+		}
+	default:
+		return errors.New("未识别的插件失败策略 [" + string(policy.FailPolicy) + "], 可选 fail、warn、retry")
+	}
+	return nil
+}
+
+// effectivePluginRunPolicy 合并插件包中声明的策略与启动配置中的覆盖, 启动配置中设置的项优先
+func effectivePluginRunPolicy(plugin *vos.DbAppPlugin, startInfo *vos.DbAppStartInfo) *vos.AppPluginRunPolicy {
+	policy := &vos.AppPluginRunPolicy{}
+	if plugin.RunPolicy != nil {
+		*policy = *plugin.RunPolicy
+	}
+
+	// 多个前缀匹配时仅使用最长的前缀
+	matchPrefix := ""
+	var override *vos.AppPluginRunPolicy
+	for k, v := range startInfo.PluginRunPolicy {
+		if v == nil || !strings.HasPrefix(plugin.Name, k) {
+			continue
+		}
+
+		if override == nil || len(k) > len(matchPrefix) {
+			matchPrefix = k
+			override = v
+		}
+	}
+
+	if override != nil {
+		if override.Timeout > 0 {
+			policy.Timeout = override.Timeout
+		}
+
+		if override.FailPolicy != "" {
+			policy.FailPolicy = override.FailPolicy
+			policy.Retry = override.Retry
+		}
+	}
+
+	if policy.Timeout <= 0 {
+		policy.Timeout = defaultPluginTimeout
+	}
+
+	if policy.FailPolicy == "" {
+		policy.FailPolicy = vos.PluginFailPolicyFail
+	}
+	return policy
+}
+
+// startSyncPlugin 运行before/after插件, 按失败策略重试或忽略失败, 运行结果记录到插件状态中
+func (a *appRunMgr) startSyncPlugin(plugin *vos.DbAppPlugin, appStatusInfo *AppStatusInfo) error {
+	pluginName := plugin.Name
+	policy := effectivePluginRunPolicy(plugin, appStatusInfo.StartArgs)
+	timeout := time.Duration(policy.Timeout) * time.Second
+
+	attempts := 1
+	if policy.FailPolicy == vos.PluginFailPolicyRetry {
+		attempts += policy.Retry
+	}
+
+	var (
+		err       error
+		startTime = time.Now()
+		output    = appStatusInfo.pluginOutput(pluginName)
+	)
+
+	i := 1
+	for ; i <= attempts; i++ {
+		if err = a.runSyncPlugin(plugin, appStatusInfo, timeout); err == nil || err == errPluginAppClosed {
+			break
+		}
+
+		if i < attempts {
+			output.writeMsg(fmt.Sprintf("插件(%s)第%d次运行失败, 准备重试 => %s", pluginName, i, err.Error()))
+		}
+	}
+
+	if i > attempts {
+		i = attempts
+	}
+
+	result := &vos.AppPluginRunResult{
+		Result:     vos.PluginRunSuccess,
+		Attempts:   i,
+		Duration:   int64(time.Since(startTime) / time.Millisecond),
+		FinishTime: time.Now(),
+	}
+
+	if err == nil || err == errPluginAppClosed {
+		if err == nil {
+			appStatusInfo.setPluginRunResult(pluginName, result)
+		}
+		return err
+	}
+
+	result.Msg = err.Error()
+	if policy.FailPolicy == vos.PluginFailPolicyWarn {
+		result.Result = vos.PluginRunWarned
+		appStatusInfo.setPluginRunResult(pluginName, result)
+		output.writeMsg("插件(" + pluginName + ")运行失败, 按失败策略忽略并继续 => " + err.Error())
+		appStatusInfo.recordEvent(vos.AppEventPluginFailed, "插件("+pluginName+")运行失败, 已忽略 => "+err.Error())
+		return nil
+	}
+
+	result.Result = vos.PluginRunFailed
+	appStatusInfo.setPluginRunResult(pluginName, result)
+	return err
+}
+
+// runSyncPlugin 运行一次插件并等待结束, 超时后强制结束
+func (a *appRunMgr) runSyncPlugin(plugin *vos.DbAppPlugin, appStatusInfo *AppStatusInfo, timeout time.Duration) (returnErr error) {
+	pluginName := plugin.Name
+	defer func() {
+		e := recover()
+		if e != nil {
+			switch err := e.(type) {
+			case error:
+				returnErr = errors.New(err.Error())
+			case string:
+				returnErr = errors.New(err)
+			default:
+				returnErr = errors.New("插件运行失败, 未知异常")
+			}
+		}
+	}()
+
+	isUnlock := false
+	a.Lock()
+	defer func() {
+		if !isUnlock {
+			a.Unlock()
+		}
+	}()
+
+	if appStatusInfo.isClose {
+		return errPluginAppClosed
+	}
+
+	command, err := a.preparePluginCmd(plugin, appStatusInfo, "start")
+	if err != nil {
+		return err
+	}
+	isUnlock = true
+	a.Unlock()
+
+	if err = a.startPluginCmd(command, pluginName, appStatusInfo); err != nil {
+		return errors.New("插件(" + pluginName + ") 运行异常 =>" + err.Error())
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- appStatusInfo.waitPluginCmd(command, pluginName) }()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err = <-done:
+	case <-timer.C:
+		killPluginCmd(command, done)
+		err = fmt.Errorf("运行超时, %d秒内未结束", int64(timeout/time.Second))
+	}
+
+	if err != nil {
+		return errors.New("插件(" + pluginName + ") 运行异常 =>" + err.Error())
+	}
+	return nil
+}
+
+// killPluginCmd 结束插件进程组, 在限定时间内等待插件进程回收
+func killPluginCmd(command *exec.Cmd, done <-chan error) {
+	killProcessGroup(command.Process.Pid)
+	_ = command.Process.Kill()
+
+	timer := time.NewTimer(pluginKillWait)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+	}
+}
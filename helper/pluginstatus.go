@@ -0,0 +1,295 @@
+package helper
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/byzk-org/bypt-server/consts"
+	"github.com/byzk-org/bypt-server/vos"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// pluginStatusMsgMaxSize 单条状态消息的最大长度
+const pluginStatusMsgMaxSize = 64 * 1024
+
+// startPluginCmd 启动插件进程, 非windows系统中通过继承的管道为插件提供状态通道, 描述符通过环境变量传递
+func (a *appRunMgr) startPluginCmd(command *exec.Cmd, pluginName string, appStatusInfo *AppStatusInfo) (returnErr error) {
+	defer func() { appStatusInfo.pluginProcessStarted(pluginName, command, returnErr) }()
+
+	if runtime.GOOS == "windows" {
+		return command.Start()
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return errors.New("创建插件状态通道失败")
+	}
+
+	command.ExtraFiles = append(command.ExtraFiles, w)
+	command.Env = append(command.Env, consts.PluginStatusFdEnv+"="+strconv.Itoa(2+len(command.ExtraFiles)))
+	err = command.Start()
+	_ = w.Close()
+	if err != nil {
+		_ = r.Close()
+		return err
+	}
+
+	go a.readPluginStatus(r, pluginName, appStatusInfo)
+	return nil
+}
+
+// readPluginStatus 读取插件的状态消息, 插件及其子进程全部退出后结束
+func (a *appRunMgr) readPluginStatus(r io.ReadCloser, pluginName string, appStatusInfo *AppStatusInfo) {
+	defer r.Close()
+	output := appStatusInfo.pluginOutput(pluginName)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 4096), pluginStatusMsgMaxSize)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		msg := &vos.PluginStatusMsg{}
+		if err := json.Unmarshal(line, msg); err != nil {
+			output.writeMsg("插件(" + pluginName + ")状态消息格式错误 => " + string(line))
+			continue
+		}
+
+		if err := a.handlePluginStatus(appStatusInfo, pluginName, msg); err != nil {
+			output.writeMsg("插件(" + pluginName + ")状态消息处理失败 => " + err.Error())
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		output.writeMsg("插件(" + pluginName + ")状态通道读取失败 => " + err.Error())
+		// 继续读取并丢弃, 避免插件写入时因管道关闭而退出
+		_, _ = io.Copy(ioutil.Discard, r)
+	}
+}
+
+// handlePluginStatus 更新插件状态, 状态变化时记录事件, 请求重启时重启应用
+func (a *appRunMgr) handlePluginStatus(appStatusInfo *AppStatusInfo, pluginName string, msg *vos.PluginStatusMsg) error {
+	switch msg.Status {
+	case "", vos.PluginStatusHealthy, vos.PluginStatusDegraded, vos.PluginStatusRestart:
+	default:
+		return errors.New("未识别的插件状态 [" + string(msg.Status) + "]")
+	}
+
+	appStatusInfo.pluginStatusLock.Lock()
+	status := appStatusInfo.pluginStatusLocked(pluginName)
+
+	prevStatus, prevMsg := status.Status, status.Msg
+	if prevStatus == "" {
+		prevStatus = vos.PluginStatusHealthy
+	}
+	if msg.Status != "" {
+		status.Status = msg.Status
+		status.Msg = msg.Msg
+	} else if status.Status == "" {
+		status.Status = vos.PluginStatusHealthy
+	}
+
+	for k, v := range msg.Metrics {
+		if status.Metrics == nil {
+			status.Metrics = make(map[string]float64)
+		}
+		status.Metrics[k] = v
+	}
+	status.UpdateTime = time.Now()
+
+	isRestart := false
+	if msg.Status == vos.PluginStatusRestart && !appStatusInfo.pluginRestarting {
+		appStatusInfo.pluginRestarting = true
+		isRestart = true
+	}
+	appStatusInfo.pluginStatusLock.Unlock()
+
+	eventMsg := "插件(" + pluginName + ")"
+	if msg.Msg != "" {
+		eventMsg += " => " + msg.Msg
+	}
+
+	switch msg.Status {
+	case vos.PluginStatusDegraded:
+		if prevStatus != vos.PluginStatusDegraded || prevMsg != msg.Msg {
+			appStatusInfo.recordEvent(vos.AppEventPluginDegraded, eventMsg)
+		}
+	case vos.PluginStatusHealthy:
+		if prevStatus != vos.PluginStatusHealthy {
+			appStatusInfo.recordEvent(vos.AppEventPluginHealthy, eventMsg)
+		}
+	case vos.PluginStatusRestart:
+		if isRestart {
+			a.restartByPlugin(appStatusInfo, pluginName, eventMsg)
+		}
+	}
+	return nil
+}
+
+// restartByPlugin 插件请求重启应用, 仅重启仍在运行的当前实例, 每个实例只重启一次
+func (a *appRunMgr) restartByPlugin(appStatusInfo *AppStatusInfo, pluginName, eventMsg string) {
+	if appStatusInfo.startMode != appStartModeNormal {
+		return
+	}
+
+	a.Lock()
+	isCurrent := a.startAppMap[appStatusInfo.Name] == appStatusInfo
+	a.Unlock()
+
+	appStatusInfo.closeLock.Lock()
+	isRunning := !appStatusInfo.isClose && !appStatusInfo.isStopping
+	appStatusInfo.closeLock.Unlock()
+	if !isCurrent || !isRunning {
+		return
+	}
+
+	appStatusInfo.recordEvent(vos.AppEventPluginRestart, eventMsg)
+	go func() {
+		if err := a.RestartApp(appStatusInfo.Name); err != nil {
+			logrus.Error("插件(" + pluginName + ")请求重启应用 [" + appStatusInfo.Name + "] 失败 => " + err.Error())
+		}
+	}()
+}
+
+// pluginStatusInfo 插件最近上报的状态, 未上报过时返回nil
+func (a *AppStatusInfo) pluginStatusInfo(pluginName string) *vos.AppPluginStatus {
+	a.pluginStatusLock.Lock()
+	defer a.pluginStatusLock.Unlock()
+	status, ok := a.pluginStatus[pluginName]
+	if !ok {
+		return nil
+	}
+
+	statusCopy := *status
+	if status.LastRun != nil {
+		lastRun := *status.LastRun
+		statusCopy.LastRun = &lastRun
+	}
+	if status.Process != nil {
+		process := *status.Process
+		statusCopy.Process = &process
+	}
+	if status.Metrics != nil {
+		statusCopy.Metrics = make(map[string]float64, len(status.Metrics))
+		for k, v := range status.Metrics {
+			statusCopy.Metrics[k] = v
+		}
+	}
+	return &statusCopy
+}
+
+func (a *AppStatusInfo) convertPluginsStatus() {
+	a.pluginStatusLock.Lock()
+	names := make([]string, 0, len(a.pluginStatus))
+	for k := range a.pluginStatus {
+		names = append(names, k)
+	}
+	a.pluginStatusLock.Unlock()
+
+	if len(names) == 0 {
+		return
+	}
+
+	a.PluginStatus = make(map[string]*vos.AppPluginStatus, len(names))
+	for _, k := range names {
+		a.PluginStatus[k] = a.pluginStatusInfo(k)
+	}
+}
+
+// setPluginRunResult 记录before/after插件最近一次的运行结果
+func (a *AppStatusInfo) setPluginRunResult(pluginName string, result *vos.AppPluginRunResult) {
+	a.pluginStatusLock.Lock()
+	defer a.pluginStatusLock.Unlock()
+	status := a.pluginStatusLocked(pluginName)
+	status.LastRun = result
+	status.UpdateTime = result.FinishTime
+}
+
+// waitPluginCmd 等待插件进程结束并记录退出状态
+func (a *AppStatusInfo) waitPluginCmd(command *exec.Cmd, pluginName string) error {
+	err := command.Wait()
+	a.pluginProcessExited(pluginName, command, err)
+	return err
+}
+
+// setPluginsPending 将本次运行的插件设置为等待运行
+func (a *AppStatusInfo) setPluginsPending(plugins []*vos.DbAppPlugin) {
+	a.pluginStatusLock.Lock()
+	defer a.pluginStatusLock.Unlock()
+	for _, plugin := range plugins {
+		status := a.pluginStatusLocked(plugin.Name)
+		if status.Process == nil {
+			status.Process = &vos.AppPluginProcess{
+				State: vos.PluginProcessPending,
+			}
+		}
+	}
+}
+
+// pluginProcessStarted 记录插件进程的启动结果
+func (a *AppStatusInfo) pluginProcessStarted(pluginName string, command *exec.Cmd, err error) {
+	a.pluginStatusLock.Lock()
+	defer a.pluginStatusLock.Unlock()
+	status := a.pluginStatusLocked(pluginName)
+	process := &vos.AppPluginProcess{
+		State:     vos.PluginProcessRunning,
+		StartTime: time.Now(),
+	}
+
+	if prev := status.Process; prev != nil && prev.State != vos.PluginProcessPending {
+		process.RestartCount = prev.RestartCount + 1
+	}
+
+	if err != nil {
+		process.State = vos.PluginProcessFailed
+		process.EndTime = process.StartTime
+		process.ErrMsg = err.Error()
+	} else {
+		process.Pid = command.Process.Pid
+	}
+	status.Process = process
+}
+
+// pluginProcessExited 记录插件进程的退出状态
+func (a *AppStatusInfo) pluginProcessExited(pluginName string, command *exec.Cmd, err error) {
+	a.pluginStatusLock.Lock()
+	defer a.pluginStatusLock.Unlock()
+	process := a.pluginStatusLocked(pluginName).Process
+	if process == nil || command.Process == nil || process.Pid != command.Process.Pid {
+		return
+	}
+
+	process.EndTime = time.Now()
+	process.State = vos.PluginProcessExited
+	if state := command.ProcessState; state != nil {
+		process.ExitCode = state.ExitCode()
+		if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			process.Signal = status.Signal().String()
+		}
+	}
+
+	if err != nil {
+		process.State = vos.PluginProcessFailed
+		process.ErrMsg = err.Error()
+	}
+}
+
+// pluginStatusLocked 获取插件状态, 不存在时创建, 调用方需持有pluginStatusLock
+func (a *AppStatusInfo) pluginStatusLocked(pluginName string) *vos.AppPluginStatus {
+	status, ok := a.pluginStatus[pluginName]
+	if !ok {
+		status = &vos.AppPluginStatus{}
+		a.pluginStatus[pluginName] = status
+	}
+	return status
+}
@@ -0,0 +1,174 @@
+package helper
+
+import (
+	"errors"
+	"github.com/byzk-org/bypt-server/consts"
+	"github.com/byzk-org/bypt-server/db"
+	"github.com/byzk-org/bypt-server/vos"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ParsePortRange 解析端口范围, 格式: 起始端口-结束端口
+func ParsePortRange(val string) (int, int, error) {
+	split := strings.Split(val, "-")
+	if len(split) != 2 {
+		return 0, 0, errors.New("端口范围格式错误, 格式: 起始端口-结束端口")
+	}
+
+	start, err := strconv.Atoi(strings.TrimSpace(split[0]))
+	if err != nil {
+		return 0, 0, errors.New("起始端口格式错误")
+	}
+
+	end, err := strconv.Atoi(strings.TrimSpace(split[1]))
+	if err != nil {
+		return 0, 0, errors.New("结束端口格式错误")
+	}
+
+	if start <= 0 || end > 65535 || start > end {
+		return 0, 0, errors.New("端口范围必须在1-65535之间且起始端口不大于结束端口")
+	}
+	return start, end, nil
+}
+
+// allocatePorts 检查固定端口并自动分配端口, 调用方需持有管理器锁
+func (a *appRunMgr) allocatePorts(appStartInfo *vos.DbAppStartInfo) ([]*vos.AppPortConfig, error) {
+	if len(appStartInfo.Ports) == 0 {
+		return nil, nil
+	}
+
+	usedPorts, selfPorts := a.managedPorts(appStartInfo.Name)
+	result := make([]*vos.AppPortConfig, 0, len(appStartInfo.Ports))
+	names := make(map[string]bool, len(appStartInfo.Ports))
+	autoPorts := make([]*vos.AppPortConfig, 0, len(appStartInfo.Ports))
+	for _, p := range appStartInfo.Ports {
+		if p.Name == "" {
+			return nil, errors.New("端口名称不能为空")
+		}
+
+		if names[p.Name] {
+			return nil, errors.New("端口名称 [" + p.Name + "] 重复")
+		}
+		names[p.Name] = true
+
+		port := &vos.AppPortConfig{
+			Name: p.Name,
+			Port: p.Port,
+			Env:  p.Env,
+		}
+		if port.Env == "" {
+			port.Env = portEnvName(p.Name)
+		}
+		result = append(result, port)
+
+		if p.Port == 0 {
+			autoPorts = append(autoPorts, port)
+			continue
+		}
+
+		if p.Port < 0 || p.Port > 65535 {
+			return nil, errors.New("端口 [" + p.Name + "] 超出范围")
+		}
+
+		portStr := strconv.Itoa(p.Port)
+		if appName, ok := usedPorts[p.Port]; ok {
+			return nil, errors.New("端口 " + portStr + " 已被应用 [" + appName + "] 使用")
+		}
+
+		// 升级时旧版本仍占用同一端口
+		if !selfPorts[p.Port] && !portAvailable(p.Port) {
+			return nil, errors.New("端口 " + portStr + " 已被其他程序占用")
+		}
+		usedPorts[p.Port] = appStartInfo.Name
+	}
+
+	if len(autoPorts) == 0 {
+		return result, nil
+	}
+
+	start, end, err := portRangeSetting()
+	if err != nil {
+		return nil, err
+	}
+
+	next := start
+	for _, port := range autoPorts {
+		for ; next <= end; next++ {
+			if _, ok := usedPorts[next]; ok || selfPorts[next] || !portAvailable(next) {
+				continue
+			}
+			port.Port = next
+			usedPorts[next] = appStartInfo.Name
+			break
+		}
+
+		if port.Port == 0 {
+			return nil, errors.New("端口范围内已无可用端口")
+		}
+	}
+	return result, nil
+}
+
+// managedPorts 获取其他应用已分配的端口及同名应用(升级中的旧版本)占用的端口
+func (a *appRunMgr) managedPorts(appName string) (map[int]string, map[int]bool) {
+	usedPorts := make(map[int]string)
+	selfPorts := make(map[int]bool)
+	for _, statusMap := range []map[string]*AppStatusInfo{a.startAppMap, a.upgradeAppMap} {
+		for name, statusInfo := range statusMap {
+			for _, p := range statusInfo.Ports {
+				if name == appName {
+					selfPorts[p.Port] = true
+				} else {
+					usedPorts[p.Port] = name
+				}
+			}
+		}
+	}
+	return usedPorts, selfPorts
+}
+
+// portRangeSetting 获取自动分配的端口范围
+func portRangeSetting() (int, int, error) {
+	setting := &vos.DbSetting{}
+	if err := db.GetDb().Model(&vos.DbSetting{}).Where(&vos.DbSetting{
+		Name: consts.DbSettingPortRange,
+	}).First(&setting).Error; err != nil {
+		return 0, 0, errors.New("获取端口范围配置失败")
+	}
+	return ParsePortRange(setting.Val)
+}
+
+// portAvailable 检查端口是否可以监听
+func portAvailable(port int) bool {
+	listener, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		return false
+	}
+	_ = listener.Close()
+	return true
+}
+
+// portEnvName 端口默认的环境变量名称
+func portEnvName(name string) string {
+	return "PORT_" + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+// portEnv 端口的环境变量
+func (a *AppStatusInfo) portEnv() []string {
+	env := make([]string, 0, len(a.Ports))
+	for _, p := range a.Ports {
+		env = append(env, p.Env+"="+strconv.Itoa(p.Port))
+	}
+	return env
+}
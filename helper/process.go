@@ -0,0 +1,561 @@
+package helper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/byzk-org/bypt-server/consts"
+	"github.com/byzk-org/bypt-server/db"
+	"github.com/byzk-org/bypt-server/vos"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// logRelaySegmentSize 输出中转文件的分段大小
+	logRelaySegmentSize = 4 * 1024 * 1024
+	// logRelayDrainTimeout 进程退出后等待输出全部写入日志的最长时间
+	logRelayDrainTimeout = 5 * time.Second
+	// logRelayPositionFile 记录日志写入位置的文件, 接管时从该位置继续读取
+	logRelayPositionFile = "position"
+	// appProcessSaveRetry 数据库被事务锁定时的重试次数
+	appProcessSaveRetry = 20
+)
+
+// appProcessChan 进程记录由单独的协程顺序写入, 保证同一次运行的保存先于删除
+var appProcessChan = make(chan func() error, 256)
+
+func init() {
+	go saveAppProcesses()
+}
+
+// saveAppProcesses 保存或删除进程记录
+func saveAppProcesses() {
+	for fn := range appProcessChan {
+		var err error
+		for i := 0; i < appProcessSaveRetry; i++ {
+			if err = fn(); err == nil {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		if err != nil {
+			logrus.Error("保存应用进程信息失败 => " + err.Error())
+		}
+	}
+}
+
+func saveAppProcess(record *vos.DbAppProcess) {
+	appProcessChan <- func() error {
+		return db.GetDb().Save(record).Error
+	}
+}
+
+func removeAppProcess(runId string) {
+	appProcessChan <- func() error {
+		return db.GetDb().Where(&vos.DbAppProcess{
+			RunId: runId,
+		}).Delete(&vos.DbAppProcess{}).Error
+	}
+}
+
+// newRunId 生成应用单次运行的标识
+func newRunId(appName string) string {
+	return fmt.Sprintf("%s-%d", appName, time.Now().UnixNano())
+}
+
+// runIdEnv 应用单次运行标识的环境变量, 服务重启后据此找到遗留的进程
+func (a *AppStatusInfo) runIdEnv() string {
+	return consts.RunIdEnv + "=" + a.runId
+}
+
+// processRecord 生成运行中应用的进程记录
+func (a *AppStatusInfo) processRecord(relay *appOutputRelay) *vos.DbAppProcess {
+	pid := a.runCmd.Process.Pid
+	startTime, pgid, _ := processStat(pid)
+	record := &vos.DbAppProcess{
+		RunId:          a.runId,
+		AppName:        a.Name,
+		AppVersion:     a.VersionStr,
+		Pid:            pid,
+		StartTime:      startTime,
+		Pgid:           pgid,
+		RelayPid:       relay.pid,
+		RelayStartTime: relay.startTime,
+		SpoolDir:       relay.spoolDir,
+		RunDir:         a.runDir,
+		JavaCmd:        a.JavaCmd,
+		CreateTime:     time.Now(),
+	}
+
+	if len(a.Ports) > 0 {
+		record.PortsBytes, _ = json.Marshal(a.Ports)
+	}
+	return record
+}
+
+// IsLogRelay 当前进程是否为输出中转进程
+func IsLogRelay() bool {
+	return os.Getenv(consts.LogRelayEnv) != ""
+}
+
+// RunLogRelay 将标准输入中的应用输出分段写入中转目录, 输入结束后退出
+func RunLogRelay() {
+	signal.Ignore(syscall.SIGHUP, syscall.SIGINT, syscall.SIGPIPE)
+	if err := runLogRelay(os.Stdin, os.Getenv(consts.LogRelayEnv)); err != nil {
+		fmt.Println("输出中转失败 => " + err.Error())
+		os.Exit(1)
+	}
+}
+
+func runLogRelay(r io.Reader, spoolDir string) error {
+	if err := os.MkdirAll(spoolDir, 0700); err != nil {
+		return err
+	}
+
+	var (
+		seq  int
+		size int
+		file *os.File
+	)
+	defer func() {
+		if file != nil {
+			_ = file.Close()
+		}
+	}()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if file == nil || size >= logRelaySegmentSize {
+				if file != nil {
+					_ = file.Close()
+					seq++
+				}
+
+				var openErr error
+				if file, openErr = os.OpenFile(logRelaySegmentPath(spoolDir, seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600); openErr != nil {
+					file = nil
+					return openErr
+				}
+				size = 0
+			}
+
+			if _, writeErr := file.Write(buf[:n]); writeErr != nil {
+				return writeErr
+			}
+			size += n
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func logRelaySegmentPath(spoolDir string, seq int) string {
+	return filepath.Join(spoolDir, fmt.Sprintf("%012d.log", seq))
+}
+
+// logRelaySegments 中转目录中的分段序号, 从小到大排列
+func logRelaySegments(spoolDir string) []int {
+	fileInfos, err := ioutil.ReadDir(spoolDir)
+	if err != nil {
+		return nil
+	}
+
+	segments := make([]int, 0, len(fileInfos))
+	for _, f := range fileInfos {
+		if !strings.HasSuffix(f.Name(), ".log") {
+			continue
+		}
+
+		if seq, err := strconv.Atoi(strings.TrimSuffix(f.Name(), ".log")); err == nil {
+			segments = append(segments, seq)
+		}
+	}
+	sort.Ints(segments)
+	return segments
+}
+
+// appOutputRelay 输出中转, 应用的输出经中转进程写入中转目录, 服务重启时应用不会因输出管道断开而退出
+type appOutputRelay struct {
+	spoolDir  string
+	pid       int
+	startTime uint64
+	// writer 交给应用作为标准输出及错误输出的管道写入端
+	writer *os.File
+	// done 中转进程已退出
+	done chan struct{}
+	// drained 中转目录中的输出已全部写入日志
+	drained chan struct{}
+}
+
+// startOutputRelay 启动输出中转进程, 当前系统不支持时返回nil
+func startOutputRelay(appStatusInfo *AppStatusInfo) (*appOutputRelay, error) {
+	if !logRelaySupported {
+		return nil, nil
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return nil, errors.New("获取服务程序路径失败")
+	}
+
+	spoolDir := filepath.Join(consts.LogSpoolDir, appStatusInfo.runId)
+	if err = os.MkdirAll(spoolDir, 0700); err != nil {
+		return nil, errors.New("创建输出中转目录失败")
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, errors.New("创建输出管道失败")
+	}
+	defer r.Close()
+
+	cmd := exec.Command(self)
+	cmd.Env = append(os.Environ(), consts.LogRelayEnv+"="+spoolDir, appStatusInfo.runIdEnv())
+	cmd.Stdin = r
+	cmd.SysProcAttr = logRelaySysProcAttr()
+	if err = cmd.Start(); err != nil {
+		_ = w.Close()
+		return nil, errors.New("启动输出中转进程失败 => " + err.Error())
+	}
+
+	startTime, _, _ := processStat(cmd.Process.Pid)
+	relay := &appOutputRelay{
+		spoolDir:  spoolDir,
+		pid:       cmd.Process.Pid,
+		startTime: startTime,
+		writer:    w,
+		done:      make(chan struct{}),
+		drained:   make(chan struct{}),
+	}
+
+	go func() {
+		_ = cmd.Wait()
+		close(relay.done)
+	}()
+	return relay, nil
+}
+
+// adoptOutputRelay 接管上次运行时启动的输出中转进程
+func adoptOutputRelay(record *vos.DbAppProcess) *appOutputRelay {
+	relay := &appOutputRelay{
+		spoolDir:  record.SpoolDir,
+		pid:       record.RelayPid,
+		startTime: record.RelayStartTime,
+		done:      make(chan struct{}),
+		drained:   make(chan struct{}),
+	}
+
+	go func() {
+		waitProcessExit(relay.pid, relay.startTime)
+		close(relay.done)
+	}()
+	return relay
+}
+
+// closeWriter 关闭服务持有的管道写入端, 应用退出后中转进程才能读到结束
+func (r *appOutputRelay) closeWriter() {
+	if r.writer != nil {
+		_ = r.writer.Close()
+		r.writer = nil
+	}
+}
+
+func (r *appOutputRelay) isDone() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// waitDrained 等待输出全部写入日志
+func (r *appOutputRelay) waitDrained(timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-r.drained:
+	case <-timer.C:
+	}
+}
+
+// tail 将中转目录中的输出写入应用日志, 已读完的分段在有新分段后删除, 中转进程退出且输出全部写入后结束
+func (r *appOutputRelay) tail(w io.Writer) {
+	defer close(r.drained)
+	defer os.RemoveAll(r.spoolDir)
+
+	seq, offset := r.readPosition()
+	var file *os.File
+	defer func() {
+		if file != nil {
+			_ = file.Close()
+		}
+	}()
+
+	buf := make([]byte, 32*1024)
+	for {
+		relayDone := r.isDone()
+		segments := logRelaySegments(r.spoolDir)
+		if file == nil {
+			next := -1
+			for _, s := range segments {
+				if s >= seq {
+					next = s
+					break
+				}
+			}
+
+			if next < 0 {
+				if relayDone {
+					return
+				}
+				time.Sleep(200 * time.Millisecond)
+				continue
+			}
+
+			f, err := os.Open(logRelaySegmentPath(r.spoolDir, next))
+			if err != nil {
+				if relayDone {
+					return
+				}
+				time.Sleep(200 * time.Millisecond)
+				continue
+			}
+
+			if next != seq {
+				offset = 0
+			}
+
+			if offset > 0 {
+				if _, err = f.Seek(offset, io.SeekStart); err != nil {
+					offset = 0
+				}
+			}
+			file, seq = f, next
+		}
+
+		n, err := file.Read(buf)
+		if n > 0 {
+			_, _ = w.Write(buf[:n])
+			offset += int64(n)
+			r.savePosition(seq, offset)
+			continue
+		}
+
+		if err != nil && err != io.EOF {
+			return
+		}
+
+		if len(segments) > 0 && segments[len(segments)-1] > seq {
+			_ = file.Close()
+			file = nil
+			_ = os.Remove(logRelaySegmentPath(r.spoolDir, seq))
+			seq, offset = seq+1, 0
+			continue
+		}
+
+		if relayDone {
+			return
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+func (r *appOutputRelay) readPosition() (int, int64) {
+	content, err := ioutil.ReadFile(filepath.Join(r.spoolDir, logRelayPositionFile))
+	if err != nil {
+		return 0, 0
+	}
+
+	var (
+		seq    int
+		offset int64
+	)
+	if _, err = fmt.Sscanf(string(content), "%d %d", &seq, &offset); err != nil {
+		return 0, 0
+	}
+	return seq, offset
+}
+
+func (r *appOutputRelay) savePosition(seq int, offset int64) {
+	_ = ioutil.WriteFile(filepath.Join(r.spoolDir, logRelayPositionFile), []byte(fmt.Sprintf("%d %d", seq, offset)), 0600)
+}
+
+// adoptRunningApps 服务启动时接管上次运行中且仍存活的应用进程, 无法接管的遗留进程全部结束, 返回已接管的应用名称
+func (a *appRunMgr) adoptRunningApps(startInfos []*vos.DbAppStartInfo) map[string]bool {
+	adopted := make(map[string]bool)
+	records := make([]*vos.DbAppProcess, 0)
+	if err := db.GetDb().Model(&vos.DbAppProcess{}).Find(&records).Error; err != nil {
+		logrus.Error("查询应用进程信息失败 => " + err.Error())
+	}
+
+	candidates := make(map[string]*vos.DbAppProcess)
+	discard := make([]*vos.DbAppProcess, 0)
+	for _, record := range records {
+		startInfo := findAdoptStartInfo(startInfos, record)
+		if startInfo == nil || candidates[startInfo.Name] != nil || !processAlive(record.Pid, record.StartTime) {
+			discard = append(discard, record)
+			continue
+		}
+		candidates[startInfo.Name] = record
+	}
+
+	// 插件等其他进程会在接管后重新启动, 仅保留应用进程组及输出中转进程
+	for runId, pids := range listRunProcesses() {
+		var record *vos.DbAppProcess
+		for _, c := range candidates {
+			if c.RunId == runId {
+				record = c
+			}
+		}
+
+		for _, pid := range pids {
+			if record != nil {
+				if _, pgid, err := processStat(pid); pid == record.RelayPid || (err == nil && pgid == record.Pgid) {
+					continue
+				}
+			}
+			killProcess(pid)
+		}
+	}
+
+	for name, record := range candidates {
+		if err := a.adoptApp(findAdoptStartInfo(startInfos, record), record); err != nil {
+			logrus.Error("接管应用[" + name + "]失败 => " + err.Error())
+			discard = append(discard, record)
+			continue
+		}
+		adopted[name] = true
+	}
+
+	for _, record := range discard {
+		if record.Pgid > 0 && processAlive(record.Pid, record.StartTime) {
+			killProcessGroup(record.Pgid)
+		}
+
+		if record.RelayPid > 0 && processAlive(record.RelayPid, record.RelayStartTime) {
+			killProcess(record.RelayPid)
+		}
+		removeAppProcess(record.RunId)
+		if record.SpoolDir != "" {
+			_ = os.RemoveAll(record.SpoolDir)
+		}
+	}
+	return adopted
+}
+
+// findAdoptStartInfo 查找进程记录对应的启动信息, 定时任务不接管
+func findAdoptStartInfo(startInfos []*vos.DbAppStartInfo, record *vos.DbAppProcess) *vos.DbAppStartInfo {
+	for _, s := range startInfos {
+		if s.Name == record.AppName && s.Version == record.AppVersion && len(s.JobBytes) == 0 {
+			return s
+		}
+	}
+	return nil
+}
+
+// adoptApp 使用上次的启动信息接管运行中的进程
+func (a *appRunMgr) adoptApp(startInfo *vos.DbAppStartInfo, record *vos.DbAppProcess) error {
+	parseStartInfoBytes(startInfo)
+	startInfo.AdoptProcess = record
+	defer func() { startInfo.AdoptProcess = nil }()
+
+	statusInfo, err := a.startApp(startInfo, appStartModeNormal)
+	if err != nil && statusInfo != nil {
+		a.Lock()
+		if a.startAppMap[startInfo.Name] == statusInfo {
+			delete(a.startAppMap, startInfo.Name)
+		}
+		a.Unlock()
+	}
+	return err
+}
+
+// adoptAppExec 接管运行中的应用进程, 重新关联日志并监控进程退出
+func (a *appRunMgr) adoptAppExec(appStatusInfo *AppStatusInfo, record *vos.DbAppProcess) error {
+	if record.RunDir != appStatusInfo.runDir {
+		return errors.New("运行目录已变更")
+	}
+
+	process, err := os.FindProcess(record.Pid)
+	if err != nil {
+		return errors.New("获取应用进程失败")
+	}
+
+	logsWriter, err := newAppLogs(appStatusInfo.StartArgs.Name, appStatusInfo.StartArgs.Version, appStatusInfo.StartArgs.LogDir)
+	if err != nil {
+		return err
+	}
+	logsWriter.lineHandler = appStatusInfo.handleLogLine
+	logsWriter.outputHandler = appStatusInfo.handleAttachOutput
+	appStatusInfo.logCloser = logsWriter
+
+	if record.JavaCmd != "" {
+		appStatusInfo.JavaCmd = record.JavaCmd
+		packJdkCache.retain(appStatusInfo, record.JavaCmd)
+	}
+	appStatusInfo.runCmd = &exec.Cmd{
+		Path:    appStatusInfo.JavaCmd,
+		Dir:     record.RunDir,
+		Process: process,
+	}
+	appStatusInfo.Adopted = true
+
+	relay := adoptOutputRelay(record)
+	go relay.tail(logsWriter)
+	go a.runAdoptedApp(appStatusInfo, record, relay)
+	return nil
+}
+
+// runAdoptedApp 重新启动监听及常驻插件, 等待接管的进程退出
+func (a *appRunMgr) runAdoptedApp(appStatusInfo *AppStatusInfo, record *vos.DbAppProcess, relay *appOutputRelay) {
+	go func() {
+		msg := <-appStatusInfo.exitChannel
+		a.settingErrStatus(msg, appStatusInfo, appRunErrTypeApp)
+	}()
+
+	plugins := make([]*vos.DbAppPlugin, 0, len(appStatusInfo.VersionInfo.PluginInfo))
+	for _, plugin := range appStatusInfo.VersionInfo.PluginInfo {
+		if plugin.Type == vos.AppPluginTypeNormal || plugin.Type == vos.AppPluginTypeListener {
+			plugins = append(plugins, plugin)
+		}
+	}
+
+	appStatusInfo.setPluginsPending(plugins)
+	appStatusInfo.pluginOkChan = make(chan bool, len(plugins))
+	for _, plugin := range plugins {
+		go a.startPlugin(plugin, appStatusInfo)
+	}
+
+	appStatusInfo.Status = appRunStatusRunner
+	appStatusInfo.recordEvent(vos.AppEventAdopted, "进程号 "+strconv.Itoa(record.Pid))
+
+	waitProcessExit(record.Pid, record.StartTime)
+	_ = appStatusInfo.runCmd.Process.Release()
+	packJdkCache.release(appStatusInfo)
+	relay.waitDrained(logRelayDrainTimeout)
+	removeAppProcess(record.RunId)
+
+	appStatusInfo.ExitCode = -1
+	a.settingErrStatus("运行异常 => 接管的进程已退出", appStatusInfo, appRunErrTypeApp)
+}
@@ -0,0 +1,187 @@
+//go:build linux
+// +build linux
+
+package helper
+
+import (
+	"bytes"
+	"errors"
+	"github.com/byzk-org/bypt-server/consts"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// sysPidfdOpen pidfd_open系统调用号, 需要5.3及以上内核
+const sysPidfdOpen = 434
+
+const logRelaySupported = true
+
+// logRelaySysProcAttr 输出中转进程使用单独的进程组, 不随服务所在的进程组收到信号
+func logRelaySysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{
+		Setpgid: true,
+	}
+}
+
+// setProcessGroup 应用进程使用单独的进程组, 结束时可以连同其子进程一起结束
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// processStat 读取/proc/<pid>/stat中的启动时间及进程组, 第三个返回值为进程状态
+func processStat(pid int) (uint64, int, error) {
+	content, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// 进程名称中可能包含空格及括号, 从最后一个括号之后开始解析
+	i := bytes.LastIndexByte(content, ')')
+	if i < 0 {
+		return 0, 0, errors.New("解析进程信息失败")
+	}
+
+	fields := strings.Fields(string(content[i+1:]))
+	if len(fields) < 20 || fields[0] == "Z" || fields[0] == "X" {
+		return 0, 0, errors.New("进程已结束")
+	}
+
+	pgid, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	startTime, err := strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return startTime, pgid, nil
+}
+
+// processAlive 进程是否仍在运行, 启动时间不一致说明进程号已被复用
+func processAlive(pid int, startTime uint64) bool {
+	if pid <= 0 {
+		return false
+	}
+
+	t, _, err := processStat(pid)
+	return err == nil && t == startTime
+}
+
+// waitProcessExit 等待非子进程退出, 优先使用pidfd, 内核不支持时轮询
+func waitProcessExit(pid int, startTime uint64) {
+	fd, _, errno := syscall.Syscall(sysPidfdOpen, uintptr(pid), 0, 0)
+	if errno == 0 {
+		defer syscall.Close(int(fd))
+		// 打开pidfd后再次校验, 避免打开的是复用了进程号的其他进程
+		if !processAlive(pid, startTime) {
+			return
+		}
+
+		if err := waitPidfd(int(fd)); err == nil {
+			return
+		}
+	}
+
+	for processAlive(pid, startTime) {
+		time.Sleep(time.Second)
+	}
+}
+
+// waitPidfd 进程退出时pidfd变为可读
+func waitPidfd(fd int) error {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(epfd)
+
+	if err = syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, fd, &syscall.EpollEvent{
+		Events: syscall.EPOLLIN,
+		Fd:     int32(fd),
+	}); err != nil {
+		return err
+	}
+
+	events := make([]syscall.EpollEvent, 1)
+	for {
+		n, err := syscall.EpollWait(epfd, events, -1)
+		if err == syscall.EINTR {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if n > 0 {
+			return nil
+		}
+	}
+}
+
+// listRunProcesses 根据环境变量中的运行标识查找所有由服务启动的进程
+func listRunProcesses() map[string][]int {
+	result := make(map[string][]int)
+	fileInfos, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return result
+	}
+
+	self := os.Getpid()
+	prefix := []byte(consts.RunIdEnv + "=")
+	for _, f := range fileInfos {
+		pid, err := strconv.Atoi(f.Name())
+		if err != nil || pid == self {
+			continue
+		}
+
+		environ, err := ioutil.ReadFile(filepath.Join("/proc", f.Name(), "environ"))
+		if err != nil {
+			continue
+		}
+
+		for _, e := range bytes.Split(environ, []byte{0}) {
+			if bytes.HasPrefix(e, prefix) {
+				runId := string(e[len(prefix):])
+				result[runId] = append(result[runId], pid)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// checkOwnedDir 校验路径为服务用户所有的目录且不是符号链接
+func checkOwnedDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return errors.New("不是目录")
+	}
+
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Geteuid() {
+		return errors.New("目录不属于服务用户")
+	}
+	return nil
+}
+
+func killProcess(pid int) {
+	_ = syscall.Kill(pid, syscall.SIGKILL)
+}
+
+func killProcessGroup(pgid int) {
+	_ = syscall.Kill(-pgid, syscall.SIGKILL)
+}
@@ -0,0 +1,58 @@
+//go:build !linux
+// +build !linux
+
+package helper
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// logRelaySupported 输出中转及进程接管仅支持linux, 其他系统中服务重启时应用随之退出
+const logRelaySupported = false
+
+func logRelaySysProcAttr() *syscall.SysProcAttr {
+	return nil
+}
+
+func setProcessGroup(cmd *exec.Cmd) {
+}
+
+func processStat(pid int) (uint64, int, error) {
+	return 0, 0, errors.New("当前系统不支持读取进程信息")
+}
+
+func processAlive(pid int, startTime uint64) bool {
+	return false
+}
+
+func waitProcessExit(pid int, startTime uint64) {
+	for processAlive(pid, startTime) {
+		time.Sleep(time.Second)
+	}
+}
+
+func listRunProcesses() map[string][]int {
+	return nil
+}
+
+func checkOwnedDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return errors.New("不是目录")
+	}
+	return nil
+}
+
+func killProcess(pid int) {
+}
+
+func killProcessGroup(pgid int) {
+}
@@ -0,0 +1,134 @@
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"github.com/byzk-org/bypt-server/vos"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// defaultStartTimeout 默认等待应用就绪的时间, 单位秒
+const defaultStartTimeout int64 = 300
+
+// appReadiness 编译后的就绪检测配置
+type appReadiness struct {
+	readyPattern *regexp.Regexp
+	failPattern  *regexp.Regexp
+	timeout      time.Duration
+}
+
+// readinessWatch 就绪检测的日志匹配监听, 需要在程序启动前注册以免错过日志
+type readinessWatch struct {
+	statusInfo   *AppStatusInfo
+	readyWatcher *logWatcher
+	failWatcher  *logWatcher
+}
+
+// checkReadinessConfig 校验并编译就绪检测配置
+func checkReadinessConfig(config *vos.AppReadinessConfig) (*appReadiness, error) {
+	if config == nil {
+		return nil, nil
+	}
+
+	if config.ReadyLogPattern == "" {
+		return nil, errors.New("就绪日志正则表达式不能为空")
+	}
+
+	readiness := &appReadiness{}
+	readyPattern, err := regexp.Compile(config.ReadyLogPattern)
+	if err != nil {
+		return nil, errors.New("就绪日志正则表达式格式不正确")
+	}
+	readiness.readyPattern = readyPattern
+
+	if config.FailLogPattern != "" {
+		if readiness.failPattern, err = regexp.Compile(config.FailLogPattern); err != nil {
+			return nil, errors.New("启动失败日志正则表达式格式不正确")
+		}
+	}
+
+	timeout := config.StartTimeout
+	if timeout <= 0 {
+		timeout = defaultStartTimeout
+	}
+	readiness.timeout = time.Duration(timeout) * time.Second
+	return readiness, nil
+}
+
+// newReadinessWatch 注册就绪检测的日志匹配监听, 未配置就绪检测时返回nil
+func (a *AppStatusInfo) newReadinessWatch() *readinessWatch {
+	if a.readiness == nil {
+		return nil
+	}
+
+	watch := &readinessWatch{
+		statusInfo:   a,
+		readyWatcher: a.addLogWatcher(a.readiness.readyPattern),
+	}
+	if a.readiness.failPattern != nil {
+		watch.failWatcher = a.addLogWatcher(a.readiness.failPattern)
+	}
+	return watch
+}
+
+func (w *readinessWatch) close() {
+	w.statusInfo.removeLogWatcher(w.readyWatcher)
+	if w.failWatcher != nil {
+		w.statusInfo.removeLogWatcher(w.failWatcher)
+	}
+}
+
+// waitReadiness 等待应用输出就绪日志, 就绪前匹配到失败日志或超时时视为启动失败
+func (a *appRunMgr) waitReadiness(appStatusInfo *AppStatusInfo, watch *readinessWatch) {
+	defer watch.close()
+
+	var failChan chan []byte
+	if watch.failWatcher != nil {
+		failChan = watch.failWatcher.matchChan
+	}
+
+	timeout := appStatusInfo.readiness.timeout
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-appStatusInfo.exitChannel:
+	case <-watch.readyWatcher.matchChan:
+		appStatusInfo.closeLock.Lock()
+		if !appStatusInfo.isClose {
+			appStatusInfo.Status = appRunStatusRunner
+			appStatusInfo.recordEvent(vos.AppEventReady, "")
+			close(appStatusInfo.readyChan)
+		}
+		appStatusInfo.closeLock.Unlock()
+	case line := <-failChan:
+		a.settingErrStatus("启动失败 => "+strings.TrimSpace(string(line)), appStatusInfo, appRunErrTypeApp)
+	case <-timer.C:
+		a.settingErrStatus(fmt.Sprintf("启动超时, %d秒内未就绪", int64(timeout/time.Second)), appStatusInfo, appRunErrTypeApp)
+	}
+}
+
+// WaitAppReady 等待应用就绪, 应用在就绪前退出、匹配到失败日志或超时时返回错误
+func (a *appRunMgr) WaitAppReady(appName string) error {
+	a.Lock()
+	statusInfo, ok := a.startAppMap[appName]
+	a.Unlock()
+	if !ok {
+		return errors.New("app未启动")
+	}
+
+	if statusInfo.readyChan == nil {
+		return nil
+	}
+
+	select {
+	case <-statusInfo.readyChan:
+		return nil
+	case <-statusInfo.exitChannel:
+		statusInfo.closeLock.Lock()
+		defer statusInfo.closeLock.Unlock()
+		return errors.New(statusInfo.ErrMsg)
+	}
+}
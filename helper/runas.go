@@ -0,0 +1,120 @@
+package helper
+
+import (
+	"errors"
+	"github.com/byzk-org/bypt-server/consts"
+	"github.com/byzk-org/bypt-server/vos"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+)
+
+// appRunCredential 应用及插件的运行身份
+type appRunCredential struct {
+	username string
+	uid      uint32
+	gid      uint32
+	groups   []uint32
+}
+
+// resolveRunCredential 解析并校验启动信息中的运行用户及用户组, 未指定时使用服务默认用户
+func resolveRunCredential(appStartInfo *vos.DbAppStartInfo) (*appRunCredential, error) {
+	runUser := consts.User
+	if appStartInfo.RunUser != "" {
+		u, err := lookupRunUser(appStartInfo.RunUser)
+		if err != nil {
+			return nil, errors.New("获取运行用户[" + appStartInfo.RunUser + "]失败")
+		}
+		runUser = u
+	}
+
+	uid, err := strconv.ParseUint(runUser.Uid, 10, 32)
+	if err != nil {
+		return nil, errors.New("获取用户[" + runUser.Username + "]的uid失败")
+	}
+
+	gidStr := runUser.Gid
+	if appStartInfo.RunGroup != "" {
+		if gidStr, err = lookupRunGroupId(appStartInfo.RunGroup); err != nil {
+			return nil, errors.New("获取运行用户组[" + appStartInfo.RunGroup + "]失败")
+		}
+	}
+
+	gid, err := strconv.ParseUint(gidStr, 10, 32)
+	if err != nil {
+		return nil, errors.New("获取用户[" + runUser.Username + "]的gid失败")
+	}
+
+	credential := &appRunCredential{
+		username: runUser.Username,
+		uid:      uint32(uid),
+		gid:      uint32(gid),
+		groups:   make([]uint32, 0, len(appStartInfo.RunGroups)),
+	}
+
+	for _, groupName := range appStartInfo.RunGroups {
+		groupId, err := lookupRunGroupId(groupName)
+		if err != nil {
+			return nil, errors.New("获取附加用户组[" + groupName + "]失败")
+		}
+
+		id, err := strconv.ParseUint(groupId, 10, 32)
+		if err != nil {
+			return nil, errors.New("获取附加用户组[" + groupName + "]的gid失败")
+		}
+		credential.groups = append(credential.groups, uint32(id))
+	}
+	return credential, nil
+}
+
+// lookupRunUser 根据用户名或uid查找用户
+func lookupRunUser(name string) (*user.User, error) {
+	if u, err := user.Lookup(name); err == nil {
+		return u, nil
+	}
+	return user.LookupId(name)
+}
+
+// lookupRunGroupId 根据组名或gid查找用户组
+func lookupRunGroupId(name string) (string, error) {
+	if g, err := user.LookupGroup(name); err == nil {
+		return g.Gid, nil
+	}
+
+	g, err := user.LookupGroupId(name)
+	if err != nil {
+		return "", err
+	}
+	return g.Gid, nil
+}
+
+// sysProcAttr 进程的运行身份属性
+func (c *appRunCredential) sysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{
+		Credential: &syscall.Credential{
+			Uid:    c.uid,
+			Gid:    c.gid,
+			Groups: c.groups,
+		},
+	}
+}
+
+// chown 将路径及其下所有文件的所属用户更改为运行用户
+func (c *appRunCredential) chown(path string) error {
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+
+	return filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err = os.Lchown(p, int(c.uid), int(c.gid)); err != nil {
+			return errors.New("更改[" + p + "]的所属用户为[" + c.username + "]失败")
+		}
+		return nil
+	})
+}
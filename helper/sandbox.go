@@ -0,0 +1,132 @@
+package helper
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/byzk-org/bypt-server/consts"
+	"github.com/byzk-org/bypt-server/vos"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// sandboxInitInfo 传递给沙箱初始化进程的信息
+type sandboxInitInfo struct {
+	Sandbox *vos.AppSandboxConfig `json:"sandbox"`
+	// Path 要运行的程序
+	Path string   `json:"path"`
+	Args []string `json:"args"`
+	Dir  string   `json:"dir"`
+	// RunRoot 所有应用运行目录的根目录
+	RunRoot string   `json:"runRoot"`
+	Uid     uint32   `json:"uid"`
+	Gid     uint32   `json:"gid"`
+	Groups  []uint32 `json:"groups"`
+	// JdkDir 应用使用的包内jdk缓存, 位于运行根目录下, 隐藏运行根目录后以只读方式重新挂载
+	JdkDir string `json:"jdkDir,omitempty"`
+}
+
+// IsSandboxInit 当前进程是否为沙箱初始化进程
+func IsSandboxInit() bool {
+	return os.Getenv(consts.SandboxInitEnv) != ""
+}
+
+// RunSandboxInit 在新的命名空间内完成隔离设置后替换为应用程序, 成功时不会返回
+func RunSandboxInit() {
+	info := &sandboxInitInfo{}
+	infoBytes, err := base64.StdEncoding.DecodeString(os.Getenv(consts.SandboxInitEnv))
+	if err == nil {
+		err = json.Unmarshal(infoBytes, info)
+	}
+
+	if err != nil || info.Sandbox == nil {
+		fmt.Println("获取沙箱信息失败")
+		os.Exit(1)
+	}
+
+	if err = runSandboxInit(info); err != nil {
+		fmt.Println("沙箱初始化失败 => " + err.Error())
+		os.Exit(1)
+	}
+}
+
+// checkSandboxConfig 校验沙箱配置
+func checkSandboxConfig(sandbox *vos.AppSandboxConfig) error {
+	if sandbox == nil {
+		return nil
+	}
+
+	if err := checkSandboxSupported(); err != nil {
+		return err
+	}
+
+	if len(sandbox.ReadOnlyPaths) > 0 && !sandbox.MountNs {
+		return errors.New("只读挂载路径需要开启挂载命名空间")
+	}
+
+	for _, p := range sandbox.ReadOnlyPaths {
+		if !filepath.IsAbs(p) {
+			return errors.New("只读挂载路径 [" + p + "] 必须为绝对路径")
+		}
+
+		if _, err := os.Stat(p); err != nil {
+			return errors.New("只读挂载路径 [" + p + "] 不存在")
+		}
+	}
+	return nil
+}
+
+// wrapSandboxCmd 将应用命令替换为在沙箱中运行的初始化进程
+func (a *appRunMgr) wrapSandboxCmd(cmd *exec.Cmd, appStatusInfo *AppStatusInfo) error {
+	path, err := exec.LookPath(cmd.Path)
+	if err != nil {
+		return errors.New("查找可执行程序 [" + cmd.Path + "] 失败")
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return errors.New("获取服务程序路径失败")
+	}
+
+	credential := appStatusInfo.credential
+	initInfo := &sandboxInitInfo{
+		Sandbox: appStatusInfo.StartArgs.Sandbox,
+		Path:    path,
+		Args:    cmd.Args,
+		Dir:     cmd.Dir,
+		RunRoot: appStatusInfo.runRootDir,
+		Uid:     credential.uid,
+		Gid:     credential.gid,
+		Groups:  credential.groups,
+	}
+	if appStatusInfo.jdkCacheKey != "" {
+		initInfo.JdkDir = filepath.Join(jdkCacheRoot(appStatusInfo.runRootDir), appStatusInfo.jdkCacheKey)
+	}
+
+	infoBytes, err := json.Marshal(initInfo)
+	if err != nil {
+		return errors.New("转换沙箱信息失败")
+	}
+
+	cmd.Path = self
+	cmd.Args = []string{self}
+	cmd.Env = append(cmd.Env, consts.SandboxInitEnv+"="+base64.StdEncoding.EncodeToString(infoBytes))
+	cmd.SysProcAttr = sandboxSysProcAttr(appStatusInfo.StartArgs.Sandbox)
+	return nil
+}
+
+// sandboxExecEnv 去除沙箱信息后的应用环境变量
+func sandboxExecEnv() []string {
+	prefix := consts.SandboxInitEnv + "="
+	environ := os.Environ()
+	env := make([]string, 0, len(environ))
+	for _, e := range environ {
+		if !strings.HasPrefix(e, prefix) {
+			env = append(env, e)
+		}
+	}
+	return env
+}
@@ -0,0 +1,265 @@
+//go:build linux
+// +build linux
+
+package helper
+
+import (
+	"errors"
+	"github.com/byzk-org/bypt-server/vos"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	sandboxPrCapBSetDrop        = 24
+	sandboxPrSetNoNewPrivs      = 38
+	sandboxPrCapAmbient         = 47
+	sandboxPrCapAmbientClearAll = 4
+	sandboxCapVersion3          = 0x20080522
+)
+
+// checkSandboxSupported 沙箱需要root权限创建命名空间
+func checkSandboxSupported() error {
+	if os.Geteuid() != 0 {
+		return errors.New("沙箱隔离需要以root用户运行服务")
+	}
+	return nil
+}
+
+// sandboxSysProcAttr 沙箱初始化进程的命名空间属性, 初始化进程以root运行, 完成隔离后再切换用户
+func sandboxSysProcAttr(sandbox *vos.AppSandboxConfig) *syscall.SysProcAttr {
+	var cloneFlags uintptr
+	if sandbox.MountNs || sandbox.PidNs {
+		cloneFlags |= syscall.CLONE_NEWNS
+	}
+
+	if sandbox.PidNs {
+		cloneFlags |= syscall.CLONE_NEWPID
+	}
+
+	if sandbox.NetNs {
+		cloneFlags |= syscall.CLONE_NEWNET
+	}
+
+	return &syscall.SysProcAttr{
+		Cloneflags: cloneFlags,
+	}
+}
+
+// runSandboxInit 在沙箱初始化进程中完成挂载、权限及用户设置, 然后替换为应用程序
+func runSandboxInit(info *sandboxInitInfo) error {
+	runtime.LockOSThread()
+	sandbox := info.Sandbox
+
+	if sandbox.MountNs || sandbox.PidNs {
+		if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+			return errors.New("设置挂载点为私有失败 => " + err.Error())
+		}
+	}
+
+	if sandbox.PidNs {
+		if err := syscall.Mount("proc", "/proc", "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, ""); err != nil {
+			return errors.New("挂载/proc失败 => " + err.Error())
+		}
+	}
+
+	if sandbox.MountNs {
+		runDirFd, err := syscall.Open(info.Dir, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+		if err != nil {
+			return errors.New("打开运行目录失败 => " + err.Error())
+		}
+		defer syscall.Close(runDirFd)
+
+		jdkDirFd := -1
+		if info.JdkDir != "" {
+			if jdkDirFd, err = syscall.Open(info.JdkDir, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0); err != nil {
+				return errors.New("打开jdk缓存目录失败 => " + err.Error())
+			}
+			defer syscall.Close(jdkDirFd)
+		}
+
+		for _, p := range sandbox.ReadOnlyPaths {
+			if err = mountSandboxReadOnly(p); err != nil {
+				return err
+			}
+		}
+
+		if err = syscall.Mount("tmpfs", "/tmp", "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777"); err != nil {
+			return errors.New("挂载私有/tmp失败 => " + err.Error())
+		}
+
+		if err = mountSandboxRunDir(runDirFd, info.RunRoot, info.Dir); err != nil {
+			return err
+		}
+
+		if jdkDirFd != -1 {
+			if err = mountSandboxJdkDir(jdkDirFd, info.JdkDir); err != nil {
+				return err
+			}
+		}
+	}
+
+	if sandbox.NetNs {
+		if err := setSandboxLoopbackUp(); err != nil {
+			return err
+		}
+	}
+
+	if sandbox.NoNewPrivs {
+		if _, _, e := syscall.RawSyscall(syscall.SYS_PRCTL, sandboxPrSetNoNewPrivs, 1, 0); e != 0 {
+			return errors.New("设置no_new_privs失败 => " + e.Error())
+		}
+	}
+
+	if sandbox.DropCaps {
+		if err := dropSandboxBoundingCaps(); err != nil {
+			return err
+		}
+	}
+
+	groups := make([]int, 0, len(info.Groups))
+	for _, g := range info.Groups {
+		groups = append(groups, int(g))
+	}
+
+	if err := syscall.Setgroups(groups); err != nil {
+		return errors.New("设置附加用户组失败 => " + err.Error())
+	}
+
+	if err := syscall.Setgid(int(info.Gid)); err != nil {
+		return errors.New("设置运行用户组失败 => " + err.Error())
+	}
+
+	if err := syscall.Setuid(int(info.Uid)); err != nil {
+		return errors.New("设置运行用户失败 => " + err.Error())
+	}
+
+	if sandbox.DropCaps {
+		if err := clearSandboxCaps(); err != nil {
+			return err
+		}
+	}
+
+	if err := syscall.Chdir(info.Dir); err != nil {
+		return errors.New("切换运行目录失败 => " + err.Error())
+	}
+
+	return syscall.Exec(info.Path, info.Args, sandboxExecEnv())
+}
+
+// mountSandboxReadOnly 将主机路径重新挂载为只读
+func mountSandboxReadOnly(p string) error {
+	if err := syscall.Mount(p, p, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return errors.New("绑定只读路径 [" + p + "] 失败 => " + err.Error())
+	}
+
+	if err := syscall.Mount("", p, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY|syscall.MS_NOSUID, ""); err != nil {
+		return errors.New("设置路径 [" + p + "] 只读失败 => " + err.Error())
+	}
+	return nil
+}
+
+// mountSandboxRunDir 使用空的tmpfs覆盖运行根目录以隐藏其他应用, 再将应用自身的运行目录挂载回原位置,
+// 运行目录通过预先打开的fd挂载, 可能已被私有/tmp覆盖
+func mountSandboxRunDir(runDirFd int, runRoot, runDir string) error {
+	if runRoot != "" {
+		rel, err := filepath.Rel(runRoot, runDir)
+		if err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			if err = os.MkdirAll(runRoot, 0755); err != nil {
+				return errors.New("创建运行根目录失败 => " + err.Error())
+			}
+
+			if err = syscall.Mount("tmpfs", runRoot, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=0755"); err != nil {
+				return errors.New("隐藏应用运行目录失败 => " + err.Error())
+			}
+		}
+	}
+
+	if err := os.MkdirAll(runDir, 0755); err != nil {
+		return errors.New("创建运行目录失败 => " + err.Error())
+	}
+
+	if err := syscall.Mount("/proc/self/fd/"+strconv.Itoa(runDirFd), runDir, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return errors.New("挂载运行目录失败 => " + err.Error())
+	}
+	return nil
+}
+
+// mountSandboxJdkDir 将应用使用的jdk缓存以只读方式挂载回原位置
+func mountSandboxJdkDir(jdkDirFd int, jdkDir string) error {
+	if err := os.MkdirAll(jdkDir, 0755); err != nil {
+		return errors.New("创建jdk缓存目录失败 => " + err.Error())
+	}
+
+	if err := syscall.Mount("/proc/self/fd/"+strconv.Itoa(jdkDirFd), jdkDir, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return errors.New("挂载jdk缓存目录失败 => " + err.Error())
+	}
+
+	if err := syscall.Mount("", jdkDir, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY|syscall.MS_NOSUID, ""); err != nil {
+		return errors.New("设置jdk缓存目录只读失败 => " + err.Error())
+	}
+	return nil
+}
+
+// setSandboxLoopbackUp 启用网络命名空间中的回环网卡
+func setSandboxLoopbackUp() error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return errors.New("创建网络控制套接字失败 => " + err.Error())
+	}
+	defer syscall.Close(fd)
+
+	// ifreq: 网卡名称之后为flags字段
+	var ifr [40]byte
+	copy(ifr[:syscall.IFNAMSIZ-1], "lo")
+	if _, _, e := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCGIFFLAGS, uintptr(unsafe.Pointer(&ifr[0]))); e != 0 {
+		return errors.New("获取回环网卡状态失败 => " + e.Error())
+	}
+
+	flags := (*uint16)(unsafe.Pointer(&ifr[syscall.IFNAMSIZ]))
+	*flags |= syscall.IFF_UP | syscall.IFF_RUNNING
+	if _, _, e := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCSIFFLAGS, uintptr(unsafe.Pointer(&ifr[0]))); e != 0 {
+		return errors.New("启用回环网卡失败 => " + e.Error())
+	}
+	return nil
+}
+
+// dropSandboxBoundingCaps 清空capabilities边界集及ambient集, 进程及其子进程无法再获得任何capability
+func dropSandboxBoundingCaps() error {
+	for c := uintptr(0); ; c++ {
+		_, _, e := syscall.RawSyscall(syscall.SYS_PRCTL, sandboxPrCapBSetDrop, c, 0)
+		if e == syscall.EINVAL {
+			break
+		}
+
+		if e != 0 {
+			return errors.New("丢弃capabilities失败 => " + e.Error())
+		}
+	}
+
+	_, _, _ = syscall.RawSyscall6(syscall.SYS_PRCTL, sandboxPrCapAmbient, sandboxPrCapAmbientClearAll, 0, 0, 0, 0)
+	return nil
+}
+
+// clearSandboxCaps 清空当前线程的capabilities, 运行用户为root时切换用户不会自动清空
+func clearSandboxCaps() error {
+	header := struct {
+		version uint32
+		pid     int32
+	}{version: sandboxCapVersion3}
+	var data [2]struct {
+		effective   uint32
+		permitted   uint32
+		inheritable uint32
+	}
+
+	if _, _, e := syscall.RawSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data[0])), 0); e != 0 {
+		return errors.New("清空capabilities失败 => " + e.Error())
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+// +build !linux
+
+package helper
+
+import (
+	"errors"
+	"github.com/byzk-org/bypt-server/vos"
+	"syscall"
+)
+
+// checkSandboxSupported 沙箱仅支持linux
+func checkSandboxSupported() error {
+	return errors.New("当前系统不支持沙箱隔离")
+}
+
+func sandboxSysProcAttr(sandbox *vos.AppSandboxConfig) *syscall.SysProcAttr {
+	return nil
+}
+
+func runSandboxInit(info *sandboxInitInfo) error {
+	return checkSandboxSupported()
+}
@@ -0,0 +1,147 @@
+package helper
+
+import (
+	cryptoRand "crypto/rand"
+	"errors"
+	"github.com/byzk-org/bypt-server/consts"
+	"github.com/byzk-org/bypt-server/db"
+	"github.com/byzk-org/bypt-server/utils"
+	"github.com/byzk-org/bypt-server/vos"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+var (
+	// secretRefRegexp 环境配置中的密钥引用, 例: ${secret:db-pass}
+	secretRefRegexp  = regexp.MustCompile(`\$\{secret:([^}]*)}`)
+	secretNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+	nodeSecretKeyLock sync.Mutex
+	nodeSecretKey     []byte
+)
+
+// SetSecret 保存密钥, 同名密钥将被覆盖
+func SetSecret(name string, val []byte) error {
+	if !secretNameRegexp.MatchString(name) {
+		return errors.New("密钥名称只能包含字母、数字、下划线、中划线和点")
+	}
+
+	if len(val) == 0 {
+		return errors.New("密钥的值不能为空")
+	}
+
+	key, err := getNodeSecretKey()
+	if err != nil {
+		return err
+	}
+
+	encVal, err := utils.Sm4Encrypt(key, val)
+	if err != nil {
+		return errors.New("加密密钥失败")
+	}
+
+	now := time.Now()
+	secret := &vos.DbSecret{}
+	if err = db.GetDb().Where(&vos.DbSecret{
+		Name: name,
+	}).First(&secret).Error; err != nil || secret.Name == "" {
+		secret = &vos.DbSecret{
+			Name:       name,
+			CreateTime: now,
+		}
+	}
+	secret.Value = encVal
+	secret.EndUpdateTime = now
+
+	if err = db.GetDb().Save(secret).Error; err != nil {
+		return errors.New("保存密钥失败")
+	}
+	return nil
+}
+
+// resolveSecretRefs 将值中的密钥引用替换为密钥明文, 仅在进程启动时调用
+func resolveSecretRefs(val string) (string, error) {
+	if !secretRefRegexp.MatchString(val) {
+		return val, nil
+	}
+
+	var resolveErr error
+	resolved := secretRefRegexp.ReplaceAllStringFunc(val, func(ref string) string {
+		if resolveErr != nil {
+			return ""
+		}
+
+		plain, err := getSecret(secretRefRegexp.FindStringSubmatch(ref)[1])
+		if err != nil {
+			resolveErr = err
+			return ""
+		}
+		return string(plain)
+	})
+	if resolveErr != nil {
+		return "", resolveErr
+	}
+	return resolved, nil
+}
+
+// getSecret 获取密钥明文
+func getSecret(name string) ([]byte, error) {
+	secret := &vos.DbSecret{}
+	if err := db.GetDb().Where(&vos.DbSecret{
+		Name: name,
+	}).First(&secret).Error; err != nil || secret.Name == "" {
+		return nil, errors.New("未找到引用的密钥 [" + name + "]")
+	}
+
+	key, err := getNodeSecretKey()
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := utils.Sm4Decrypt(key, secret.Value)
+	if err != nil {
+		return nil, errors.New("解密密钥 [" + name + "] 失败")
+	}
+	return plain, nil
+}
+
+// getNodeSecretKey 获取节点密钥, 不存在时生成, 节点密钥使用运行证书加密后保存在数据目录中
+func getNodeSecretKey() ([]byte, error) {
+	nodeSecretKeyLock.Lock()
+	defer nodeSecretKeyLock.Unlock()
+	if nodeSecretKey != nil {
+		return nodeSecretKey, nil
+	}
+
+	keyPath := filepath.Join(consts.DbPathDir, ".node.key")
+	if encKey, err := ioutil.ReadFile(keyPath); err == nil {
+		key, err := utils.Sm2Decrypt(consts.CaPrivateKey, encKey)
+		if err != nil || len(key) != 16 {
+			return nil, errors.New("解析节点密钥失败")
+		}
+		nodeSecretKey = key
+		return nodeSecretKey, nil
+	} else if !os.IsNotExist(err) {
+		return nil, errors.New("读取节点密钥失败")
+	}
+
+	key := make([]byte, 16)
+	if _, err := cryptoRand.Read(key); err != nil {
+		return nil, errors.New("生成节点密钥失败")
+	}
+
+	encKey, err := utils.Sm2Encrypt(consts.CaPubKey, key)
+	if err != nil {
+		return nil, errors.New("加密节点密钥失败")
+	}
+
+	if err = ioutil.WriteFile(keyPath, encKey, 0600); err != nil {
+		return nil, errors.New("保存节点密钥失败")
+	}
+	nodeSecretKey = key
+	return nodeSecretKey, nil
+}
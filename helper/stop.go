@@ -0,0 +1,136 @@
+package helper
+
+import (
+	"errors"
+	"github.com/byzk-org/bypt-server/vos"
+	"time"
+)
+
+// defaultStopTimeout 默认停止阶段插件超时时间, 单位秒
+const defaultStopTimeout int64 = 30
+
+// markStopping 将应用设置为停止中, 应用已在停止中时返回false, 调用方需持有管理器锁
+func (a *appRunMgr) markStopping(appStatusInfo *AppStatusInfo) bool {
+	appStatusInfo.closeLock.Lock()
+	defer appStatusInfo.closeLock.Unlock()
+	if appStatusInfo.isStopping {
+		return false
+	}
+
+	appStatusInfo.isStopping = true
+	if !appStatusInfo.isClose {
+		appStatusInfo.Status = appRunStatusStopping
+	}
+	return true
+}
+
+// runStopPlugins 依次执行pre-stop、stop、post-stop插件, 调用方需先通过markStopping设置停止中且不持有管理器锁, 执行完成后由调用方结束应用
+func (a *appRunMgr) runStopPlugins(appStatusInfo *AppStatusInfo) {
+	defer func() { recover() }()
+
+	var preStopPlugins, stopPlugins, postStopPlugins []*vos.DbAppPlugin
+	for _, plugin := range appStatusInfo.VersionInfo.PluginInfo {
+		switch plugin.Type {
+		case vos.AppPluginTypePreStop:
+			preStopPlugins = append(preStopPlugins, plugin)
+		case vos.AppPluginTypeStop:
+			stopPlugins = append(stopPlugins, plugin)
+		case vos.AppPluginTypePostStop:
+			postStopPlugins = append(postStopPlugins, plugin)
+		}
+	}
+
+	if len(preStopPlugins)+len(stopPlugins)+len(postStopPlugins) == 0 {
+		return
+	}
+
+	appStatusInfo.closeLock.Lock()
+	isClose := appStatusInfo.isClose
+	appStatusInfo.closeLock.Unlock()
+	if isClose {
+		return
+	}
+
+	stopTimeout := appStatusInfo.StartArgs.StopTimeout
+	if stopTimeout <= 0 {
+		stopTimeout = defaultStopTimeout
+	}
+	timeout := time.Duration(stopTimeout) * time.Second
+
+	for _, plugin := range preStopPlugins {
+		a.runStopPlugin(plugin, appStatusInfo, timeout)
+	}
+
+	if len(stopPlugins) > 0 {
+		for _, plugin := range stopPlugins {
+			a.runStopPlugin(plugin, appStatusInfo, timeout)
+		}
+		waitAppExit(appStatusInfo, timeout)
+	}
+
+	if len(postStopPlugins) == 0 {
+		return
+	}
+
+	if appStatusInfo.runCmd != nil && appStatusInfo.runCmd.Process != nil {
+		_ = appStatusInfo.runCmd.Process.Kill()
+	}
+
+	if !waitAppExit(appStatusInfo, timeout) {
+		return
+	}
+
+	// 等待退出时的运行目录清理完成
+	appStatusInfo.closeLock.Lock()
+	appStatusInfo.closeLock.Unlock()
+
+	for _, plugin := range postStopPlugins {
+		a.runStopPlugin(plugin, appStatusInfo, timeout)
+	}
+}
+
+// runStopPlugin 以__cmd__=stop运行插件, 超时后强制结束, 异常信息记录到插件输出中
+func (a *appRunMgr) runStopPlugin(plugin *vos.DbAppPlugin, appStatusInfo *AppStatusInfo, timeout time.Duration) {
+	a.Lock()
+	command, err := a.preparePluginCmd(plugin, appStatusInfo, "stop")
+	a.Unlock()
+	if err != nil {
+		appStatusInfo.pluginOutput(plugin.Name).writeMsg("插件(" + plugin.Name + ")准备失败 => " + err.Error())
+		return
+	}
+
+	if err = a.startPluginCmd(command, plugin.Name, appStatusInfo); err != nil {
+		appStatusInfo.pluginOutput(plugin.Name).writeMsg("插件(" + plugin.Name + ")启动失败 => " + err.Error())
+		return
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- appStatusInfo.waitPluginCmd(command, plugin.Name) }()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err = <-done:
+	case <-timer.C:
+		killPluginCmd(command, done)
+		err = errors.New("运行超时")
+	}
+
+	if err != nil {
+		appStatusInfo.pluginOutput(plugin.Name).writeMsg("插件(" + plugin.Name + ") 运行异常 => " + err.Error())
+	}
+}
+
+// waitAppExit 等待应用退出, 超时返回false
+func waitAppExit(appStatusInfo *AppStatusInfo, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-appStatusInfo.exitChannel:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
@@ -0,0 +1,306 @@
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"github.com/byzk-org/bypt-server/db"
+	"github.com/byzk-org/bypt-server/vos"
+	"github.com/jinzhu/gorm"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultUpgradeReadyTimeout 默认等待新版本就绪的时间, 单位秒
+	defaultUpgradeReadyTimeout int64 = 120
+	// defaultUpgradeVerifyTime 默认停止旧版本后的验证时间, 单位秒
+	defaultUpgradeVerifyTime int64 = 60
+)
+
+// UpgradeApp 滚动升级应用, 新版本就绪后停止旧版本, 验证期内新版本异常则回滚到旧版本,
+// operationLock 仅在启动、切换及回滚时持有, 等待就绪及验证期间不阻塞其他操作
+func (a *appRunMgr) UpgradeApp(upgradeInfo *vos.AppUpgradeInfo, progress func(msg string), operationLock sync.Locker) error {
+	if upgradeInfo == nil || upgradeInfo.StartInfo == nil {
+		return errors.New("获取应用升级信息失败")
+	}
+
+	newStartInfo := upgradeInfo.StartInfo
+	if newStartInfo.Name == "" {
+		return errors.New("要升级的应用名称不能为空")
+	}
+
+	if newStartInfo.Version == "" {
+		return errors.New("要升级的目标版本不能为空")
+	}
+
+	var readyPattern *regexp.Regexp
+	if upgradeInfo.ReadyLogPattern != "" {
+		compile, err := regexp.Compile(upgradeInfo.ReadyLogPattern)
+		if err != nil {
+			return errors.New("就绪日志正则表达式格式不正确")
+		}
+		readyPattern = compile
+	}
+
+	readyTimeout := upgradeInfo.ReadyTimeout
+	if readyTimeout <= 0 {
+		readyTimeout = defaultUpgradeReadyTimeout
+	}
+
+	verifyTime := upgradeInfo.VerifyTime
+	if verifyTime <= 0 {
+		verifyTime = defaultUpgradeVerifyTime
+	}
+
+	operationLock.Lock()
+	oldStatusInfo, newStatusInfo, record, err := a.startUpgradeApp(newStartInfo, progress)
+	operationLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	oldStartInfo := oldStatusInfo.StartArgs
+	outName := fmt.Sprintf("[%s-%s]", record.AppName, record.ToVersion)
+	progress(outName + "等待就绪")
+	if err := a.waitUpgradeReady(newStatusInfo, readyPattern, upgradeInfo.ProbeAddr, time.Duration(readyTimeout)*time.Second); err != nil {
+		a.removeUpgradeApp(record.AppName, "升级失败")
+		a.saveUpgradeRecord(record, vos.AppUpgradeStatusFail, err.Error())
+		return err
+	}
+
+	progress(fmt.Sprintf("%s已就绪, 停止[%s-%s]", outName, record.AppName, record.FromVersion))
+	operationLock.Lock()
+	err = a.promoteUpgradeApp(oldStatusInfo, newStatusInfo)
+	operationLock.Unlock()
+	if err != nil {
+		a.removeUpgradeApp(record.AppName, "升级失败")
+		a.saveUpgradeRecord(record, vos.AppUpgradeStatusFail, err.Error())
+		return err
+	}
+
+	a.saveUpgradeRecord(record, vos.AppUpgradeStatusVerify, "")
+	go a.verifyUpgradeApp(record, oldStartInfo, newStatusInfo, time.Duration(verifyTime)*time.Second, operationLock)
+	progress(fmt.Sprintf("%s升级成功, 将在%d秒内验证运行状态, 异常时自动回滚", outName, verifyTime))
+	return nil
+}
+
+// startUpgradeApp 校验应用可以升级并启动目标版本, 调用方需持有全局操作锁, 避免同一应用被并发升级
+func (a *appRunMgr) startUpgradeApp(newStartInfo *vos.DbAppStartInfo, progress func(msg string)) (*AppStatusInfo, *AppStatusInfo, *vos.DbAppUpgradeRecord, error) {
+	a.Lock()
+	oldStatusInfo, ok := a.startAppMap[newStartInfo.Name]
+	_, isUpgrading := a.upgradeAppMap[newStartInfo.Name]
+	a.Unlock()
+
+	if !ok || oldStatusInfo.isClose {
+		return nil, nil, nil, errors.New("应用未启动, 请直接启动目标版本")
+	}
+
+	if isUpgrading {
+		return nil, nil, nil, errors.New("应用正在升级, 请勿重复升级")
+	}
+
+	if oldStatusInfo.VersionStr == newStartInfo.Version {
+		return nil, nil, nil, errors.New("目标版本与正在运行的版本相同")
+	}
+
+	now := time.Now()
+	record := &vos.DbAppUpgradeRecord{
+		Id:          fmt.Sprintf("%s-%d", newStartInfo.Name, now.UnixNano()),
+		AppName:     newStartInfo.Name,
+		FromVersion: oldStatusInfo.VersionStr,
+		ToVersion:   newStartInfo.Version,
+		Status:      vos.AppUpgradeStatusWaitReady,
+		StartTime:   now,
+	}
+	if err := db.GetDb().Create(record).Error; err != nil {
+		return nil, nil, nil, errors.New("保存升级记录失败")
+	}
+
+	outName := fmt.Sprintf("[%s-%s]", record.AppName, record.ToVersion)
+	progress(outName + "正在启动")
+	newStatusInfo, err := a.startApp(newStartInfo, appStartModeUpgrade)
+	if err != nil {
+		a.removeUpgradeApp(record.AppName, "升级失败")
+		a.saveUpgradeRecord(record, vos.AppUpgradeStatusFail, "启动目标版本失败 => "+err.Error())
+		return nil, nil, nil, err
+	}
+	return oldStatusInfo, newStatusInfo, record, nil
+}
+
+// waitUpgradeReady 等待升级的新版本就绪
+func (a *appRunMgr) waitUpgradeReady(statusInfo *AppStatusInfo, readyPattern *regexp.Regexp, probeAddr string, timeout time.Duration) error {
+	var logMatchChan chan []byte
+	if readyPattern != nil {
+		watcher := statusInfo.addLogWatcher(readyPattern)
+		defer statusInfo.removeLogWatcher(watcher)
+		logMatchChan = watcher.matchChan
+	}
+
+	timeoutTimer := time.NewTimer(timeout)
+	defer timeoutTimer.Stop()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	logReady := logMatchChan == nil
+	for {
+		select {
+		case <-statusInfo.exitChannel:
+			return errors.New("目标版本运行异常 => " + statusInfo.ErrMsg)
+		case <-timeoutTimer.C:
+			return errors.New("等待目标版本就绪超时")
+		case <-logMatchChan:
+			logReady = true
+			logMatchChan = nil
+		case <-ticker.C:
+		}
+
+		if !logReady || statusInfo.Status != appRunStatusRunner {
+			continue
+		}
+
+		if probeAddr == "" || probeUpgradeAddr(probeAddr) == nil {
+			return nil
+		}
+	}
+}
+
+// promoteUpgradeApp 停止旧版本并将新版本设置为应用的运行版本
+func (a *appRunMgr) promoteUpgradeApp(oldStatusInfo, newStatusInfo *AppStatusInfo) error {
+	a.Lock()
+	appName := newStatusInfo.Name
+	if a.upgradeAppMap[appName] != newStatusInfo {
+		a.Unlock()
+		return errors.New("升级被取消")
+	}
+
+	// 旧版本的停止插件运行期间不持有管理器锁
+	if current, ok := a.startAppMap[appName]; ok && current == oldStatusInfo && a.markStopping(oldStatusInfo) {
+		a.Unlock()
+		a.runStopPlugins(oldStatusInfo)
+		a.Lock()
+	}
+	defer a.Unlock()
+
+	if a.upgradeAppMap[appName] != newStatusInfo {
+		return errors.New("升级被取消")
+	}
+
+	return db.GetDb().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&vos.DbAppInfo{}).Where(&vos.DbAppInfo{
+			Name: appName,
+		}).Update(&vos.DbAppInfo{
+			CurrentVersion: newStatusInfo.VersionStr,
+		}).Error; err != nil {
+			return errors.New("更新应用信息失败")
+		}
+
+		if err := a.saveStartInfo(tx, newStatusInfo.StartArgs); err != nil {
+			return err
+		}
+
+		if current, ok := a.startAppMap[appName]; ok && current == oldStatusInfo {
+			a.closeStopRestartChan(oldStatusInfo)
+			a.settingErrStatus("升级停止", oldStatusInfo, appRunErrTypeData)
+		}
+
+		delete(a.upgradeAppMap, appName)
+		a.startAppMap[appName] = newStatusInfo
+		return nil
+	})
+}
+
+// verifyUpgradeApp 验证升级后的新版本, 验证期内异常退出则回滚到旧版本
+func (a *appRunMgr) verifyUpgradeApp(record *vos.DbAppUpgradeRecord, oldStartInfo *vos.DbAppStartInfo, newStatusInfo *AppStatusInfo, verifyTime time.Duration, operationLock sync.Locker) {
+	defer func() { recover() }()
+
+	timer := time.NewTimer(verifyTime)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		newStatusInfo.closeLock.Lock()
+		isClose := newStatusInfo.isClose
+		if !isClose {
+			newStatusInfo.startMode = appStartModeNormal
+		}
+		newStatusInfo.closeLock.Unlock()
+		if !isClose {
+			a.saveUpgradeRecord(record, vos.AppUpgradeStatusSuccess, "")
+			return
+		}
+	case <-newStatusInfo.exitChannel:
+	}
+
+	reason := "目标版本运行异常 => " + newStatusInfo.ErrMsg
+
+	a.Lock()
+	current, ok := a.startAppMap[record.AppName]
+	isStopByUser := !ok || current != newStatusInfo
+	if !isStopByUser {
+		delete(a.startAppMap, record.AppName)
+	}
+	a.Unlock()
+
+	if isStopByUser {
+		a.saveUpgradeRecord(record, vos.AppUpgradeStatusFail, "验证期间应用被停止")
+		return
+	}
+
+	operationLock.Lock()
+	err := a.StartApp(oldStartInfo)
+	operationLock.Unlock()
+	if err != nil {
+		a.saveUpgradeRecord(record, vos.AppUpgradeStatusFail, reason+", 回滚失败 => "+err.Error())
+		return
+	}
+	a.saveUpgradeRecord(record, vos.AppUpgradeStatusRollback, reason)
+}
+
+// removeUpgradeApp 停止并移除升级中的新版本
+func (a *appRunMgr) removeUpgradeApp(appName, errMsg string) {
+	a.Lock()
+	defer a.Unlock()
+	statusInfo, ok := a.upgradeAppMap[appName]
+	if !ok {
+		return
+	}
+	a.settingErrStatus(errMsg, statusInfo, appRunErrTypeData)
+	delete(a.upgradeAppMap, appName)
+}
+
+// saveUpgradeRecord 更新升级记录状态
+func (a *appRunMgr) saveUpgradeRecord(record *vos.DbAppUpgradeRecord, status vos.AppUpgradeStatus, msg string) {
+	record.Status = status
+	record.Msg = msg
+	if status != vos.AppUpgradeStatusVerify {
+		record.EndTime = time.Now()
+	}
+	_ = db.GetDb().Model(record).Update(record).Error
+}
+
+// probeUpgradeAddr 探测新版本健康状态
+func probeUpgradeAddr(addr string) error {
+	if strings.HasPrefix(addr, "http://") || strings.HasPrefix(addr, "https://") {
+		client := &http.Client{Timeout: 3 * time.Second}
+		resp, err := client.Get(addr)
+		if err != nil {
+			return err
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+			return errors.New("健康探测状态码异常")
+		}
+		return nil
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, 3*time.Second)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
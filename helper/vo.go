@@ -1,7 +1,6 @@
 package helper
 
 import (
-	"bytes"
 	"github.com/byzk-org/bypt-server/vos"
 	"io"
 	"os/exec"
@@ -14,9 +13,23 @@ type appRunStatus string
 const (
 	appRunStatusWaitRun     appRunStatus = "正在启动"
 	appRunStatusRunner      appRunStatus = "正在运行"
+	appRunStatusWaitReady   appRunStatus = "等待就绪"
 	appRunStatusRunError    appRunStatus = "运行异常"
 	appRunStatusWaitRestart appRunStatus = "等待重启"
 	appRunStatusRunRestart  appRunStatus = "正在重启"
+	appRunStatusFinish      appRunStatus = "运行结束"
+	appRunStatusStopping    appRunStatus = "正在停止"
+)
+
+type appStartMode int
+
+const (
+	// appStartModeNormal 普通启动
+	appStartModeNormal appStartMode = iota
+	// appStartModeUpgrade 升级时与旧版本并行启动
+	appStartModeUpgrade
+	// appStartModeJob 定时任务的单次运行
+	appStartModeJob
 )
 
 type appRunErrType int
@@ -30,42 +43,93 @@ const (
 )
 
 type AppStatusInfo struct {
-	StartArgs          *vos.DbAppStartInfo   `json:"startArgs,omitempty"`
-	Name               string                `json:"name,omitempty"`
-	Desc               string                `json:"desc,omitempty"`
-	AppInfo            *vos.DbAppInfo        `json:"appInfo,omitempty"`
-	VersionStr         string                `json:"versionStr,omitempty"`
-	VersionInfo        *vos.DbAppVersionInfo `json:"versionInfo,omitempty"`
-	StartTime          time.Time             `json:"startTime,omitempty"`
-	HaveErr            bool                  `json:"haveErr,omitempty"`
-	ErrMsg             string                `json:"errMsg,omitempty"`
-	JavaCmd            string                `json:"javaCmd,omitempty"`
-	PluginOutPutBuffer map[string][]byte     `json:"pluginOutPutBuffer,omitempty"`
-	Status             appRunStatus          `gorm:"-" json:"status,omitempty"`
-	IsRestart          bool                  `gorm:"-" json:"isRestart,omitempty"`
+	StartArgs          *vos.DbAppStartInfo             `json:"startArgs,omitempty"`
+	Name               string                          `json:"name,omitempty"`
+	Desc               string                          `json:"desc,omitempty"`
+	AppInfo            *vos.DbAppInfo                  `json:"appInfo,omitempty"`
+	VersionStr         string                          `json:"versionStr,omitempty"`
+	VersionInfo        *vos.DbAppVersionInfo           `json:"versionInfo,omitempty"`
+	StartTime          time.Time                       `json:"startTime,omitempty"`
+	HaveErr            bool                            `json:"haveErr,omitempty"`
+	ErrMsg             string                          `json:"errMsg,omitempty"`
+	ExitCode           int                             `json:"exitCode,omitempty"`
+	JavaCmd            string                          `json:"javaCmd,omitempty"`
+	PluginOutPutBuffer map[string][]byte               `json:"pluginOutPutBuffer,omitempty"`
+	PluginStatus       map[string]*vos.AppPluginStatus `json:"pluginStatus,omitempty"`
+	Status             appRunStatus                    `gorm:"-" json:"status,omitempty"`
+	IsRestart          bool                            `gorm:"-" json:"isRestart,omitempty"`
+	Events             []*vos.DbAppEvent               `gorm:"-" json:"events,omitempty"`
+	Ports              []*vos.AppPortConfig            `gorm:"-" json:"ports,omitempty"`
+	ConfigBundles      []*vos.AppConfigBundleRef       `gorm:"-" json:"configBundles,omitempty"`
+	Adopted            bool                            `gorm:"-" json:"adopted,omitempty"`
+	CommandLine        []string                        `gorm:"-" json:"commandLine,omitempty"`
+	SkippedPlugins     []*SkippedPluginInfo            `gorm:"-" json:"skippedPlugins,omitempty"`
 	exitChannel        chan string
 	runCmd             *exec.Cmd
 	pluginsCmd         []*exec.Cmd
 	runDir             string
 	closeLock          sync.Mutex
 	isClose            bool
+	isStopping         bool
+	stopByUser         bool
 	pluginOkChan       chan bool
-	pluginOutPutBuffer map[string]*bytes.Buffer
+	pluginOutputLock   sync.Mutex
+	pluginOutputs      map[string]*pluginOutput
+	pluginStatusLock   sync.Mutex
+	pluginStatus       map[string]*vos.AppPluginStatus
+	// pluginRestarting 插件已请求重启, 重启完成前忽略其他重启请求
+	pluginRestarting   bool
 	logCloser          io.Closer
 	stopRestartChannel chan bool
+	startMode          appStartMode
+	runId              string
+	// jdkCacheKey 使用中的包内jdk缓存, 进程退出后释放引用
+	jdkCacheKey string
+	// javaMajor 启动前已确定的java主版本, 0为未确定
+	javaMajor      int
+	readiness      *appReadiness
+	readyChan      chan struct{}
+	credential     *appRunCredential
+	runRootDir     string
+	logWatcherLock sync.Mutex
+	logWatchers    []*logWatcher
+	attachLock     sync.Mutex
+	attachViewers  []*AppAttach
+	attachWriter   *AppAttach
+	attachStdin    io.WriteCloser
+}
+
+// AppJobInfo 定时任务信息
+type AppJobInfo struct {
+	Name       string            `json:"name,omitempty"`
+	Version    string            `json:"version,omitempty"`
+	Job        *vos.AppJobConfig `json:"job,omitempty"`
+	PrevTime   time.Time         `json:"prevTime,omitempty"`
+	NextTime   time.Time         `json:"nextTime,omitempty"`
+	RunningNum int               `json:"runningNum,omitempty"`
 }
 
 func (a *AppStatusInfo) convertPluginsOutPut() {
 	if a.PluginOutPutBuffer == nil {
 		a.PluginOutPutBuffer = make(map[string][]byte)
 	}
-	if len(a.pluginOutPutBuffer) == 0 {
-		return
+	a.pluginOutputLock.Lock()
+	names := make([]string, 0, len(a.pluginOutputs))
+	for k := range a.pluginOutputs {
+		names = append(names, k)
 	}
+	a.pluginOutputLock.Unlock()
 
-	for k, v := range a.pluginOutPutBuffer {
-		if v != nil && v.Len() > 0 {
-			a.PluginOutPutBuffer[k] = v.Bytes()
+	for _, k := range names {
+		if output := a.pluginOutputBytes(k); len(output) > 0 {
+			a.PluginOutPutBuffer[k] = output
 		}
 	}
 }
+
+// SkippedPluginInfo 按插件选择未运行的插件
+type SkippedPluginInfo struct {
+	Name string            `json:"name,omitempty"`
+	Desc string            `json:"desc,omitempty"`
+	Type vos.AppPluginType `json:"type,omitempty"`
+}
@@ -0,0 +1,231 @@
+package helper
+
+import (
+	"errors"
+	"github.com/byzk-org/bypt-server/consts"
+	"github.com/byzk-org/bypt-server/db"
+	"github.com/byzk-org/bypt-server/vos"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// checkVolumeConfig 校验数据卷配置
+func checkVolumeConfig(volumes []*vos.AppVolumeConfig) error {
+	names := make(map[string]bool, len(volumes))
+	paths := make(map[string]bool, len(volumes))
+	for _, v := range volumes {
+		if err := checkVolumeName(v.Name); err != nil {
+			return err
+		}
+
+		if names[v.Name] {
+			return errors.New("数据卷名称 [" + v.Name + "] 重复")
+		}
+		names[v.Name] = true
+
+		p := filepath.Clean(filepath.FromSlash(v.Path))
+		if v.Path == "" || filepath.IsAbs(p) || p == "." || p == ".." || strings.HasPrefix(p, ".."+string(filepath.Separator)) {
+			return errors.New("数据卷 [" + v.Name + "] 的路径必须为运行目录内的相对路径")
+		}
+
+		if paths[p] {
+			return errors.New("数据卷路径 [" + v.Path + "] 重复")
+		}
+		paths[p] = true
+	}
+	return nil
+}
+
+// checkVolumeName 数据卷名称不能包含路径分隔符
+func checkVolumeName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return errors.New("数据卷名称 [" + name + "] 不合法")
+	}
+	return nil
+}
+
+// linkVolumes 创建数据卷目录并链接到运行目录中
+func (a *appRunMgr) linkVolumes(appStatusInfo *AppStatusInfo) error {
+	volumes := appStatusInfo.StartArgs.Volumes
+	if len(volumes) == 0 {
+		return nil
+	}
+
+	volumeRoot, err := volumeRootDir()
+	if err != nil {
+		return err
+	}
+
+	for _, v := range volumes {
+		volumeDir := filepath.Join(volumeRoot, appStatusInfo.Name, v.Name)
+		if err = os.MkdirAll(volumeDir, 0755); err != nil {
+			return errors.New("创建数据卷 [" + v.Name + "] 目录失败")
+		}
+
+		if err = appStatusInfo.credential.chown(volumeDir); err != nil {
+			return err
+		}
+
+		linkPath := filepath.Join(appStatusInfo.runDir, filepath.FromSlash(v.Path))
+		if err = removeEmptyVolumePath(linkPath); err != nil {
+			return errors.New("数据卷 [" + v.Name + "] 的路径 [" + v.Path + "] 在运行目录中已存在")
+		}
+
+		if err = os.MkdirAll(filepath.Dir(linkPath), 0755); err != nil {
+			return errors.New("创建数据卷 [" + v.Name + "] 的上级目录失败")
+		}
+
+		if err = os.Symlink(volumeDir, linkPath); err != nil {
+			return errors.New("链接数据卷 [" + v.Name + "] 失败 => " + err.Error())
+		}
+	}
+	return nil
+}
+
+// removeEmptyVolumePath 数据卷路径已存在时仅允许为空目录, 如程序包中自带的空数据目录
+func removeEmptyVolumePath(p string) error {
+	fileInfo, err := os.Lstat(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil || !fileInfo.IsDir() {
+		return errors.New("路径已存在")
+	}
+
+	fileInfos, err := ioutil.ReadDir(p)
+	if err != nil || len(fileInfos) > 0 {
+		return errors.New("路径已存在")
+	}
+	return os.Remove(p)
+}
+
+// QueryVolumes 查询数据卷及其大小, appName为空时查询全部应用
+func (a *appRunMgr) QueryVolumes(appName string) ([]*vos.AppVolumeInfo, error) {
+	volumeRoot, err := volumeRootDir()
+	if err != nil {
+		return nil, err
+	}
+
+	appNames := []string{appName}
+	if appName == "" {
+		appNames = nil
+		fileInfos, err := ioutil.ReadDir(volumeRoot)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, errors.New("读取数据卷目录失败")
+		}
+
+		for _, f := range fileInfos {
+			if f.IsDir() {
+				appNames = append(appNames, f.Name())
+			}
+		}
+	}
+
+	result := make([]*vos.AppVolumeInfo, 0)
+	for _, name := range appNames {
+		fileInfos, err := ioutil.ReadDir(filepath.Join(volumeRoot, name))
+		if err != nil {
+			continue
+		}
+
+		for _, f := range fileInfos {
+			if !f.IsDir() {
+				continue
+			}
+
+			volumeDir := filepath.Join(volumeRoot, name, f.Name())
+			result = append(result, &vos.AppVolumeInfo{
+				AppName: name,
+				Name:    f.Name(),
+				Dir:     volumeDir,
+				Size:    dirSize(volumeDir),
+				InUse:   a.volumeInUse(name, f.Name()),
+			})
+		}
+	}
+	return result, nil
+}
+
+// RemoveVolume 删除数据卷, 正在被使用的数据卷不能删除
+func (a *appRunMgr) RemoveVolume(appName, volumeName string) error {
+	if err := checkVolumeName(volumeName); err != nil {
+		return err
+	}
+
+	if err := checkVolumeName(appName); err != nil {
+		return errors.New("应用名称不合法")
+	}
+
+	volumeRoot, err := volumeRootDir()
+	if err != nil {
+		return err
+	}
+
+	volumeDir := filepath.Join(volumeRoot, appName, volumeName)
+	if _, err = os.Stat(volumeDir); err != nil {
+		return errors.New("未找到对应的数据卷")
+	}
+
+	if a.volumeInUse(appName, volumeName) {
+		return errors.New("数据卷正在被运行中的应用使用, 请先停止应用")
+	}
+
+	if err = os.RemoveAll(volumeDir); err != nil {
+		return errors.New("删除数据卷失败")
+	}
+	_ = os.Remove(filepath.Join(volumeRoot, appName))
+	return nil
+}
+
+// volumeInUse 数据卷是否被运行中或升级中的应用使用
+func (a *appRunMgr) volumeInUse(appName, volumeName string) bool {
+	a.Lock()
+	defer a.Unlock()
+	for _, statusMap := range []map[string]*AppStatusInfo{a.startAppMap, a.upgradeAppMap} {
+		statusInfo, ok := statusMap[appName]
+		if !ok {
+			continue
+		}
+
+		for _, v := range statusInfo.StartArgs.Volumes {
+			if v.Name == volumeName {
+				return true
+			}
+		}
+	}
+
+	if job, ok := a.jobMap[appName]; ok {
+		for _, v := range job.startInfo.Volumes {
+			if v.Name == volumeName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// volumeRootDir 获取数据卷存储目录
+func volumeRootDir() (string, error) {
+	setting := &vos.DbSetting{}
+	if err := db.GetDb().Model(&vos.DbSetting{}).Where(&vos.DbSetting{
+		Name: consts.DbSettingVolumeDir,
+	}).First(&setting).Error; err != nil || setting.Val == "" {
+		return "", errors.New("获取数据卷目录失败")
+	}
+	return setting.Val, nil
+}
+
+// dirSize 统计目录下所有文件的大小
+func dirSize(dir string) int64 {
+	var size int64
+	_ = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
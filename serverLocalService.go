@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"github.com/byzk-org/bypt-server/db"
+	"github.com/byzk-org/bypt-server/helper"
 	"github.com/byzk-org/bypt-server/logs"
 	"github.com/byzk-org/bypt-server/socket"
 	_ "github.com/byzk-org/bypt-server/socket"
@@ -72,6 +73,16 @@ func (p *program) Stop(s service.Service) error {
 
 func main() {
 	defer func() { recover() }()
+	if helper.IsSandboxInit() {
+		helper.RunSandboxInit()
+		return
+	}
+
+	if helper.IsLogRelay() {
+		helper.RunLogRelay()
+		return
+	}
+
 	isUserStart := false
 	args := os.Args
 	for _, arg := range args {
@@ -131,6 +131,10 @@ var appListByAppNameAndVersion ServiceInterfaceFn = func(socketOperation *Socket
 		if len(plugin.EnvConfigBytes) > 0 {
 			_ = json.Unmarshal(plugin.EnvConfigBytes, &plugin.EnvConfig)
 		}
+
+		if len(plugin.RunPolicyBytes) > 0 {
+			_ = json.Unmarshal(plugin.RunPolicyBytes, &plugin.RunPolicy)
+		}
 		appVersion.PluginInfo = append(appVersion.PluginInfo, plugin)
 	}
 
@@ -0,0 +1,50 @@
+package services
+
+import (
+	"github.com/byzk-org/bypt-server/helper"
+)
+
+var attachService ServiceInterfaceFn = func(socketOperation *SocketOperation) error {
+	appName, err := socketOperation.ReadMsg()
+	if err != nil {
+		return err
+	}
+
+	mode, err := socketOperation.ReadMsg()
+	if err != nil {
+		return err
+	}
+
+	attach, err := helper.AppStatusMgr.AttachApp(appName.String(), mode.String() == "rw")
+	if err != nil {
+		return err
+	}
+	defer attach.Close()
+
+	inputErrChan := make(chan error, 1)
+	go func() {
+		for {
+			msg, err := socketOperation.ReadMsg()
+			if err == nil {
+				err = attach.Write(msg)
+			}
+
+			if err != nil {
+				inputErrChan <- err
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case output := <-attach.Output():
+			socketOperation.SendMsg(output)
+		case <-attach.Exit():
+			socketOperation.SendMsg([]byte("!!!!!!"))
+			return nil
+		case err = <-inputErrChan:
+			return err
+		}
+	}
+}
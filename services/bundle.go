@@ -0,0 +1,101 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/byzk-org/bypt-server/helper"
+	"strconv"
+)
+
+var configBundlePushService ServiceInterfaceFn = func(socketOperation *SocketOperation) error {
+	name, err := socketOperation.ReadMsg()
+	if err != nil {
+		return err
+	}
+
+	desc, err := socketOperation.ReadMsg()
+	if err != nil {
+		return err
+	}
+
+	content, err := socketOperation.ReadMsg()
+	if err != nil {
+		return err
+	}
+
+	bundle, err := helper.SaveConfigBundle(name.String(), desc.String(), content)
+	if err != nil {
+		return err
+	}
+
+	bundle.Content = nil
+	marshal, _ := json.Marshal(bundle)
+	socketOperation.SendMsg(marshal)
+	return nil
+}
+
+var configBundleListService ServiceInterfaceFn = func(socketOperation *SocketOperation) error {
+	name, err := socketOperation.ReadMsg()
+	if err != nil {
+		return err
+	}
+
+	bundles, err := helper.QueryConfigBundles(name.String())
+	if err != nil {
+		return err
+	}
+
+	marshal, _ := json.Marshal(bundles)
+	socketOperation.SendMsg(marshal)
+	return nil
+}
+
+var configBundlePullService ServiceInterfaceFn = func(socketOperation *SocketOperation) error {
+	name, err := socketOperation.ReadMsg()
+	if err != nil {
+		return err
+	}
+
+	revision, err := readBundleRevision(socketOperation)
+	if err != nil {
+		return err
+	}
+
+	bundle, err := helper.GetConfigBundle(name.String(), revision)
+	if err != nil {
+		return err
+	}
+	socketOperation.SendMsg(bundle.Content)
+	return nil
+}
+
+var configBundleRmService ServiceInterfaceFn = func(socketOperation *SocketOperation) error {
+	name, err := socketOperation.ReadMsg()
+	if err != nil {
+		return err
+	}
+
+	revision, err := readBundleRevision(socketOperation)
+	if err != nil {
+		return err
+	}
+	return helper.AppStatusMgr.RemoveConfigBundle(name.String(), revision)
+}
+
+// readBundleRevision 读取配置包版本, 为空时返回0
+func readBundleRevision(socketOperation *SocketOperation) (int, error) {
+	msg, err := socketOperation.ReadMsg()
+	if err != nil {
+		return 0, err
+	}
+
+	if len(msg) == 0 {
+		return 0, nil
+	}
+
+	revision, err := strconv.Atoi(msg.String())
+	if err != nil || revision < 0 {
+		return 0, errors.New("非法的配置包版本")
+	}
+	return revision, nil
+}
@@ -51,6 +51,12 @@ var configService ServiceInterfaceFn = func(socketOperation *SocketOperation) er
 		return logs.SetTimeSpaceUnit(unit)
 	}
 
+	if key.String() == consts.DbSettingPortRange {
+		if _, _, err = helper.ParsePortRange(valStr); err != nil {
+			return err
+		}
+	}
+
 	return db.GetDb().Transaction(func(tx *gorm.DB) error {
 		helper.AppStatusMgr.Lock()
 		defer helper.AppStatusMgr.Unlock()
@@ -74,10 +80,17 @@ var configService ServiceInterfaceFn = func(socketOperation *SocketOperation) er
 		}
 
 		switch srcSetting.Name {
+		case consts.DbSettingPluginLibDir:
+			if err = movePluginLibPaths(tx, srcSetting.Val, valStr); err != nil {
+				return err
+			}
+			fallthrough
 		case consts.DbSettingLogDir:
 			fallthrough
 		case consts.DbSettingJdkSaveDir:
 			fallthrough
+		case consts.DbSettingVolumeDir:
+			fallthrough
 		case consts.DbSettingAppSaveDir:
 			_ = os.MkdirAll(srcSetting.Val, 0777)
 			if err = os.Rename(srcSetting.Val, valStr); err != nil {
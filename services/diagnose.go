@@ -0,0 +1,53 @@
+package services
+
+import (
+	"github.com/byzk-org/bypt-server/helper"
+	"strings"
+)
+
+var diagThreadDumpService ServiceInterfaceFn = func(socketOperation *SocketOperation) error {
+	appName, err := socketOperation.ReadMsg()
+	if err != nil {
+		return err
+	}
+
+	output, err := helper.AppStatusMgr.ThreadDump(appName.String())
+	if err != nil {
+		return err
+	}
+	socketOperation.SendMsg(output)
+	return nil
+}
+
+var diagHeapDumpService ServiceInterfaceFn = func(socketOperation *SocketOperation) error {
+	appName, err := socketOperation.ReadMsg()
+	if err != nil {
+		return err
+	}
+
+	dumpFile, err := helper.AppStatusMgr.HeapDump(appName.String())
+	if err != nil {
+		return err
+	}
+	socketOperation.SendMsg([]byte(dumpFile))
+	return nil
+}
+
+var diagJcmdService ServiceInterfaceFn = func(socketOperation *SocketOperation) error {
+	appName, err := socketOperation.ReadMsg()
+	if err != nil {
+		return err
+	}
+
+	cmdLine, err := socketOperation.ReadMsg()
+	if err != nil {
+		return err
+	}
+
+	output, err := helper.AppStatusMgr.Jcmd(appName.String(), strings.Fields(cmdLine.String()))
+	if err != nil {
+		return err
+	}
+	socketOperation.SendMsg(output)
+	return nil
+}
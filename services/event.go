@@ -0,0 +1,58 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/byzk-org/bypt-server/helper"
+	"time"
+)
+
+// eventQueryLimit 事件查询返回的最大数量
+const eventQueryLimit = 500
+
+// eventTimeLayout 事件查询的时间格式
+const eventTimeLayout = "2006-01-02 15:04:05"
+
+var eventListService ServiceInterfaceFn = func(socketOperation *SocketOperation) error {
+	appName, err := socketOperation.ReadMsg()
+	if err != nil {
+		return err
+	}
+
+	startTime, err := readEventTime(socketOperation)
+	if err != nil {
+		return err
+	}
+
+	endTime, err := readEventTime(socketOperation)
+	if err != nil {
+		return err
+	}
+
+	events, err := helper.QueryAppEvents(appName.String(), startTime, endTime, eventQueryLimit)
+	if err != nil {
+		return errors.New("查询应用事件失败")
+	}
+
+	marshal, _ := json.Marshal(events)
+	socketOperation.SendMsg(marshal)
+	return nil
+}
+
+// readEventTime 读取查询时间, 为空时不限制
+func readEventTime(socketOperation *SocketOperation) (time.Time, error) {
+	msg, err := socketOperation.ReadMsg()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if len(msg) == 0 {
+		return time.Time{}, nil
+	}
+
+	t, err := time.ParseInLocation(eventTimeLayout, msg.String(), time.Local)
+	if err != nil {
+		return time.Time{}, errors.New("时间格式错误, 格式为: " + eventTimeLayout)
+	}
+	return t, nil
+}
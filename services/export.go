@@ -63,6 +63,46 @@ var exportService ServiceInterfaceFn = func(socketOperation *SocketOperation) er
 			_ = json.Unmarshal(d.CopyFileBytes, &d.CopyFiles)
 		}
 
+		if len(d.RunGroupsBytes) > 0 {
+			_ = json.Unmarshal(d.RunGroupsBytes, &d.RunGroups)
+		}
+
+		if len(d.SandboxBytes) > 0 {
+			_ = json.Unmarshal(d.SandboxBytes, &d.Sandbox)
+		}
+
+		if len(d.PortsBytes) > 0 {
+			_ = json.Unmarshal(d.PortsBytes, &d.Ports)
+		}
+
+		if len(d.VolumesBytes) > 0 {
+			_ = json.Unmarshal(d.VolumesBytes, &d.Volumes)
+		}
+
+		if len(d.ConfigBundlesBytes) > 0 {
+			_ = json.Unmarshal(d.ConfigBundlesBytes, &d.ConfigBundles)
+		}
+
+		if len(d.JobBytes) > 0 {
+			_ = json.Unmarshal(d.JobBytes, &d.Job)
+		}
+
+		if len(d.ReadinessBytes) > 0 {
+			_ = json.Unmarshal(d.ReadinessBytes, &d.Readiness)
+		}
+
+		if len(d.JvmOptionsBytes) > 0 {
+			_ = json.Unmarshal(d.JvmOptionsBytes, &d.JvmOptions)
+		}
+
+		if len(d.PluginRunPolicyBytes) > 0 {
+			_ = json.Unmarshal(d.PluginRunPolicyBytes, &d.PluginRunPolicy)
+		}
+
+		if len(d.PluginSelectionBytes) > 0 {
+			_ = json.Unmarshal(d.PluginSelectionBytes, &d.PluginSelection)
+		}
+
 		endData[d.Name] = d
 	}
 
@@ -14,13 +14,13 @@ import (
 	"github.com/byzk-org/bypt-server/utils"
 	"github.com/byzk-org/bypt-server/vos"
 	"github.com/jinzhu/gorm"
-	"github.com/tjfoc/gmsm/sm2"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -184,6 +184,10 @@ var importService ServiceInterfaceFn = func(socketOperation *SocketOperation) er
 		if err = installPlugin(contentFile, appInfo, appVersion); err != nil {
 			return err
 		}
+	case "install-plugin-lib":
+		if err = installPluginLib(contentFile); err != nil {
+			return err
+		}
 	case "install-jdk":
 		if err = installJdk(contentFile, appInfo); err != nil {
 			return err
@@ -350,16 +354,7 @@ func installPlugin(contentFile *os.File, appInfo *vos.DbAppInfo, appVersionInfo
 			return errors.New("数据校验失败, 数据可能已被篡改")
 		}
 
-		saveDirSetting := &vos.DbSetting{}
-		if err := tx.Where(&vos.DbSetting{
-			Name: consts.DbSettingAppSaveDir,
-		}).First(&saveDirSetting).Error; err != nil {
-			return errors.New("查询保存目录失败")
-		}
-
-		saveDir := filepath.Join(saveDirSetting.Val, srcAppInfo.Name, srcAppVersionInfo.Name)
-
-		plugins, err := parsePlugin(contentFile, saveDir, appInfo.Name, appVersionInfo.Name, consts.CaPubKey)
+		plugins, err := parsePlugin(tx, contentFile, appInfo.Name, appVersionInfo.Name)
 		if err != nil {
 			return err
 		}
@@ -414,6 +409,16 @@ func installPlugin(contentFile *os.File, appInfo *vos.DbAppInfo, appVersionInfo
 	//parsePlugin(contentFile)
 }
 
+// installPluginLib 仅将插件导入到插件库, 不关联应用版本
+func installPluginLib(contentFile *os.File) error {
+	GlobalOperationLock.Lock()
+	defer GlobalOperationLock.Unlock()
+	return db.GetDb().Transaction(func(tx *gorm.DB) error {
+		_, err := parsePlugin(tx, contentFile, "", "")
+		return err
+	})
+}
+
 // installAppPack 安装应用包
 func installAppPack(contentFile *os.File, appInfo *vos.DbAppInfo, appVersionInfo *vos.DbAppVersionInfo) error {
 	GlobalOperationLock.Lock()
@@ -469,31 +474,33 @@ func installAppPack(contentFile *os.File, appInfo *vos.DbAppInfo, appVersionInfo
 			AppName: appInfo.Name,
 		})
 
-		hexJarPassBytes, err = nextInfo(contentFile)
-		if err != nil {
-			return err
-		}
+		isCmd := appVersionInfo.ExecType == vos.AppExecTypeCmd
+		if !isCmd {
+			hexJarPassBytes, err = nextInfo(contentFile)
+			if err != nil {
+				return err
+			}
 
-		jarPassJsonBytes, err = base64.StdEncoding.DecodeString(string(hexJarPassBytes))
-		if err != nil {
-			return errors.New("获取运行密钥失败")
-		}
+			jarPassJsonBytes, err = base64.StdEncoding.DecodeString(string(hexJarPassBytes))
+			if err != nil {
+				return errors.New("获取运行密钥失败")
+			}
 
-		jarPassEnc, err = utils.Sm2Encrypt(consts.CaPubKey, jarPassJsonBytes)
-		if err != nil {
-			return errors.New("加密运行密钥失败")
-		}
+			jarPassEnc, err = utils.Sm2Encrypt(consts.CaPubKey, jarPassJsonBytes)
+			if err != nil {
+				return errors.New("加密运行密钥失败")
+			}
 
-		appVersionInfo.JarPass = jarPassEnc
+			appVersionInfo.JarPass = jarPassEnc
+		}
 
 		cmdByte, err = nextInfo(contentFile)
 		if err != nil {
 			return errors.New("获取包命令失败")
 		}
 
-		cmdStr := string(cmdByte)
-		if cmdStr != "jar" {
-			return errors.New("获取运行程序失败")
+		if err = checkPackCmd(string(cmdByte), appVersionInfo); err != nil {
+			return err
 		}
 
 		contentTmpFilePath := filepath.Join(tmpDir, "dc")
@@ -539,7 +546,7 @@ func installAppPack(contentFile *os.File, appInfo *vos.DbAppInfo, appVersionInfo
 			encryptContentFilePath,
 		}, nil)
 
-		plugins, err = parsePlugin(contentFile, contentFileDir, appInfo.Name, appVersionInfo.Name, consts.CaPubKey)
+		plugins, err = parsePlugin(tx, contentFile, appInfo.Name, appVersionInfo.Name)
 		if err != nil {
 			return err
 		}
@@ -575,7 +582,7 @@ func installAppPack(contentFile *os.File, appInfo *vos.DbAppInfo, appVersionInfo
 			}
 		}
 
-		if len(appVersionInfo.JdkStartArgs) == 0 {
+		if len(appVersionInfo.JdkStartArgs) == 0 && !isCmd {
 			appVersionInfo.JdkStartArgs = []string{"-jar"}
 		}
 
@@ -614,6 +621,34 @@ func installAppPack(contentFile *os.File, appInfo *vos.DbAppInfo, appVersionInfo
 
 }
 
+// checkPackCmd 校验包命令与程序执行方式是否匹配
+// jar: 加密的jar程序; cmd: 单个可执行文件; archive: tar.gz压缩包, 通过入口文件运行
+func checkPackCmd(cmdStr string, appVersionInfo *vos.DbAppVersionInfo) error {
+	switch appVersionInfo.ExecType {
+	case vos.AppExecTypePlugin:
+		if cmdStr != "jar" {
+			return errors.New("获取运行程序失败")
+		}
+		appVersionInfo.Entrypoint = ""
+	case vos.AppExecTypeCmd:
+		switch cmdStr {
+		case "cmd":
+			appVersionInfo.Entrypoint = ""
+		case "archive":
+			entrypoint := filepath.Clean(appVersionInfo.Entrypoint)
+			if appVersionInfo.Entrypoint == "" || filepath.IsAbs(entrypoint) || entrypoint == ".." || strings.HasPrefix(entrypoint, ".."+string(filepath.Separator)) {
+				return errors.New("压缩包程序的入口文件不正确")
+			}
+			appVersionInfo.Entrypoint = filepath.ToSlash(entrypoint)
+		default:
+			return errors.New("获取运行程序失败")
+		}
+	default:
+		return errors.New("未识别的程序执行方式")
+	}
+	return nil
+}
+
 // nextInfo 读取下一段插件
 func nextInfo(contentFile *os.File) ([]byte, error) {
 
@@ -733,9 +768,8 @@ func decryptFrame2File(contentFile *os.File, destFile string) error {
 	return nil
 }
 
-// parsePlugin  转换插件
-func parsePlugin(contentFile *os.File, pluginSaveDir, appName, appVersionName string, pubKey *sm2.PublicKey) ([]*vos.DbAppPlugin, error) {
-	_ = os.MkdirAll(pluginSaveDir, 0777)
+// parsePlugin  转换插件, 插件文件保存到插件库中
+func parsePlugin(tx *gorm.DB, contentFile *os.File, appName, appVersionName string) ([]*vos.DbAppPlugin, error) {
 	var (
 		allPlugins = make([]*vos.DbAppPlugin, 0)
 		err        error
@@ -766,7 +800,7 @@ func parsePlugin(contentFile *os.File, pluginSaveDir, appName, appVersionName st
 			return nil, err
 		}
 
-		plugin, err = handlerPluginFile(appName, appVersionName, tmpFile, pluginSaveDir, pubKey)
+		plugin, err = handlerPluginFile(tx, appName, appVersionName, tmpFile)
 		if err != nil {
 			return nil, err
 		}
@@ -775,17 +809,14 @@ func parsePlugin(contentFile *os.File, pluginSaveDir, appName, appVersionName st
 	}
 }
 
-// handlerPluginFile 处理插件文件
-func handlerPluginFile(appName, appVersionName, pluginFilePath, pluginSaveDir string, pubKey *sm2.PublicKey) (*vos.DbAppPlugin, error) {
+// handlerPluginFile 处理插件文件, 插件库中已存在相同的插件时直接引用
+func handlerPluginFile(tx *gorm.DB, appName, appVersionName, pluginFilePath string) (*vos.DbAppPlugin, error) {
 	dir, err := utils.TmpDir()
 	if err != nil {
 		return nil, errors.New("创建程序临时运行目录失败")
 	}
 	defer os.RemoveAll(dir)
 
-	saveDir := filepath.Join(pluginSaveDir, "p")
-	_ = os.MkdirAll(saveDir, 0777)
-
 	file, err := os.OpenFile(pluginFilePath, os.O_RDONLY, 0666)
 	if err != nil {
 		return nil, errors.New("打开插件文件失败")
@@ -859,6 +890,9 @@ func handlerPluginFile(appName, appVersionName, pluginFilePath, pluginSaveDir st
 	case vos.AppPluginTypeNormal:
 	case vos.AppPluginTypeBefore:
 	case vos.AppPluginTypeAfter:
+	case vos.AppPluginTypePreStop:
+	case vos.AppPluginTypeStop:
+	case vos.AppPluginTypePostStop:
 	default:
 		return nil, errors.New("未被支持的插件类型")
 	}
@@ -886,45 +920,49 @@ func handlerPluginFile(appName, appVersionName, pluginFilePath, pluginSaveDir st
 		return nil, errors.New("获取插件sha1摘要失败")
 	}
 
-	md5Str := hex.EncodeToString(md5Sum)
-	sha1Str := hex.EncodeToString(sha1Sum)
-	saveFilePath := filepath.Join(saveDir, md5Str+sha1Str)
+	var envConfigBytes []byte = nil
 
-	key := utils.Sm4RandomKey()
-	if err = utils.Sm4Encrypt2File(key, tmpRunFilePath, saveFilePath); err != nil {
-		return nil, err
+	if len(plugin.EnvConfig) > 0 {
+		envConfigBytes, _ = json.Marshal(plugin.EnvConfig)
 	}
 
-	encryptFilePath, err := utils.Sm4Encrypt(key, []byte(saveFilePath))
-	if err != nil {
-		return nil, errors.New("插件路径格式转换失败")
-	}
+	var runPolicyBytes []byte = nil
+	if plugin.RunPolicy != nil {
+		if plugin.Type != vos.AppPluginTypeBefore && plugin.Type != vos.AppPluginTypeAfter {
+			return nil, errors.New("仅before/after插件支持设置超时时间及失败策略")
+		}
 
-	encryptKey, err := utils.Sm2Encrypt(pubKey, key)
-	if err != nil {
-		return nil, errors.New("生成数据保护密钥失败")
+		if err = helper.CheckPluginRunPolicy(plugin.RunPolicy); err != nil {
+			return nil, err
+		}
+		runPolicyBytes, _ = json.Marshal(plugin.RunPolicy)
 	}
 
-	var envConfigBytes []byte = nil
-
-	if len(plugin.EnvConfig) > 0 {
-		envConfigBytes, _ = json.Marshal(plugin.EnvConfig)
+	pluginLib, err := savePluginLib(tx, tmpRunFilePath, &vos.DbPluginLib{
+		Desc:           pluginDesc,
+		Type:           plugin.Type,
+		Md5:            md5Sum,
+		Sha1:           sha1Sum,
+		EnvConfigBytes: envConfigBytes,
+		RunPolicyBytes: runPolicyBytes,
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	endData := &vos.DbAppPlugin{
-		AppName:    appName,
-		AppVersion: appVersionName,
-		Name:       hex.EncodeToString(md5Sum) + hex.EncodeToString(sha1Sum),
-		Desc:       pluginDesc,
-		Content: bytes.Join([][]byte{
-			encryptKey,
-			encryptFilePath,
-		}, nil),
+		AppName:        appName,
+		AppVersion:     appVersionName,
+		Name:           pluginLib.Name,
+		Desc:           pluginDesc,
+		Content:        pluginLib.Content,
 		Md5:            md5Sum,
 		Sha1:           sha1Sum,
 		Type:           plugin.Type,
 		EnvConfig:      plugin.EnvConfig,
 		EnvConfigBytes: envConfigBytes,
+		RunPolicy:      plugin.RunPolicy,
+		RunPolicyBytes: runPolicyBytes,
 	}
 
 	sign, err := utils.PrivateKeySign(consts.CaPrivateKey, endData.Src())
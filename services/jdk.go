@@ -5,6 +5,7 @@ import (
 	"errors"
 	"github.com/byzk-org/bypt-server/consts"
 	"github.com/byzk-org/bypt-server/db"
+	"github.com/byzk-org/bypt-server/helper"
 	"github.com/byzk-org/bypt-server/utils"
 	"github.com/byzk-org/bypt-server/vos"
 	"github.com/jinzhu/gorm"
@@ -90,7 +91,7 @@ var jdkRmAll ServiceInterfaceFn = func(socketOperation *SocketOperation) error {
 		if err := os.RemoveAll(jdkSavePath.Val); err != nil {
 			return errors.New("删除jdk文件失败")
 		}
-		return nil
+		return helper.ClearJdkCache()
 	})
 }
 
@@ -131,6 +132,34 @@ var jdkRmName ServiceInterfaceFn = func(socketOperation *SocketOperation) error
 			return errors.New("删除jdk文件失败")
 		}
 
-		return nil
+		return helper.RemoveJdkCache(srcJdkInfo)
 	})
 }
+
+var jdkScanService ServiceInterfaceFn = func(socketOperation *SocketOperation) error {
+	jdks, err := helper.ScanLocalJdks()
+	if err != nil {
+		return err
+	}
+	marshal, _ := json.Marshal(jdks)
+	socketOperation.SendMsg(marshal)
+	return nil
+}
+
+var jdkLocalListService ServiceInterfaceFn = func(socketOperation *SocketOperation) error {
+	jdks, err := helper.QueryLocalJdks()
+	if err != nil {
+		return err
+	}
+	marshal, _ := json.Marshal(jdks)
+	socketOperation.SendMsg(marshal)
+	return nil
+}
+
+var jdkLocalRmService ServiceInterfaceFn = func(socketOperation *SocketOperation) error {
+	name, err := socketOperation.ReadMsg()
+	if err != nil {
+		return err
+	}
+	return helper.RemoveLocalJdk(name.String())
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/byzk-org/bypt-server/db"
+	"github.com/byzk-org/bypt-server/helper"
+	"github.com/byzk-org/bypt-server/vos"
+	"github.com/jinzhu/gorm"
+)
+
+// jobHistoryLimit 查询任务运行记录的最大条数
+const jobHistoryLimit = 100
+
+var jobListService ServiceInterfaceFn = func(socketOperation *SocketOperation) error {
+	socketOperation.SendMsg(helper.AppStatusMgr.JobList())
+	return nil
+}
+
+var jobTriggerService ServiceInterfaceFn = func(socketOperation *SocketOperation) error {
+	appName, err := socketOperation.ReadMsg()
+	if err != nil {
+		return err
+	}
+	return helper.AppStatusMgr.TriggerJob(appName.String())
+}
+
+var jobHistoryService ServiceInterfaceFn = func(socketOperation *SocketOperation) error {
+	appName, err := socketOperation.ReadMsg()
+	if err != nil {
+		return err
+	}
+
+	records := make([]*vos.DbAppJobRecord, 0)
+	if err = db.GetDb().Model(&vos.DbAppJobRecord{}).Where(&vos.DbAppJobRecord{
+		AppName: appName.String(),
+	}).Order("start_time desc").Limit(jobHistoryLimit).Find(&records).Error; err != nil && err != gorm.ErrRecordNotFound {
+		return errors.New("查询任务运行记录失败")
+	}
+
+	marshal, _ := json.Marshal(records)
+	socketOperation.SendMsg(marshal)
+	return nil
+}
@@ -35,6 +35,7 @@ var (
 		"psList":                     psListService,
 		"psApp":                      psAppNameService,
 		"psAppPlugin":                psAppNamePluginService,
+		"psAppPluginFollow":          psAppPluginFollowService,
 		"rmAll":                      rmAllService,
 		"rmByName":                   rmAppByNameService,
 		"rmByNameAndVersion":         rmAppByNameAndVersionService,
@@ -49,8 +50,54 @@ var (
 		"jdkRm":                      jdkRmName,
 		"jdkRmAll":                   jdkRmAll,
 		"jdkRename":                  jdkRename,
+		"jdkScan":                    jdkScanService,
+		"jdkLocalLs":                 jdkLocalListService,
+		"jdkLocalRm":                 jdkLocalRmService,
 		"infoBanner":                 infoBannerService,
 		"infoLogClear":               infoClearLogService,
 		"export":                     exportService,
+		"upgrade":                    upgradeService,
+		"upgradeList":                upgradeListService,
+		"jobList":                    jobListService,
+		"jobTrigger":                 jobTriggerService,
+		"jobHistory":                 jobHistoryService,
+		"secretSet":                  secretSetService,
+		"secretList":                 secretListService,
+		"secretRm":                   secretRmService,
+		"attach":                     attachService,
+		"diagThreadDump":             diagThreadDumpService,
+		"diagHeapDump":               diagHeapDumpService,
+		"diagJcmd":                   diagJcmdService,
+		"events":                     eventListService,
+		"notifySet":                  notifySetService,
+		"notifyList":                 notifyListService,
+		"notifyRm":                   notifyRmService,
+		"notifyTest":                 notifyTestService,
+		"volumeList":                 volumeListService,
+		"volumeRm":                   volumeRmService,
+		"configBundlePush":           configBundlePushService,
+		"configBundleList":           configBundleListService,
+		"configBundlePull":           configBundlePullService,
+		"configBundleRm":             configBundleRmService,
+		"pluginLibLs":                pluginLibListService,
+		"pluginLibAttach":            pluginLibAttachService,
+		"pluginLibDetach":            pluginLibDetachService,
+		"pluginLibRm":                pluginLibRmService,
+	}
+
+	// AsyncServiceMap 长时间运行的命令, 在独立的协程中执行, 不阻塞其他命令
+	// start、startWithConfig 可能需要等待应用就绪, upgrade 需要等待目标版本就绪
+	AsyncServiceMap = map[string]bool{
+		"start":             true,
+		"startWithConfig":   true,
+		"upgrade":           true,
+		"attach":            true,
+		"psAppPluginFollow": true,
+		"diagThreadDump":    true,
+		"diagHeapDump":      true,
+		"diagJcmd":          true,
+		"notifyTest":        true,
+		"volumeList":        true,
+		"jdkScan":           true,
 	}
 )
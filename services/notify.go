@@ -0,0 +1,68 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/byzk-org/bypt-server/db"
+	"github.com/byzk-org/bypt-server/helper"
+	"github.com/byzk-org/bypt-server/vos"
+)
+
+var notifySetService ServiceInterfaceFn = func(socketOperation *SocketOperation) error {
+	msg, err := socketOperation.ReadMsg()
+	if err != nil {
+		return err
+	}
+
+	rule := &vos.DbNotifyRule{}
+	if err = json.Unmarshal(msg, rule); err != nil {
+		return errors.New("转换通知规则失败")
+	}
+	return helper.SaveNotifyRule(rule)
+}
+
+var notifyListService ServiceInterfaceFn = func(socketOperation *SocketOperation) error {
+	rules, err := helper.QueryNotifyRules()
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range rules {
+		if rule.Smtp != nil && rule.Smtp.Password != "" {
+			rule.Smtp.Password = "******"
+		}
+	}
+
+	marshal, _ := json.Marshal(rules)
+	socketOperation.SendMsg(marshal)
+	return nil
+}
+
+var notifyRmService ServiceInterfaceFn = func(socketOperation *SocketOperation) error {
+	name, err := socketOperation.ReadMsg()
+	if err != nil {
+		return err
+	}
+
+	ruleWhere := db.GetDb().Model(&vos.DbNotifyRule{}).Where(&vos.DbNotifyRule{
+		Name: name.String(),
+	})
+
+	count := 0
+	if err = ruleWhere.Count(&count).Error; err != nil || count == 0 {
+		return errors.New("未找到对应的通知规则")
+	}
+
+	if err = ruleWhere.Delete(&vos.DbNotifyRule{}).Error; err != nil {
+		return errors.New("删除通知规则失败")
+	}
+	return nil
+}
+
+var notifyTestService ServiceInterfaceFn = func(socketOperation *SocketOperation) error {
+	name, err := socketOperation.ReadMsg()
+	if err != nil {
+		return err
+	}
+	return helper.TestNotifyRule(name.String())
+}
@@ -0,0 +1,443 @@
+package services
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/byzk-org/bypt-server/consts"
+	"github.com/byzk-org/bypt-server/db"
+	"github.com/byzk-org/bypt-server/helper"
+	"github.com/byzk-org/bypt-server/utils"
+	"github.com/byzk-org/bypt-server/vos"
+	"github.com/jinzhu/gorm"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// savePluginLib 将插件文件加密保存到插件库, 插件库中已存在相同的插件时直接复用
+func savePluginLib(tx *gorm.DB, runFilePath string, pluginLib *vos.DbPluginLib) (*vos.DbPluginLib, error) {
+	pluginLib.Name = hex.EncodeToString(pluginLib.Md5) + hex.EncodeToString(pluginLib.Sha1)
+
+	srcPluginLib := &vos.DbPluginLib{}
+	err := tx.Where(&vos.DbPluginLib{
+		Name: pluginLib.Name,
+	}).First(&srcPluginLib).Error
+	switch err {
+	case nil:
+		if !utils.PubKeyVerifySign(consts.CaPubKey, srcPluginLib.SignSrc(), srcPluginLib.Sign) {
+			return nil, errors.New("插件库中的插件 [" + pluginLib.Name + "] 已被篡改, 请删除后重新导入")
+		}
+
+		p, key, err := utils.Sm4DecryptContentPath(consts.CaPrivateKey, srcPluginLib.Content)
+		if err != nil {
+			return nil, errors.New("获取插件库中的插件路径失败")
+		}
+
+		if _, err = os.Stat(p); err == nil {
+			return srcPluginLib, nil
+		}
+
+		// 插件文件已丢失, 使用原密钥及路径重新保存, 已有的引用保持有效
+		_ = os.MkdirAll(filepath.Dir(p), 0777)
+		if err = utils.Sm4Encrypt2File(key, runFilePath, p); err != nil {
+			return nil, err
+		}
+		return srcPluginLib, nil
+	case gorm.ErrRecordNotFound:
+	default:
+		return nil, errors.New("查询插件库失败")
+	}
+
+	libDirSetting := &vos.DbSetting{}
+	if err = tx.Where(&vos.DbSetting{
+		Name: consts.DbSettingPluginLibDir,
+	}).First(&libDirSetting).Error; err != nil {
+		return nil, errors.New("查询插件库存储目录失败")
+	}
+
+	if err = os.MkdirAll(libDirSetting.Val, 0777); err != nil {
+		return nil, errors.New("创建插件库存储目录失败")
+	}
+
+	saveFilePath := filepath.Join(libDirSetting.Val, pluginLib.Name)
+	key := utils.Sm4RandomKey()
+	if err = utils.Sm4Encrypt2File(key, runFilePath, saveFilePath); err != nil {
+		return nil, err
+	}
+
+	encryptFilePath, err := utils.Sm4Encrypt(key, []byte(saveFilePath))
+	if err != nil {
+		return nil, errors.New("插件路径格式转换失败")
+	}
+
+	encryptKey, err := utils.Sm2Encrypt(consts.CaPubKey, key)
+	if err != nil {
+		return nil, errors.New("生成数据保护密钥失败")
+	}
+
+	pluginLib.Content = bytes.Join([][]byte{
+		encryptKey,
+		encryptFilePath,
+	}, nil)
+	pluginLib.CreateTime = time.Now()
+
+	sign, err := utils.PrivateKeySign(consts.CaPrivateKey, pluginLib.SignSrc())
+	if err != nil {
+		return nil, errors.New("插件库签名失败")
+	}
+	pluginLib.Sign = sign
+
+	if err = tx.Create(&pluginLib).Error; err != nil {
+		return nil, errors.New("保存插件库信息失败")
+	}
+	return pluginLib, nil
+}
+
+// pluginRefIndex 插件在应用版本插件列表中的位置, 不存在时返回-1
+func pluginRefIndex(plugins, md5Sum, sha1Sum []byte) int {
+	blockSize := md5.Size + sha1.Size
+	ref := append(append(make([]byte, 0, blockSize), md5Sum...), sha1Sum...)
+	for i := 0; i+blockSize <= len(plugins); i += blockSize {
+		if bytes.Equal(plugins[i:i+blockSize], ref) {
+			return i
+		}
+	}
+	return -1
+}
+
+// updatePluginRefs 更新应用版本的插件列表并重新签名
+func updatePluginRefs(tx *gorm.DB, appVersionInfo *vos.DbAppVersionInfo, plugins []byte) error {
+	appVersionInfo.Plugins = plugins
+	appVersionInfo.EndUpdateTime = time.Now()
+	sign, err := utils.PrivateKeySign(consts.CaPrivateKey, appVersionInfo.SignSrc())
+	if err != nil {
+		return errors.New("生成数据签名失败")
+	}
+	appVersionInfo.Sign = sign
+
+	// 插件列表可能为空, 使用map更新避免零值被忽略
+	if err = tx.Model(&vos.DbAppVersionInfo{}).Where(&vos.DbAppVersionInfo{
+		AppName: appVersionInfo.AppName,
+		Name:    appVersionInfo.Name,
+	}).Updates(map[string]interface{}{
+		"plugins":         appVersionInfo.Plugins,
+		"end_update_time": appVersionInfo.EndUpdateTime,
+		"sign":            appVersionInfo.Sign,
+	}).Error; err != nil {
+		return errors.New("更新应用版本信息失败")
+	}
+	return nil
+}
+
+// movePluginLibPaths 插件库目录移动后, 将插件库及引用插件中加密保存的文件路径指向新目录并重新签名
+func movePluginLibPaths(tx *gorm.DB, srcDir, destDir string) error {
+	pluginLibs := make([]*vos.DbPluginLib, 0)
+	if err := tx.Find(&pluginLibs).Error; err != nil && err != gorm.ErrRecordNotFound {
+		return errors.New("查询插件库失败")
+	}
+
+	for _, pluginLib := range pluginLibs {
+		if !utils.PubKeyVerifySign(consts.CaPubKey, pluginLib.SignSrc(), pluginLib.Sign) {
+			return errors.New("插件库中的插件 [" + pluginLib.Name + "] 已被篡改, 请删除后再修改插件库目录")
+		}
+
+		p, key, err := utils.Sm4DecryptContentPath(consts.CaPrivateKey, pluginLib.Content)
+		if err != nil {
+			return errors.New("获取插件库中的插件路径失败")
+		}
+
+		rel, err := filepath.Rel(srcDir, p)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+
+		encryptFilePath, err := utils.Sm4Encrypt(key, []byte(filepath.Join(destDir, rel)))
+		if err != nil {
+			return errors.New("插件路径格式转换失败")
+		}
+
+		srcContent := pluginLib.Content
+		pluginLib.Content = bytes.Join([][]byte{
+			srcContent[:113],
+			encryptFilePath,
+		}, nil)
+		if pluginLib.Sign, err = utils.PrivateKeySign(consts.CaPrivateKey, pluginLib.SignSrc()); err != nil {
+			return errors.New("插件库签名失败")
+		}
+
+		if err = tx.Model(&vos.DbPluginLib{}).Where(&vos.DbPluginLib{
+			Name: pluginLib.Name,
+		}).Updates(&vos.DbPluginLib{
+			Content: pluginLib.Content,
+			Sign:    pluginLib.Sign,
+		}).Error; err != nil {
+			return errors.New("更新插件库信息失败")
+		}
+
+		plugins := make([]*vos.DbAppPlugin, 0)
+		if err = tx.Where(&vos.DbAppPlugin{
+			Md5:  pluginLib.Md5,
+			Sha1: pluginLib.Sha1,
+		}).Find(&plugins).Error; err != nil && err != gorm.ErrRecordNotFound {
+			return errors.New("查询插件使用情况失败")
+		}
+
+		for _, plugin := range plugins {
+			if !bytes.Equal(plugin.Content, srcContent) {
+				continue
+			}
+
+			plugin.Content = pluginLib.Content
+			if plugin.Sign, err = utils.PrivateKeySign(consts.CaPrivateKey, plugin.Src()); err != nil {
+				return errors.New("插件签名失败")
+			}
+
+			if err = tx.Model(&vos.DbAppPlugin{}).Where(&vos.DbAppPlugin{
+				AppName:    plugin.AppName,
+				AppVersion: plugin.AppVersion,
+				Name:       plugin.Name,
+			}).Updates(&vos.DbAppPlugin{
+				Content: plugin.Content,
+				Sign:    plugin.Sign,
+			}).Error; err != nil {
+				return errors.New("更新应用插件信息失败")
+			}
+		}
+	}
+	return nil
+}
+
+// queryPluginVersion 查询并校验需要修改插件的应用版本
+func queryPluginVersion(tx *gorm.DB, appName, appVersion string) (*vos.DbAppVersionInfo, error) {
+	if helper.AppStatusMgr.IsStart(appName) {
+		return nil, errors.New("应用已经启动，请关闭应用后在做尝试")
+	}
+
+	appVersionInfo := &vos.DbAppVersionInfo{}
+	if err := tx.Where(&vos.DbAppVersionInfo{
+		AppName: appName,
+		Name:    appVersion,
+	}).First(&appVersionInfo).Error; err != nil || appVersionInfo.Name == "" {
+		return nil, errors.New("为找到对应应用，请您确认应用已导入")
+	}
+
+	if !utils.PubKeyVerifySign(consts.CaPubKey, appVersionInfo.SignSrc(), appVersionInfo.Sign) {
+		return nil, errors.New("数据校验失败, 数据可能已被篡改")
+	}
+	return appVersionInfo, nil
+}
+
+// pluginLibListService 插件库中的插件及引用插件的应用版本
+var pluginLibListService ServiceInterfaceFn = func(socketOperation *SocketOperation) error {
+	pluginLibs := make([]*vos.DbPluginLib, 0)
+	if err := db.GetDb().Order("create_time").Find(&pluginLibs).Error; err != nil && err != gorm.ErrRecordNotFound {
+		return errors.New("查询插件库失败")
+	}
+
+	plugins := make([]*vos.DbAppPlugin, 0)
+	if err := db.GetDb().Select("app_name, app_version, md5, sha1").Find(&plugins).Error; err != nil && err != gorm.ErrRecordNotFound {
+		return errors.New("查询应用插件失败")
+	}
+
+	usage := make(map[string][]*vos.PluginLibUsage)
+	for _, plugin := range plugins {
+		name := hex.EncodeToString(plugin.Md5) + hex.EncodeToString(plugin.Sha1)
+		usage[name] = append(usage[name], &vos.PluginLibUsage{
+			AppName:    plugin.AppName,
+			AppVersion: plugin.AppVersion,
+		})
+	}
+
+	result := make([]*vos.PluginLibInfo, 0, len(pluginLibs))
+	for _, pluginLib := range pluginLibs {
+		pluginUsage := usage[pluginLib.Name]
+		if pluginUsage == nil {
+			pluginUsage = make([]*vos.PluginLibUsage, 0)
+		}
+		result = append(result, &vos.PluginLibInfo{
+			DbPluginLib: pluginLib,
+			Usage:       pluginUsage,
+		})
+	}
+
+	marshal, _ := json.Marshal(result)
+	socketOperation.SendMsg(marshal)
+	return nil
+}
+
+// pluginLibAttachService 将插件库中的插件关联到应用版本
+var pluginLibAttachService ServiceInterfaceFn = func(socketOperation *SocketOperation) error {
+	appName, err := socketOperation.ReadMsg()
+	if err != nil {
+		return err
+	}
+
+	appVersion, err := socketOperation.ReadMsg()
+	if err != nil {
+		return err
+	}
+
+	libName, err := socketOperation.ReadMsg()
+	if err != nil {
+		return err
+	}
+
+	GlobalOperationLock.Lock()
+	defer GlobalOperationLock.Unlock()
+	return db.GetDb().Transaction(func(tx *gorm.DB) error {
+		appVersionInfo, err := queryPluginVersion(tx, appName.String(), appVersion.String())
+		if err != nil {
+			return err
+		}
+
+		pluginLib := &vos.DbPluginLib{}
+		if err = tx.Where(&vos.DbPluginLib{
+			Name: strings.ToLower(libName.String()),
+		}).First(&pluginLib).Error; err != nil {
+			return errors.New("插件库中未找到插件 [" + libName.String() + "]")
+		}
+
+		if !utils.PubKeyVerifySign(consts.CaPubKey, pluginLib.SignSrc(), pluginLib.Sign) {
+			return errors.New("插件库中的插件 [" + pluginLib.Name + "] 已被篡改, 请删除后重新导入")
+		}
+
+		if pluginRefIndex(appVersionInfo.Plugins, pluginLib.Md5, pluginLib.Sha1) != -1 {
+			return errors.New("应用版本已引用该插件")
+		}
+
+		plugin := &vos.DbAppPlugin{
+			AppName:        appVersionInfo.AppName,
+			AppVersion:     appVersionInfo.Name,
+			Name:           pluginLib.Name,
+			Desc:           pluginLib.Desc,
+			Content:        pluginLib.Content,
+			Md5:            pluginLib.Md5,
+			Sha1:           pluginLib.Sha1,
+			Type:           pluginLib.Type,
+			EnvConfigBytes: pluginLib.EnvConfigBytes,
+			RunPolicyBytes: pluginLib.RunPolicyBytes,
+		}
+		plugin.Sign, err = utils.PrivateKeySign(consts.CaPrivateKey, plugin.Src())
+		if err != nil {
+			return errors.New("插件制作签名失败")
+		}
+
+		if err = tx.Create(&plugin).Error; err != nil {
+			return errors.New("保存插件信息是失败")
+		}
+
+		plugins := append(append(appVersionInfo.Plugins, pluginLib.Md5...), pluginLib.Sha1...)
+		return updatePluginRefs(tx, appVersionInfo, plugins)
+	})
+}
+
+// pluginLibDetachService 解除应用版本对插件的引用, 插件库中的插件保留
+var pluginLibDetachService ServiceInterfaceFn = func(socketOperation *SocketOperation) error {
+	appName, err := socketOperation.ReadMsg()
+	if err != nil {
+		return err
+	}
+
+	appVersion, err := socketOperation.ReadMsg()
+	if err != nil {
+		return err
+	}
+
+	pluginName, err := socketOperation.ReadMsg()
+	if err != nil {
+		return err
+	}
+
+	GlobalOperationLock.Lock()
+	defer GlobalOperationLock.Unlock()
+	return db.GetDb().Transaction(func(tx *gorm.DB) error {
+		appVersionInfo, err := queryPluginVersion(tx, appName.String(), appVersion.String())
+		if err != nil {
+			return err
+		}
+
+		pluginWhere := &vos.DbAppPlugin{
+			AppName:    appVersionInfo.AppName,
+			AppVersion: appVersionInfo.Name,
+			Name:       strings.ToLower(pluginName.String()),
+		}
+		plugin := &vos.DbAppPlugin{}
+		if err = tx.Where(pluginWhere).First(&plugin).Error; err != nil {
+			return errors.New("应用版本未引用插件 [" + pluginName.String() + "]")
+		}
+
+		if err = tx.Where(pluginWhere).Delete(&vos.DbAppPlugin{}).Error; err != nil {
+			return errors.New("删除应用插件失败")
+		}
+
+		index := pluginRefIndex(appVersionInfo.Plugins, plugin.Md5, plugin.Sha1)
+		if index == -1 {
+			return nil
+		}
+
+		plugins := make([]byte, 0, len(appVersionInfo.Plugins))
+		plugins = append(plugins, appVersionInfo.Plugins[:index]...)
+		plugins = append(plugins, appVersionInfo.Plugins[index+md5.Size+sha1.Size:]...)
+		return updatePluginRefs(tx, appVersionInfo, plugins)
+	})
+}
+
+// pluginLibRmService 删除插件库中未被引用的插件
+var pluginLibRmService ServiceInterfaceFn = func(socketOperation *SocketOperation) error {
+	name, err := socketOperation.ReadMsg()
+	if err != nil {
+		return err
+	}
+
+	GlobalOperationLock.Lock()
+	defer GlobalOperationLock.Unlock()
+	return db.GetDb().Transaction(func(tx *gorm.DB) error {
+		libWhere := &vos.DbPluginLib{
+			Name: strings.ToLower(name.String()),
+		}
+		pluginLib := &vos.DbPluginLib{}
+		if err = tx.Where(libWhere).First(&pluginLib).Error; err != nil {
+			return errors.New("插件库中未找到插件 [" + name.String() + "]")
+		}
+
+		plugins := make([]*vos.DbAppPlugin, 0)
+		if err = tx.Where(&vos.DbAppPlugin{
+			Md5:  pluginLib.Md5,
+			Sha1: pluginLib.Sha1,
+		}).Find(&plugins).Error; err != nil && err != gorm.ErrRecordNotFound {
+			return errors.New("查询插件使用情况失败")
+		}
+
+		if len(plugins) > 0 {
+			usage := make([]string, 0, len(plugins))
+			for _, plugin := range plugins {
+				usage = append(usage, plugin.AppName+":"+plugin.AppVersion)
+			}
+			return errors.New("插件正在被应用版本 [" + strings.Join(usage, ", ") + "] 使用, 请解除引用后再删除")
+		}
+
+		if err = tx.Where(libWhere).Delete(&vos.DbPluginLib{}).Error; err != nil {
+			return errors.New("删除插件库信息失败")
+		}
+
+		// 数据被篡改时路径不可信, 仅删除记录
+		if !utils.PubKeyVerifySign(consts.CaPubKey, pluginLib.SignSrc(), pluginLib.Sign) {
+			return nil
+		}
+
+		p, _, err := utils.Sm4DecryptContentPath(consts.CaPrivateKey, pluginLib.Content)
+		if err != nil {
+			return nil
+		}
+
+		if err = os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return errors.New("删除插件文件失败")
+		}
+		return nil
+	})
+}
@@ -42,3 +42,47 @@ var psAppNamePluginService ServiceInterfaceFn = func(socketOperation *SocketOper
 	socketOperation.SendMsg(info)
 	return nil
 }
+
+var psAppPluginFollowService ServiceInterfaceFn = func(socketOperation *SocketOperation) error {
+	appName, err := socketOperation.ReadMsg()
+	if err != nil {
+		return err
+	}
+
+	pluginName, err := socketOperation.ReadMsg()
+	if err != nil {
+		return err
+	}
+
+	follow, recent, err := helper.AppStatusMgr.FollowPluginOutput(appName.String(), pluginName.String())
+	if err != nil {
+		return err
+	}
+	defer follow.Close()
+
+	if len(recent) > 0 {
+		socketOperation.SendMsg(recent)
+	}
+
+	closeChan := make(chan error, 1)
+	go func() {
+		for {
+			if _, err := socketOperation.ReadMsg(); err != nil {
+				closeChan <- err
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case output := <-follow.Output():
+			socketOperation.SendMsg(output)
+		case <-follow.Exit():
+			socketOperation.SendMsg([]byte("!!!!!!"))
+			return nil
+		case err = <-closeChan:
+			return err
+		}
+	}
+}
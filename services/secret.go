@@ -0,0 +1,55 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/byzk-org/bypt-server/db"
+	"github.com/byzk-org/bypt-server/helper"
+	"github.com/byzk-org/bypt-server/vos"
+	"github.com/jinzhu/gorm"
+)
+
+var secretSetService ServiceInterfaceFn = func(socketOperation *SocketOperation) error {
+	name, err := socketOperation.ReadMsg()
+	if err != nil {
+		return err
+	}
+
+	val, err := socketOperation.ReadMsg()
+	if err != nil {
+		return err
+	}
+
+	return helper.SetSecret(name.String(), val)
+}
+
+var secretListService ServiceInterfaceFn = func(socketOperation *SocketOperation) error {
+	secrets := make([]*vos.DbSecret, 0)
+	if err := db.GetDb().Model(&vos.DbSecret{}).Order("name").Find(&secrets).Error; err != nil && err != gorm.ErrRecordNotFound {
+		return errors.New("查询密钥列表失败")
+	}
+	marshal, _ := json.Marshal(secrets)
+	socketOperation.SendMsg(marshal)
+	return nil
+}
+
+var secretRmService ServiceInterfaceFn = func(socketOperation *SocketOperation) error {
+	name, err := socketOperation.ReadMsg()
+	if err != nil {
+		return err
+	}
+
+	secretWhere := db.GetDb().Model(&vos.DbSecret{}).Where(&vos.DbSecret{
+		Name: name.String(),
+	})
+
+	count := 0
+	if err = secretWhere.Count(&count).Error; err != nil || count == 0 {
+		return errors.New("未找到对应的密钥")
+	}
+
+	if err = secretWhere.Delete(&vos.DbSecret{}).Error; err != nil {
+		return errors.New("删除密钥失败")
+	}
+	return nil
+}
@@ -8,12 +8,10 @@ import (
 	"github.com/byzk-org/bypt-server/vos"
 	"gopkg.in/yaml.v2"
 	"os"
+	"sync"
 )
 
 var startService ServiceInterfaceFn = func(socketOperation *SocketOperation) error {
-	GlobalOperationLock.Lock()
-	defer GlobalOperationLock.Unlock()
-
 	msg, err := socketOperation.ReadMsg()
 	if err != nil {
 		return err
@@ -25,13 +23,32 @@ var startService ServiceInterfaceFn = func(socketOperation *SocketOperation) err
 		return errors.New("转换启动参数失败")
 	}
 
-	return helper.AppStatusMgr.StartApp(startInfo)
+	if err = startAppLocked(startInfo); err != nil {
+		return err
+	}
+	return waitStartReady(startInfo)
 }
 
-var startYamlConfigService ServiceInterfaceFn = func(socketOperation *SocketOperation) error {
+// startAppLocked 持有全局操作锁启动应用, 等待就绪时不持有锁, 避免阻塞其他命令
+func startAppLocked(startInfo *vos.DbAppStartInfo) error {
 	GlobalOperationLock.Lock()
 	defer GlobalOperationLock.Unlock()
+	return helper.AppStatusMgr.StartApp(startInfo)
+}
 
+// waitStartReady 启动配置要求等待就绪时等待应用完成启动
+func waitStartReady(startInfo *vos.DbAppStartInfo) error {
+	if startInfo.Readiness == nil || !startInfo.Readiness.Wait || startInfo.Job != nil {
+		return nil
+	}
+
+	if err := helper.AppStatusMgr.WaitAppReady(startInfo.Name); err != nil {
+		return errors.New("应用未能就绪 => " + err.Error())
+	}
+	return nil
+}
+
+var startYamlConfigService ServiceInterfaceFn = func(socketOperation *SocketOperation) error {
 	msg, err := socketOperation.ReadMsg()
 	if err != nil {
 		return err
@@ -53,14 +70,26 @@ var startYamlConfigService ServiceInterfaceFn = func(socketOperation *SocketOper
 		return errors.New("未从配置文件中解析出启动信息")
 	}
 
+	// 先启动全部应用, 再同时等待各应用就绪
+	wg := sync.WaitGroup{}
 	for k, v := range startInfoMap {
 		v.Name = k
-		if err = helper.AppStatusMgr.StartApp(v); err != nil {
+		if err = startAppLocked(v); err != nil {
 			socketOperation.SendMsg([]byte(fmt.Sprintf("error:[%s-%s]启动失败: %s", k, v.Version, err.Error())))
 			continue
 		}
-		socketOperation.SendMsg([]byte(fmt.Sprintf("[%s-%s]启动成功", k, v.Version)))
+
+		wg.Add(1)
+		go func(startInfo *vos.DbAppStartInfo) {
+			defer wg.Done()
+			if err := waitStartReady(startInfo); err != nil {
+				socketOperation.SendMsg([]byte(fmt.Sprintf("error:[%s-%s]启动失败: %s", startInfo.Name, startInfo.Version, err.Error())))
+				return
+			}
+			socketOperation.SendMsg([]byte(fmt.Sprintf("[%s-%s]启动成功", startInfo.Name, startInfo.Version)))
+		}(v)
 	}
+	wg.Wait()
 	socketOperation.SendMsg([]byte("!!!!!!"))
 	return nil
 }
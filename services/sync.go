@@ -36,9 +36,10 @@ type appVersionInfo struct {
 	Content           []byte             `json:"c,omitempty"`
 	PluginInfos       []*vos.DbAppPlugin `json:"ps,omitempty"`
 	JdkStartArgsBytes []byte             `json:"ja,omitempty"`
+	ExecType          vos.AppExecType    `json:"et,omitempty"`
+	Entrypoint        string             `json:"ep,omitempty"`
 
 	//Config        []*AppConfig
-	//ExecType      AppExecType
 	//CopyFiles     []*AppCopyFileInfo
 }
 
@@ -51,6 +52,8 @@ func (d *appVersionInfo) SignSrc() []byte {
 		d.ContentMd5,
 		d.JarPass,
 		d.Content,
+		vos.ExecTypeSignSrc(d.ExecType),
+		[]byte(d.Entrypoint),
 	}, nil)
 }
 
@@ -227,10 +230,9 @@ var syncInfoService ServiceInterfaceFn = func(socketOperation *SocketOperation)
 
 				appVersionSaveDir := filepath.Join(appSaveDirSetting.Val, appVersion.AppName, appVersion.Name)
 
-				pluginSaveDir := filepath.Join(appVersionSaveDir, "p")
-
 				appVersionPlugin := &bytes.Buffer{}
 				for pj, plugin := range versionPluginList {
+					plugin.StoreRunPolicy()
 					if !utils.PubKeyVerifySign(consts.CaPubKey, plugin.Src(), plugin.Sign) {
 						return errors.New("数据签名验证失败, 数据可能在传输过程中被篡改")
 					}
@@ -252,16 +254,30 @@ var syncInfoService ServiceInterfaceFn = func(socketOperation *SocketOperation)
 						return errors.New(outName + "插件: 解析运行密钥失败")
 					}
 
-					pluginRealPath := filepath.Join(pluginSaveDir, pluginMd5Hex+pluginSha1Hex)
-					encryptPath, e := utils.Sm4Encrypt(decryptPluginKey, []byte(pluginRealPath))
+					pluginTmpSaveFile := filepath.Join(pluginTmpSaveDir, fmt.Sprintf("p%s%s", pluginMd5Hex, pluginSha1Hex))
+					socketOperation.SendMsg([]byte(fmt.Sprintf("下载%s插件%d", outName, pj+1)))
+					socketOperation.SendMsg([]byte(pluginContentSizeStr))
+					if e = receiveData2File(pluginContentSize, pluginTmpSaveFile, conn, socketOperation); e != nil {
+						return errors.New(fmt.Sprintf("%s: %s", outName, e.Error()))
+					}
+
+					pluginRunFile, e := decryptSyncPlugin(decryptPluginKey, pluginTmpSaveFile, plugin)
 					if e != nil {
-						return errors.New(fmt.Sprintf("%s插件: 路径转换失败", outName))
+						return errors.New(outName + "插件: " + e.Error())
 					}
 
-					plugin.Content = bytes.Join([][]byte{
-						pluginSm4Key,
-						encryptPath,
-					}, nil)
+					pluginLib, e := savePluginLib(tx, pluginRunFile, &vos.DbPluginLib{
+						Desc:           plugin.Desc,
+						Type:           plugin.Type,
+						Md5:            plugin.Md5,
+						Sha1:           plugin.Sha1,
+						EnvConfigBytes: plugin.EnvConfigBytes,
+						RunPolicyBytes: plugin.RunPolicyBytes,
+					})
+					if e != nil {
+						return errors.New(outName + "插件: " + e.Error())
+					}
+					plugin.Content = pluginLib.Content
 
 					sign, err = utils.PrivateKeySign(consts.CaPrivateKey, plugin.Src())
 					if err != nil {
@@ -270,17 +286,9 @@ var syncInfoService ServiceInterfaceFn = func(socketOperation *SocketOperation)
 
 					plugin.Sign = sign
 
-					pluginTmpSaveFile := filepath.Join(pluginTmpSaveDir, fmt.Sprintf("p%s%s", pluginMd5Hex, pluginSha1Hex))
-					socketOperation.SendMsg([]byte(fmt.Sprintf("下载%s插件%d", outName, pj+1)))
-					socketOperation.SendMsg([]byte(pluginContentSizeStr))
-					if e = receiveData2File(pluginContentSize, pluginTmpSaveFile, conn, socketOperation); e != nil {
-						return errors.New(fmt.Sprintf("%s: %s", outName, e.Error()))
-					}
-
 					if err = appPluginModel.Create(&plugin).Error; err != nil {
 						return errors.New("保存应用插件信息失败")
 					}
-					renameMap[pluginTmpSaveFile] = pluginRealPath
 					appVersionPlugin.Write(plugin.Md5)
 					appVersionPlugin.Write(plugin.Sha1)
 				}
@@ -338,6 +346,8 @@ var syncInfoService ServiceInterfaceFn = func(socketOperation *SocketOperation)
 					Content:           appVersion.Content,
 					Plugins:           appVersionPlugin.Bytes(),
 					JdkStartArgsBytes: appVersion.JdkStartArgsBytes,
+					ExecType:          appVersion.ExecType,
+					Entrypoint:        appVersion.Entrypoint,
 				}
 
 				sign, err = utils.PrivateKeySign(consts.CaPrivateKey, endVersion.SignSrc())
@@ -562,6 +572,7 @@ var syncRemoteService ServiceInterfaceFn = func(socketOperation *SocketOperation
 						return errors.New("插件签名失败")
 					}
 					tmpPluginList[pi].Sign = sign
+					tmpPluginList[pi].LoadRunPolicy()
 					fileContentFile = append(fileContentFile, contentPath)
 				}
 				appVersionList[i].PluginInfo = tmpPluginList
@@ -605,6 +616,8 @@ var syncRemoteService ServiceInterfaceFn = func(socketOperation *SocketOperation
 				Content:           tmpVersionInfo.Content,
 				PluginInfos:       tmpVersionInfo.PluginInfo,
 				JdkStartArgsBytes: tmpVersionInfo.JdkStartArgsBytes,
+				ExecType:          tmpVersionInfo.ExecType,
+				Entrypoint:        tmpVersionInfo.Entrypoint,
 			}
 
 			sign, err = utils.PrivateKeySign(consts.CaPrivateKey, appVer.SignSrc())
@@ -822,3 +835,32 @@ func copyFile(srcFilePath, distFilePath string) error {
 	_, err = io.Copy(distFile, srcFile)
 	return err
 }
+
+// decryptSyncPlugin 解密同步收到的插件文件并校验摘要, 返回解密后的文件路径
+func decryptSyncPlugin(sm4Key []byte, encFilePath string, plugin *vos.DbAppPlugin) (string, error) {
+	encFile, err := os.Open(encFilePath)
+	if err != nil {
+		return "", errors.New("打开插件文件失败")
+	}
+	defer encFile.Close()
+
+	runFilePath := encFilePath + "r"
+	if err = utils.Sm4Decrypt2File(sm4Key, encFile, runFilePath); err != nil {
+		return "", err
+	}
+
+	md5Sum, err := utils.CalcMd5(runFilePath)
+	if err != nil {
+		return "", errors.New("获取插件md5摘要失败")
+	}
+
+	sha1Sum, err := utils.CalcSha1(runFilePath)
+	if err != nil {
+		return "", errors.New("获取插件sha1摘要失败")
+	}
+
+	if !bytes.Equal(md5Sum, plugin.Md5) || !bytes.Equal(sha1Sum, plugin.Sha1) {
+		return "", errors.New("插件摘要校验失败, 数据可能在传输过程中被篡改")
+	}
+	return runFilePath, nil
+}
@@ -0,0 +1,48 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/byzk-org/bypt-server/db"
+	"github.com/byzk-org/bypt-server/helper"
+	"github.com/byzk-org/bypt-server/vos"
+	"github.com/jinzhu/gorm"
+)
+
+var upgradeService ServiceInterfaceFn = func(socketOperation *SocketOperation) error {
+	msg, err := socketOperation.ReadMsg()
+	if err != nil {
+		return err
+	}
+
+	upgradeInfo := &vos.AppUpgradeInfo{}
+	if err = json.Unmarshal(msg, upgradeInfo); err != nil {
+		return errors.New("转换升级参数失败")
+	}
+
+	if err = helper.AppStatusMgr.UpgradeApp(upgradeInfo, func(msg string) {
+		socketOperation.SendMsg([]byte(msg))
+	}, &GlobalOperationLock); err != nil {
+		return err
+	}
+	socketOperation.SendMsg([]byte("!!!!!!"))
+	return nil
+}
+
+var upgradeListService ServiceInterfaceFn = func(socketOperation *SocketOperation) error {
+	appName, err := socketOperation.ReadMsg()
+	if err != nil {
+		return err
+	}
+
+	records := make([]*vos.DbAppUpgradeRecord, 0)
+	if err = db.GetDb().Model(&vos.DbAppUpgradeRecord{}).Where(&vos.DbAppUpgradeRecord{
+		AppName: appName.String(),
+	}).Order("start_time desc").Find(&records).Error; err != nil && err != gorm.ErrRecordNotFound {
+		return errors.New("查询升级记录失败")
+	}
+
+	marshal, _ := json.Marshal(records)
+	socketOperation.SendMsg(marshal)
+	return nil
+}
@@ -0,0 +1,35 @@
+package services
+
+import (
+	"encoding/json"
+	"github.com/byzk-org/bypt-server/helper"
+)
+
+var volumeListService ServiceInterfaceFn = func(socketOperation *SocketOperation) error {
+	appName, err := socketOperation.ReadMsg()
+	if err != nil {
+		return err
+	}
+
+	volumes, err := helper.AppStatusMgr.QueryVolumes(appName.String())
+	if err != nil {
+		return err
+	}
+
+	marshal, _ := json.Marshal(volumes)
+	socketOperation.SendMsg(marshal)
+	return nil
+}
+
+var volumeRmService ServiceInterfaceFn = func(socketOperation *SocketOperation) error {
+	appName, err := socketOperation.ReadMsg()
+	if err != nil {
+		return err
+	}
+
+	volumeName, err := socketOperation.ReadMsg()
+	if err != nil {
+		return err
+	}
+	return helper.AppStatusMgr.RemoveVolume(appName.String(), volumeName.String())
+}
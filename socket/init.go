@@ -101,11 +101,19 @@ func ServerRun() {
 
 		sendMsg([]byte("ok"))
 
-		if err = execFn(fn, readMsg, sendMsg); err != nil {
-			sendErrMsg(outChannel, err.Error())
-		} else {
-			sendMsg([]byte("ok"))
+		if services.AsyncServiceMap[cmdByte.String()] {
+			go execFnAndReply(fn, readMsg, sendMsg, outChannel)
+			continue
 		}
+		execFnAndReply(fn, readMsg, sendMsg, outChannel)
+	}
+}
+
+func execFnAndReply(fn services.ServiceInterfaceFn, readMsg services.ReadMsg, sendMsg services.SendSuccessMsg, outChannel chan []byte) {
+	if err := execFn(fn, readMsg, sendMsg); err != nil {
+		sendErrMsg(outChannel, err.Error())
+	} else {
+		sendMsg([]byte("ok"))
 	}
 }
 
@@ -0,0 +1,178 @@
+package utils
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule cron表达式, 格式: 分 时 日 月 周
+type CronSchedule struct {
+	minute  uint64
+	hour    uint64
+	dom     uint64
+	month   uint64
+	dow     uint64
+	domStar bool
+	dowStar bool
+}
+
+type cronBounds struct {
+	min int
+	max int
+}
+
+var (
+	cronMinuteBounds = cronBounds{0, 59}
+	cronHourBounds   = cronBounds{0, 23}
+	cronDomBounds    = cronBounds{1, 31}
+	cronMonthBounds  = cronBounds{1, 12}
+	cronDowBounds    = cronBounds{0, 6}
+
+	cronDescriptors = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+)
+
+// ParseCron 解析cron表达式
+func ParseCron(spec string) (*CronSchedule, error) {
+	spec = strings.TrimSpace(spec)
+	if descriptor, ok := cronDescriptors[spec]; ok {
+		spec = descriptor
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, errors.New("cron表达式必须包含5个字段: 分 时 日 月 周")
+	}
+
+	var (
+		err      error
+		schedule = &CronSchedule{
+			domStar: fields[2] == "*" || fields[2] == "?",
+			dowStar: fields[4] == "*" || fields[4] == "?",
+		}
+	)
+
+	if schedule.minute, err = parseCronField(fields[0], cronMinuteBounds); err != nil {
+		return nil, err
+	}
+
+	if schedule.hour, err = parseCronField(fields[1], cronHourBounds); err != nil {
+		return nil, err
+	}
+
+	if schedule.dom, err = parseCronField(fields[2], cronDomBounds); err != nil {
+		return nil, err
+	}
+
+	if schedule.month, err = parseCronField(fields[3], cronMonthBounds); err != nil {
+		return nil, err
+	}
+
+	if fields[4] == "7" {
+		fields[4] = "0"
+	}
+	if schedule.dow, err = parseCronField(fields[4], cronDowBounds); err != nil {
+		return nil, err
+	}
+
+	return schedule, nil
+}
+
+// Next 获取给定时间之后的下一次执行时间
+func (c *CronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	yearLimit := t.Year() + 5
+
+	for t.Year() <= yearLimit {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+	return time.Time{}
+}
+
+func (c *CronSchedule) dayMatches(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) > 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) > 0
+	if c.domStar || c.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+func parseCronField(field string, bounds cronBounds) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		rangeStr := part
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s <= 0 {
+				return 0, errors.New("cron表达式步长 [" + part + "] 不正确")
+			}
+			step = s
+			rangeStr = part[:i]
+		}
+
+		start, end := bounds.min, bounds.max
+		switch {
+		case rangeStr == "*" || rangeStr == "?":
+		case strings.Contains(rangeStr, "-"):
+			rangeSplit := strings.SplitN(rangeStr, "-", 2)
+			s, err := strconv.Atoi(rangeSplit[0])
+			if err != nil {
+				return 0, errors.New("cron表达式范围 [" + part + "] 不正确")
+			}
+			e, err := strconv.Atoi(rangeSplit[1])
+			if err != nil {
+				return 0, errors.New("cron表达式范围 [" + part + "] 不正确")
+			}
+			start, end = s, e
+		default:
+			v, err := strconv.Atoi(rangeStr)
+			if err != nil {
+				return 0, errors.New("cron表达式值 [" + part + "] 不正确")
+			}
+			start, end = v, v
+			if step > 1 {
+				end = bounds.max
+			}
+		}
+
+		if start < bounds.min || end > bounds.max || start > end {
+			return 0, errors.New("cron表达式 [" + part + "] 超出取值范围")
+		}
+
+		for i := start; i <= end; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
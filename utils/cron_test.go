@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func cronTime(year int, month time.Month, day, hour, min int) time.Time {
+	return time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+}
+
+func TestCronNext(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{"每分钟", "* * * * *", time.Date(2021, 3, 10, 10, 7, 30, 0, time.UTC), cronTime(2021, 3, 10, 10, 8)},
+		{"整分钟时取下一分钟", "* * * * *", cronTime(2021, 3, 10, 10, 7), cronTime(2021, 3, 10, 10, 8)},
+		{"步长", "*/15 * * * *", time.Date(2021, 3, 10, 10, 7, 30, 0, time.UTC), cronTime(2021, 3, 10, 10, 15)},
+		{"单值步长", "5/20 * * * *", cronTime(2021, 3, 10, 10, 30), cronTime(2021, 3, 10, 10, 45)},
+		{"列表", "5,10,45 * * * *", cronTime(2021, 3, 10, 10, 10), cronTime(2021, 3, 10, 10, 45)},
+		{"范围", "0 9-17 * * *", cronTime(2021, 3, 10, 17, 0), cronTime(2021, 3, 11, 9, 0)},
+		{"范围步长", "0 9-17/4 * * *", cronTime(2021, 3, 10, 10, 0), cronTime(2021, 3, 10, 13, 0)},
+		{"范围步长跨天", "0 9-17/4 * * *", cronTime(2021, 3, 10, 18, 0), cronTime(2021, 3, 11, 9, 0)},
+		{"列表与范围组合", "0 1,3-4,22 * * *", cronTime(2021, 3, 10, 4, 0), cronTime(2021, 3, 10, 22, 0)},
+		{"跨天", "59 23 * * *", cronTime(2021, 3, 10, 23, 59), cronTime(2021, 3, 11, 23, 59)},
+		{"跨月", "0 0 1 * *", cronTime(2021, 1, 31, 12, 0), cronTime(2021, 2, 1, 0, 0)},
+		{"跳过天数不足的月份", "0 0 31 * *", cronTime(2021, 4, 15, 0, 0), cronTime(2021, 5, 31, 0, 0)},
+		{"跨年", "30 23 31 12 *", cronTime(2021, 12, 31, 23, 30), cronTime(2022, 12, 31, 23, 30)},
+		{"跨年的每日任务", "59 23 * * *", cronTime(2021, 12, 31, 23, 59), cronTime(2022, 1, 1, 23, 59)},
+		{"闰年", "0 0 29 2 *", cronTime(2021, 3, 1, 0, 0), cronTime(2024, 2, 29, 0, 0)},
+		{"指定月份", "0 0 1 3,9 *", cronTime(2021, 3, 1, 0, 0), cronTime(2021, 9, 1, 0, 0)},
+		// 2021-08-06 为周五
+		{"仅指定周", "0 12 * * 1-5", cronTime(2021, 8, 6, 13, 0), cronTime(2021, 8, 9, 12, 0)},
+		{"问号等同星号", "0 0 ? * 0", cronTime(2021, 8, 2, 0, 0), cronTime(2021, 8, 8, 0, 0)},
+		{"7表示周日", "0 0 * * 7", cronTime(2021, 8, 2, 0, 0), cronTime(2021, 8, 8, 0, 0)},
+		{"仅指定日时不限制周", "0 0 13 * *", cronTime(2021, 8, 1, 0, 0), cronTime(2021, 8, 13, 0, 0)},
+		{"同时指定日和周时匹配周", "0 0 1 * 1", cronTime(2021, 8, 25, 0, 0), cronTime(2021, 8, 30, 0, 0)},
+		{"同时指定日和周时匹配日", "0 0 1 * 1", cronTime(2021, 8, 30, 0, 0), cronTime(2021, 9, 1, 0, 0)},
+		{"预定义每小时", "@hourly", cronTime(2021, 3, 10, 10, 0), cronTime(2021, 3, 10, 11, 0)},
+		{"预定义每周", "@weekly", cronTime(2021, 8, 2, 0, 0), cronTime(2021, 8, 8, 0, 0)},
+		{"预定义每年", " @yearly ", cronTime(2021, 6, 1, 0, 0), cronTime(2022, 1, 1, 0, 0)},
+		{"不存在的日期", "0 0 30 2 *", cronTime(2021, 1, 1, 0, 0), time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := ParseCron(tt.spec)
+			if err != nil {
+				t.Fatalf("解析 [%s] 失败 => %v", tt.spec, err)
+			}
+
+			if got := schedule.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("[%s] 在 %s 之后的执行时间为 %s, 期望 %s", tt.spec, tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCronNextKeepsLocation(t *testing.T) {
+	loc := time.FixedZone("UTC+8", 8*60*60)
+	schedule, err := ParseCron("0 2 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := schedule.Next(time.Date(2021, 3, 10, 3, 0, 0, 0, loc))
+	want := time.Date(2021, 3, 11, 2, 0, 0, 0, loc)
+	if !got.Equal(want) || got.Location() != loc {
+		t.Errorf("执行时间为 %s, 期望 %s", got, want)
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	specs := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"@every 5m",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 0 *",
+		"* * * 13 *",
+		"* * * * 8",
+		"-1 * * * *",
+		"5-1 * * * *",
+		"1-x * * * *",
+		"x-1 * * * *",
+		"0-60 * * * *",
+		"*/0 * * * *",
+		"*/-1 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"1,,2 * * * *",
+		"1,61 * * * *",
+	}
+
+	for _, spec := range specs {
+		if _, err := ParseCron(spec); err == nil {
+			t.Errorf("[%s] 应解析失败", spec)
+		}
+	}
+}
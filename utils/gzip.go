@@ -107,18 +107,110 @@ func DeCompressGzipByReader(tarFile io.Reader, dest string) error {
 			}
 		}
 
-		filename := filepath.Join(dest, hdr.Name)
-		if hdr.FileInfo().IsDir() {
+		filename, err := extractPath(dest, hdr.Name)
+		if err != nil {
+			return err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
 			if err = os.MkdirAll(filename, 0777); err != nil {
 				return errors.New("创建目录失败")
 			}
 			continue
+		case tar.TypeSymlink:
+			// 链接目标需为相对路径且位于解压目录内
+			target := filepath.Join(filepath.Dir(filename), hdr.Linkname)
+			if filepath.IsAbs(hdr.Linkname) || !isSubPath(dest, target) {
+				return errors.New("压缩包内链接 [" + hdr.Name + "] 指向解压目录之外")
+			}
+			if err = prepareExtractFile(filename); err != nil {
+				return err
+			}
+			if err = os.Symlink(hdr.Linkname, filename); err != nil {
+				return errors.New("创建链接 [" + hdr.Name + "] 失败 => " + err.Error())
+			}
+			continue
+		case tar.TypeLink:
+			target, err := extractPath(dest, hdr.Linkname)
+			if err != nil {
+				return err
+			}
+			if err = prepareExtractFile(filename); err != nil {
+				return err
+			}
+			if err = os.Link(target, filename); err != nil {
+				return errors.New("创建链接 [" + hdr.Name + "] 失败 => " + err.Error())
+			}
+			continue
+		case tar.TypeReg, tar.TypeRegA:
+		default:
+			// 设备文件、管道等不解压
+			continue
+		}
+
+		if err = prepareExtractFile(filename); err != nil {
+			return err
 		}
 		file, err = createFile(filename)
 		if err != nil {
 			return errors.New("创建本地临时存储文件失败 => " + err.Error())
 		}
 		_, _ = io.Copy(file, tr)
+		_ = file.Close()
+		if mode := hdr.FileInfo().Mode().Perm(); mode != 0 {
+			_ = os.Chmod(filename, mode)
+		}
+	}
+	return nil
+}
+
+// extractPath 压缩包内文件在解压目录中的路径, 路径不能超出解压目录, 也不能经过已解压的符号链接
+func extractPath(dest, name string) (string, error) {
+	filename := filepath.Join(dest, name)
+	if !isSubPath(dest, filename) {
+		return "", errors.New("压缩包内路径 [" + name + "] 超出解压目录")
+	}
+
+	rel, _ := filepath.Rel(dest, filename)
+	dir := filepath.Clean(dest)
+	for _, part := range strings.Split(filepath.Dir(rel), string(filepath.Separator)) {
+		if part == "." {
+			break
+		}
+
+		dir = filepath.Join(dir, part)
+		info, err := os.Lstat(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				break
+			}
+			return "", errors.New("读取解压目录失败 => " + err.Error())
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", errors.New("压缩包内路径 [" + name + "] 经过符号链接")
+		}
+	}
+	return filename, nil
+}
+
+// isSubPath 路径是否为目录本身或位于目录内
+func isSubPath(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// prepareExtractFile 创建文件所在目录并删除已存在的非目录文件, 避免通过已有的链接写入
+func prepareExtractFile(filename string) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return errors.New("创建目录失败 => " + err.Error())
+	}
+
+	if info, err := os.Lstat(filename); err == nil && !info.IsDir() {
+		if err = os.Remove(filename); err != nil {
+			return errors.New("删除已存在的文件 [" + filename + "] 失败")
+		}
 	}
 	return nil
 }
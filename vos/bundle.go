@@ -0,0 +1,28 @@
+package vos
+
+import "time"
+
+// DbConfigBundle 配置包, 内容为tar.gz格式的文件或目录, 同名配置包每次上传生成新的版本
+type DbConfigBundle struct {
+	Id       string `gorm:"primary_key" json:"id,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Revision int    `json:"revision,omitempty"`
+	Desc     string `json:"desc,omitempty"`
+	Content  []byte `json:"-"`
+	// FileCount 配置包内的文件数量
+	FileCount int `json:"fileCount"`
+	// Size 配置包内文件的总大小
+	Size       int64     `json:"size"`
+	CreateTime time.Time `json:"createTime,omitempty"`
+}
+
+// AppConfigBundleRef 启动配置中引用的配置包
+type AppConfigBundleRef struct {
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// Revision 配置包版本, 为0时使用启动时的最新版本
+	Revision int `json:"revision,omitempty" yaml:"revision,omitempty"`
+	// Path 配置包在运行目录中的相对路径, 为空时放在运行目录下
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+	// Template 是否将配置包内的文件作为模板, 使用应用的环境配置渲染
+	Template bool `json:"template,omitempty" yaml:"template,omitempty"`
+}
@@ -2,13 +2,17 @@ package vos
 
 import (
 	"bytes"
+	"encoding/json"
+	"strconv"
 	"time"
 )
 
 type AppExecType int
 
 const (
+	// AppExecTypePlugin 加密的jar程序, 通过java运行
 	AppExecTypePlugin AppExecType = iota
+	// AppExecTypeCmd 任意可执行程序, 或带有入口文件的tar.gz压缩包
 	AppExecTypeCmd
 )
 
@@ -29,6 +33,12 @@ const (
 	AppPluginTypeNormal   AppPluginType = "normal"
 	AppPluginTypeBefore   AppPluginType = "before"
 	AppPluginTypeAfter    AppPluginType = "after"
+	// AppPluginTypePreStop 停止应用之前执行, 此时应用仍在运行, 可用于从注册中心或负载均衡中摘除
+	AppPluginTypePreStop AppPluginType = "pre-stop"
+	// AppPluginTypeStop 用于优雅停止应用, 执行完成后等待应用退出, 超时后强制结束
+	AppPluginTypeStop AppPluginType = "stop"
+	// AppPluginTypePostStop 应用进程退出之后执行
+	AppPluginTypePostStop AppPluginType = "post-stop"
 )
 
 // DbAppStartInfo app启动信息
@@ -44,6 +54,8 @@ type DbAppStartInfo struct {
 	EnvConfigBytes []byte       `json:"-" yaml:"-"`
 	// JdkPath 本地jdk路径
 	JdkPath string `json:"jdkPath,omitempty" yaml:"jdkPath,omitempty"`
+	// Jdk 已登记的本地jdk名称或版本约束, 如 temurin-17.0.2、17、>=11、11+
+	Jdk string `json:"jdk,omitempty" yaml:"jdk,omitempty"`
 	// JdkPackName 管理器内jdk的名称
 	JdkPackName string     `json:"jdkPackName,omitempty" yaml:"jdkPackName,omitempty"`
 	JdkPackInfo *DbJdkInfo `gorm:"-" json:"-" yaml:"-"`
@@ -69,6 +81,46 @@ type DbAppStartInfo struct {
 	MaxPermSize          string                       `json:"maxPermSize,omitempty" yaml:"maxPermSize,omitempty"`
 	PluginEnvConfig      map[string]map[string]string `gorm:"-" json:"pluginEnvConfig,omitempty"`
 	PluginEnvConfigBytes []byte                       `json:"-"`
+	// OpenStdin 写入运行密钥后保持标准输入打开, 以便通过attach向应用输入
+	OpenStdin bool `json:"openStdin,omitempty" yaml:"openStdin,omitempty"`
+	// StopTimeout 停止阶段每个插件的超时时间以及等待应用退出的时间, 单位秒, 默认30秒
+	StopTimeout int64 `json:"stopTimeout,omitempty" yaml:"stopTimeout,omitempty"`
+	// RunUser 运行应用及插件的用户名或uid, 为空时使用服务默认用户
+	RunUser string `json:"runUser,omitempty" yaml:"runUser,omitempty"`
+	// RunGroup 运行应用及插件的用户组名或gid, 为空时使用用户的主组
+	RunGroup string `json:"runGroup,omitempty" yaml:"runGroup,omitempty"`
+	// RunGroups 附加用户组
+	RunGroups      []string `gorm:"-" json:"runGroups,omitempty" yaml:"runGroups,omitempty"`
+	RunGroupsBytes []byte   `json:"-" yaml:"-"`
+	// Sandbox 沙箱隔离配置, 为空时不隔离
+	Sandbox      *AppSandboxConfig `gorm:"-" json:"sandbox,omitempty" yaml:"sandbox,omitempty"`
+	SandboxBytes []byte            `json:"-" yaml:"-"`
+	// Ports 应用端口, 启动前检查端口占用并以环境变量方式注入
+	Ports      []*AppPortConfig `gorm:"-" json:"ports,omitempty" yaml:"ports,omitempty"`
+	PortsBytes []byte           `json:"-" yaml:"-"`
+	// Volumes 持久化数据卷, 链接到运行目录中, 应用重启及升级后数据保留
+	Volumes      []*AppVolumeConfig `gorm:"-" json:"volumes,omitempty" yaml:"volumes,omitempty"`
+	VolumesBytes []byte             `json:"-" yaml:"-"`
+	// ConfigBundles 引用的配置包, 启动时写出到运行目录
+	ConfigBundles      []*AppConfigBundleRef `gorm:"-" json:"configBundles,omitempty" yaml:"configBundles,omitempty"`
+	ConfigBundlesBytes []byte                `json:"-" yaml:"-"`
+	// Job 定时任务配置, 不为空时应用以定时任务方式运行
+	Job      *AppJobConfig `gorm:"-" json:"job,omitempty" yaml:"job,omitempty"`
+	JobBytes []byte        `json:"-" yaml:"-"`
+	// Readiness 就绪检测配置, 为空时程序进程启动后即视为运行中
+	Readiness      *AppReadinessConfig `gorm:"-" json:"readiness,omitempty" yaml:"readiness,omitempty"`
+	ReadinessBytes []byte              `json:"-" yaml:"-"`
+	// JvmOptions 结构化的JVM参数, 与Xmx等单独的内存参数同时设置时优先使用该配置
+	JvmOptions      *AppJvmOptions `gorm:"-" json:"jvmOptions,omitempty" yaml:"jvmOptions,omitempty"`
+	JvmOptionsBytes []byte         `json:"-" yaml:"-"`
+	// PluginRunPolicy 覆盖插件包中声明的before/after插件超时时间及失败策略, 键为插件名称前缀, 多个前缀匹配时使用最长的前缀
+	PluginRunPolicy      map[string]*AppPluginRunPolicy `gorm:"-" json:"pluginRunPolicy,omitempty" yaml:"pluginRunPolicy,omitempty"`
+	PluginRunPolicyBytes []byte                         `json:"-" yaml:"-"`
+	// PluginSelection 按插件名称前缀启用或禁用版本中的插件, 为空时运行全部插件
+	PluginSelection      *AppPluginSelection `gorm:"-" json:"pluginSelection,omitempty" yaml:"pluginSelection,omitempty"`
+	PluginSelectionBytes []byte              `json:"-" yaml:"-"`
+	// AdoptProcess 服务重启后要接管的运行中进程, 不为空时不重新启动应用
+	AdoptProcess *DbAppProcess `gorm:"-" json:"-" yaml:"-"`
 }
 
 type PluginInfo struct {
@@ -77,6 +129,8 @@ type PluginInfo struct {
 	Sha1      []byte        `json:"sha1,omitempty"`
 	Sign      []byte        `json:"sign,omitempty"`
 	EnvConfig []*AppConfig  `gorm:"-" json:"envConfig,omitempty"`
+	// RunPolicy before/after插件的超时时间及失败策略
+	RunPolicy *AppPluginRunPolicy `gorm:"-" json:"runPolicy,omitempty"`
 }
 
 type AppConfig struct {
@@ -98,6 +152,9 @@ type DbAppPlugin struct {
 	Type           AppPluginType
 	EnvConfig      []*AppConfig `gorm:"-" json:"envConfig,omitempty"`
 	EnvConfigBytes []byte       ` json:"-"`
+	// RunPolicy before/after插件的超时时间及失败策略, 可被启动配置覆盖
+	RunPolicy      *AppPluginRunPolicy `gorm:"-" json:"runPolicy,omitempty"`
+	RunPolicyBytes []byte              `json:"-"`
 }
 
 func (d *DbAppPlugin) Src() []byte {
@@ -110,9 +167,26 @@ func (d *DbAppPlugin) Src() []byte {
 		d.Sha1,
 		[]byte(d.Type),
 		d.EnvConfigBytes,
+		d.RunPolicyBytes,
 	}, nil)
 }
 
+// LoadRunPolicy 由RunPolicyBytes还原RunPolicy, RunPolicyBytes不参与json传输, 同步插件前需先还原
+func (d *DbAppPlugin) LoadRunPolicy() {
+	d.RunPolicy = nil
+	if len(d.RunPolicyBytes) > 0 {
+		_ = json.Unmarshal(d.RunPolicyBytes, &d.RunPolicy)
+	}
+}
+
+// StoreRunPolicy 由RunPolicy重建参与签名的RunPolicyBytes, 接收同步的插件后需先重建再验签
+func (d *DbAppPlugin) StoreRunPolicy() {
+	d.RunPolicyBytes = nil
+	if d.RunPolicy != nil {
+		d.RunPolicyBytes, _ = json.Marshal(d.RunPolicy)
+	}
+}
+
 type DbSetting struct {
 	Name    string `gorm:"primary_key" json:"name,omitempty"`
 	Desc    string `json:"desc,omitempty"`
@@ -150,8 +224,11 @@ type DbAppVersionInfo struct {
 	JdkStartArgsBytes []byte         `json:"-"`
 	OS                string         `gorm:"-" json:"os,omitempty"`
 	ARCH              string         `gorm:"-" json:"arch,omitempty"`
+	// ExecType 程序执行方式
+	ExecType AppExecType `json:"execType,omitempty"`
+	// Entrypoint 压缩包内的入口文件, 为空时程序内容本身即为可执行文件
+	Entrypoint string `json:"entrypoint,omitempty"`
 	//Config        []*AppConfig
-	//ExecType      AppExecType
 	//CopyFiles     []*AppCopyFileInfo
 }
 
@@ -160,6 +237,8 @@ type DbLog struct {
 	AppVersion string `json:"appVersion,omitempty"`
 	Content    []byte `json:"content,omitempty"`
 	AtDate     int64  `json:"atDate,omitempty"`
+	// Source 日志来源, 应用输出为空, 插件输出为插件名称
+	Source string `json:"source,omitempty"`
 }
 
 func (d *DbAppVersionInfo) SignSrc() []byte {
@@ -173,9 +252,19 @@ func (d *DbAppVersionInfo) SignSrc() []byte {
 		d.JarPass,
 		d.Plugins,
 		d.JdkStartArgsBytes,
+		ExecTypeSignSrc(d.ExecType),
+		[]byte(d.Entrypoint),
 	}, nil)
 }
 
+// ExecTypeSignSrc 执行方式的签名原文, 默认执行方式不参与签名以兼容已有数据
+func ExecTypeSignSrc(execType AppExecType) []byte {
+	if execType == AppExecTypePlugin {
+		return nil
+	}
+	return []byte(strconv.Itoa(int(execType)))
+}
+
 type DbJdkInfo struct {
 	Name          string    `json:"name,omitempty"`
 	Desc          string    `json:"desc,omitempty"`
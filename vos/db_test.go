@@ -0,0 +1,80 @@
+package vos
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"github.com/byzk-org/bypt-server/utils"
+	"github.com/tjfoc/gmsm/sm2"
+	"testing"
+)
+
+func TestDbAppPluginRunPolicySyncRoundTrip(t *testing.T) {
+	priKey, err := sm2.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runPolicyBytes, _ := json.Marshal(&AppPluginRunPolicy{
+		Timeout:    60,
+		FailPolicy: PluginFailPolicyRetry,
+		Retry:      2,
+	})
+	plugin := &DbAppPlugin{
+		AppName:        "demo",
+		AppVersion:     "1.0.0",
+		Name:           "before",
+		Content:        []byte("content"),
+		Md5:            []byte("md5"),
+		Sha1:           []byte("sha1"),
+		Type:           AppPluginTypeBefore,
+		RunPolicyBytes: runPolicyBytes,
+	}
+	if plugin.Sign, err = utils.PrivateKeySign(priKey, plugin.Src()); err != nil {
+		t.Fatal(err)
+	}
+
+	plugin.LoadRunPolicy()
+	data, err := json.Marshal([]*DbAppPlugin{plugin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var received []*DbAppPlugin
+	if err = json.Unmarshal(data, &received); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(received) != 1 || received[0].RunPolicy == nil {
+		t.Fatal("同步后插件运行策略丢失")
+	}
+
+	if received[0].RunPolicy.Timeout != 60 || received[0].RunPolicy.FailPolicy != PluginFailPolicyRetry || received[0].RunPolicy.Retry != 2 {
+		t.Errorf("同步后插件运行策略为 %+v", received[0].RunPolicy)
+	}
+
+	received[0].StoreRunPolicy()
+	if !utils.PubKeyVerifySign(&priKey.PublicKey, received[0].Src(), received[0].Sign) {
+		t.Fatal("同步后插件签名验证失败")
+	}
+}
+
+func TestDbAppPluginWithoutRunPolicySyncRoundTrip(t *testing.T) {
+	plugin := &DbAppPlugin{
+		AppName: "demo",
+		Name:    "listener",
+		Type:    AppPluginTypeListener,
+	}
+	src := plugin.Src()
+
+	plugin.LoadRunPolicy()
+	data, _ := json.Marshal(plugin)
+	received := &DbAppPlugin{}
+	if err := json.Unmarshal(data, received); err != nil {
+		t.Fatal(err)
+	}
+
+	received.StoreRunPolicy()
+	if received.RunPolicyBytes != nil || string(received.Src()) != string(src) {
+		t.Error("未设置运行策略的插件同步后签名内容发生变化")
+	}
+}
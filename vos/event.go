@@ -0,0 +1,47 @@
+package vos
+
+import "time"
+
+type AppEventType string
+
+const (
+	// AppEventStarting 开始启动
+	AppEventStarting AppEventType = "starting"
+	// AppEventRunning 程序进程已启动
+	AppEventRunning AppEventType = "running"
+	// AppEventReady 匹配到就绪日志, 应用完成启动
+	AppEventReady AppEventType = "ready"
+	// AppEventExited 程序退出
+	AppEventExited AppEventType = "exited"
+	// AppEventPluginFailed 插件运行失败
+	AppEventPluginFailed AppEventType = "pluginFailed"
+	// AppEventRestartScheduled 已安排重启
+	AppEventRestartScheduled AppEventType = "restartScheduled"
+	// AppEventStopped 被用户停止
+	AppEventStopped AppEventType = "stopped"
+	// AppEventSignFailed 签名或摘要校验失败
+	AppEventSignFailed AppEventType = "signFailed"
+	// AppEventAdopted 服务重启后接管了仍在运行的程序进程
+	AppEventAdopted AppEventType = "adopted"
+	// AppEventPluginDegraded 插件上报检测到问题
+	AppEventPluginDegraded AppEventType = "pluginDegraded"
+	// AppEventPluginHealthy 插件上报恢复正常
+	AppEventPluginHealthy AppEventType = "pluginHealthy"
+	// AppEventPluginRestart 插件请求重启应用
+	AppEventPluginRestart AppEventType = "pluginRestart"
+	// AppEventJvmWarning JVM参数告警, 如忽略了当前java版本不支持的参数
+	AppEventJvmWarning AppEventType = "jvmWarning"
+)
+
+// DbAppEvent 应用生命周期事件
+type DbAppEvent struct {
+	Id         string       `gorm:"primary_key" json:"id,omitempty"`
+	AppName    string       `json:"appName,omitempty"`
+	AppVersion string       `json:"appVersion,omitempty"`
+	Type       AppEventType `json:"type,omitempty"`
+	ExitCode   int          `json:"exitCode"`
+	// Signal 结束进程的信号, 进程非信号结束时为空
+	Signal     string    `json:"signal,omitempty"`
+	Msg        string    `json:"msg,omitempty"`
+	CreateTime time.Time `json:"createTime,omitempty"`
+}
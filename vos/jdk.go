@@ -0,0 +1,19 @@
+package vos
+
+import "time"
+
+// DbLocalJdk 通过扫描登记的本地jdk
+type DbLocalJdk struct {
+	Name string `gorm:"primary_key" json:"name,omitempty"`
+	// Home jdk安装目录
+	Home    string `json:"home,omitempty"`
+	JavaCmd string `json:"javaCmd,omitempty"`
+	// Version 完整版本, 如 1.8.0_292、17.0.2
+	Version string `json:"version,omitempty"`
+	// Major 主版本, 如 8、17
+	Major      int       `json:"major,omitempty"`
+	Vendor     string    `json:"vendor,omitempty"`
+	Arch       string    `json:"arch,omitempty"`
+	CreateTime time.Time `json:"createTime,omitempty"`
+	UpdateTime time.Time `json:"updateTime,omitempty"`
+}
@@ -0,0 +1,56 @@
+package vos
+
+import "time"
+
+type AppJobConcurrencyPolicy string
+
+const (
+	// AppJobConcurrencyForbid 上一次运行未结束时跳过本次运行
+	AppJobConcurrencyForbid AppJobConcurrencyPolicy = "forbid"
+	// AppJobConcurrencyReplace 停止上一次运行然后开始本次运行
+	AppJobConcurrencyReplace AppJobConcurrencyPolicy = "replace"
+	// AppJobConcurrencyAllow 允许多次运行同时存在
+	AppJobConcurrencyAllow AppJobConcurrencyPolicy = "allow"
+)
+
+type AppJobTriggerType string
+
+const (
+	AppJobTriggerSchedule AppJobTriggerType = "schedule"
+	AppJobTriggerManual   AppJobTriggerType = "manual"
+)
+
+type AppJobRunStatus string
+
+const (
+	AppJobRunStatusRunning  AppJobRunStatus = "正在运行"
+	AppJobRunStatusSuccess  AppJobRunStatus = "运行成功"
+	AppJobRunStatusFail     AppJobRunStatus = "运行失败"
+	AppJobRunStatusTimeout  AppJobRunStatus = "运行超时"
+	AppJobRunStatusReplaced AppJobRunStatus = "已被替换"
+)
+
+// AppJobConfig 定时任务配置
+type AppJobConfig struct {
+	// Schedule cron表达式, 格式: 分 时 日 月 周
+	Schedule string `json:"schedule,omitempty" yaml:"schedule,omitempty"`
+	// ConcurrencyPolicy 并发策略, forbid/replace/allow, 默认forbid
+	ConcurrencyPolicy AppJobConcurrencyPolicy `json:"concurrencyPolicy,omitempty" yaml:"concurrencyPolicy,omitempty"`
+	// Timeout 单次运行超时时间, 单位秒, 0为不限制
+	Timeout int64 `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+}
+
+// DbAppJobRecord 定时任务运行记录
+type DbAppJobRecord struct {
+	Id         string            `gorm:"primary_key" json:"id,omitempty"`
+	AppName    string            `json:"appName,omitempty"`
+	AppVersion string            `json:"appVersion,omitempty"`
+	Trigger    AppJobTriggerType `json:"trigger,omitempty"`
+	Status     AppJobRunStatus   `json:"status,omitempty"`
+	ExitCode   int               `json:"exitCode"`
+	Msg        string            `json:"msg,omitempty"`
+	StartTime  time.Time         `json:"startTime,omitempty"`
+	EndTime    time.Time         `json:"endTime,omitempty"`
+	// LogPath 运行日志文件, 日志时间在 StartTime 与 EndTime 之间
+	LogPath string `json:"logPath,omitempty"`
+}
@@ -0,0 +1,25 @@
+package vos
+
+// AppJvmOptions 结构化的JVM启动参数, 启动时根据所选jdk的主版本校验后渲染为命令行参数
+type AppJvmOptions struct {
+	// Xms 初始堆内存, 如 512m
+	Xms string `json:"xms,omitempty" yaml:"xms,omitempty"`
+	// Xmx 最大堆内存
+	Xmx string `json:"xmx,omitempty" yaml:"xmx,omitempty"`
+	// Xmn 新生代大小
+	Xmn string `json:"xmn,omitempty" yaml:"xmn,omitempty"`
+	// Xss 线程栈大小
+	Xss string `json:"xss,omitempty" yaml:"xss,omitempty"`
+	// MetaspaceSize 元空间初始大小, 需要java8及以上版本
+	MetaspaceSize string `json:"metaspaceSize,omitempty" yaml:"metaspaceSize,omitempty"`
+	// MaxMetaspaceSize 元空间最大大小, 需要java8及以上版本
+	MaxMetaspaceSize string `json:"maxMetaspaceSize,omitempty" yaml:"maxMetaspaceSize,omitempty"`
+	// Gc 垃圾收集器, 可选 serial、parallel、cms、g1、zgc、shenandoah
+	Gc string `json:"gc,omitempty" yaml:"gc,omitempty"`
+	// Properties 系统属性, 渲染为 -Dkey=value
+	Properties map[string]string `json:"properties,omitempty" yaml:"properties,omitempty"`
+	// JavaAgents -javaagent 列表, 格式为 jar路径[=参数], 相对路径相对于运行目录
+	JavaAgents []string `json:"javaAgents,omitempty" yaml:"javaAgents,omitempty"`
+	// ExtraFlags 其他JVM参数, 原样追加
+	ExtraFlags []string `json:"extraFlags,omitempty" yaml:"extraFlags,omitempty"`
+}
@@ -0,0 +1,57 @@
+package vos
+
+import "time"
+
+type NotifyChannelType string
+
+const (
+	// NotifyChannelWebhook 以POST方式向地址发送json格式的事件
+	NotifyChannelWebhook NotifyChannelType = "webhook"
+	// NotifyChannelExec 执行本地命令, 事件通过环境变量及标准输入传递
+	NotifyChannelExec NotifyChannelType = "exec"
+	// NotifyChannelEmail 通过smtp发送邮件
+	NotifyChannelEmail NotifyChannelType = "email"
+)
+
+// NotifySmtpConfig 邮件通知的smtp配置, 密码可以使用${secret:name}引用密钥
+type NotifySmtpConfig struct {
+	Host     string   `json:"host,omitempty" yaml:"host,omitempty"`
+	Port     int      `json:"port,omitempty" yaml:"port,omitempty"`
+	Username string   `json:"username,omitempty" yaml:"username,omitempty"`
+	Password string   `json:"password,omitempty" yaml:"password,omitempty"`
+	From     string   `json:"from,omitempty" yaml:"from,omitempty"`
+	To       []string `json:"to,omitempty" yaml:"to,omitempty"`
+}
+
+// DbNotifyRule 通知规则
+type DbNotifyRule struct {
+	Name string `gorm:"primary_key" json:"name,omitempty" yaml:"name,omitempty"`
+	// AppName 匹配的应用名称, 为空时匹配全部应用
+	AppName string `json:"appName,omitempty" yaml:"appName,omitempty"`
+	// EventTypes 匹配的事件类型, 为空时匹配退出、插件失败、安排重启及签名校验失败
+	EventTypes      []AppEventType    `gorm:"-" json:"eventTypes,omitempty" yaml:"eventTypes,omitempty"`
+	EventTypesBytes []byte            `json:"-" yaml:"-"`
+	Channel         NotifyChannelType `json:"channel,omitempty" yaml:"channel,omitempty"`
+	// Target webhook地址或本地命令路径
+	Target    string            `json:"target,omitempty" yaml:"target,omitempty"`
+	Smtp      *NotifySmtpConfig `gorm:"-" json:"smtp,omitempty" yaml:"smtp,omitempty"`
+	SmtpBytes []byte            `json:"-" yaml:"-"`
+	// DedupWindow 相同应用相同事件及消息在该时间内只通知一次, 单位秒, 默认300
+	DedupWindow int64 `json:"dedupWindow,omitempty" yaml:"dedupWindow,omitempty"`
+	// MaxPerHour 每个应用每小时最多通知次数, 0为不限制
+	MaxPerHour int       `json:"maxPerHour,omitempty" yaml:"maxPerHour,omitempty"`
+	CreateTime time.Time `json:"createTime,omitempty" yaml:"-"`
+}
+
+// NotifyPayload 通知内容
+type NotifyPayload struct {
+	Rule       string       `json:"rule,omitempty"`
+	Host       string       `json:"host,omitempty"`
+	AppName    string       `json:"appName,omitempty"`
+	AppVersion string       `json:"appVersion,omitempty"`
+	Type       AppEventType `json:"type,omitempty"`
+	ExitCode   int          `json:"exitCode"`
+	Signal     string       `json:"signal,omitempty"`
+	Msg        string       `json:"msg,omitempty"`
+	Time       time.Time    `json:"time,omitempty"`
+}
@@ -0,0 +1,133 @@
+package vos
+
+import (
+	"strings"
+	"time"
+)
+
+type PluginStatusType string
+
+const (
+	// PluginStatusHealthy 插件检测正常
+	PluginStatusHealthy PluginStatusType = "healthy"
+	// PluginStatusDegraded 插件检测到问题, 应用仍在运行
+	PluginStatusDegraded PluginStatusType = "degraded"
+	// PluginStatusRestart 插件请求重启应用
+	PluginStatusRestart PluginStatusType = "restart"
+)
+
+// PluginStatusMsg 插件通过状态通道发送的消息, 每行一个json, status为空时仅更新指标
+type PluginStatusMsg struct {
+	Status  PluginStatusType   `json:"status,omitempty"`
+	Msg     string             `json:"msg,omitempty"`
+	Metrics map[string]float64 `json:"metrics,omitempty"`
+}
+
+// AppPluginStatus 插件最近上报的状态
+type AppPluginStatus struct {
+	Status     PluginStatusType   `json:"status,omitempty"`
+	Msg        string             `json:"msg,omitempty"`
+	Metrics    map[string]float64 `json:"metrics,omitempty"`
+	UpdateTime time.Time          `json:"updateTime,omitempty"`
+	// LastRun before/after插件最近一次的运行结果
+	LastRun *AppPluginRunResult `json:"lastRun,omitempty"`
+	// Process 插件进程最近一次的运行状态
+	Process *AppPluginProcess `json:"process,omitempty"`
+}
+
+type PluginFailPolicy string
+
+const (
+	// PluginFailPolicyFail 插件失败时应用启动失败
+	PluginFailPolicyFail PluginFailPolicy = "fail"
+	// PluginFailPolicyWarn 插件失败时记录警告并继续启动
+	PluginFailPolicyWarn PluginFailPolicy = "warn"
+	// PluginFailPolicyRetry 插件失败时重试, 重试次数用尽后应用启动失败
+	PluginFailPolicyRetry PluginFailPolicy = "retry"
+)
+
+// AppPluginRunPolicy before/after插件的超时时间及失败策略
+type AppPluginRunPolicy struct {
+	// Timeout 单次运行的超时时间, 单位秒, 默认300秒
+	Timeout int64 `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	// FailPolicy 失败策略, 默认为fail
+	FailPolicy PluginFailPolicy `json:"failPolicy,omitempty" yaml:"failPolicy,omitempty"`
+	// Retry 失败策略为retry时的重试次数
+	Retry int `json:"retry,omitempty" yaml:"retry,omitempty"`
+}
+
+type PluginRunResultType string
+
+const (
+	// PluginRunSuccess 运行成功
+	PluginRunSuccess PluginRunResultType = "success"
+	// PluginRunFailed 运行失败, 应用启动失败
+	PluginRunFailed PluginRunResultType = "failed"
+	// PluginRunWarned 运行失败, 按失败策略忽略并继续启动
+	PluginRunWarned PluginRunResultType = "warned"
+)
+
+// AppPluginRunResult before/after插件最近一次的运行结果
+type AppPluginRunResult struct {
+	Result PluginRunResultType `json:"result,omitempty"`
+	// Attempts 运行次数, 包含重试
+	Attempts int    `json:"attempts,omitempty"`
+	Msg      string `json:"msg,omitempty"`
+	// Duration 包含重试在内的总耗时, 单位毫秒
+	Duration   int64     `json:"duration,omitempty"`
+	FinishTime time.Time `json:"finishTime,omitempty"`
+}
+
+type PluginProcessState string
+
+const (
+	// PluginProcessPending 等待运行
+	PluginProcessPending PluginProcessState = "pending"
+	// PluginProcessRunning 运行中
+	PluginProcessRunning PluginProcessState = "running"
+	// PluginProcessExited 正常退出
+	PluginProcessExited PluginProcessState = "exited"
+	// PluginProcessFailed 启动失败或异常退出
+	PluginProcessFailed PluginProcessState = "failed"
+)
+
+// AppPluginProcess 插件进程的运行状态
+type AppPluginProcess struct {
+	State     PluginProcessState `json:"state,omitempty"`
+	Pid       int                `json:"pid,omitempty"`
+	StartTime time.Time          `json:"startTime,omitempty"`
+	EndTime   time.Time          `json:"endTime,omitempty"`
+	ExitCode  int                `json:"exitCode,omitempty"`
+	// Signal 结束进程的信号, 进程非信号结束时为空
+	Signal string `json:"signal,omitempty"`
+	ErrMsg string `json:"errMsg,omitempty"`
+	// RestartCount 本次应用运行中插件进程重新启动的次数
+	RestartCount int `json:"restartCount,omitempty"`
+}
+
+// AppPluginSelection 按插件名称前缀选择本次运行的插件, allow不为空时仅运行匹配的插件, 匹配deny的插件不运行
+type AppPluginSelection struct {
+	Allow []string `json:"allow,omitempty" yaml:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty" yaml:"deny,omitempty"`
+}
+
+// Selected 插件是否被选中运行
+func (s *AppPluginSelection) Selected(pluginName string) bool {
+	if s == nil {
+		return true
+	}
+
+	if len(s.Allow) > 0 && !matchPluginPrefix(s.Allow, pluginName) {
+		return false
+	}
+	return !matchPluginPrefix(s.Deny, pluginName)
+}
+
+func matchPluginPrefix(prefixes []string, pluginName string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(pluginName, prefix) {
+			return true
+		}
+	}
+	return false
+}
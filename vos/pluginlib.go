@@ -0,0 +1,47 @@
+package vos
+
+import (
+	"bytes"
+	"time"
+)
+
+// DbPluginLib 插件库中的插件, 同一插件文件只保存一份, 可被多个应用版本引用
+type DbPluginLib struct {
+	// Name 插件文件md5与sha1摘要的十六进制
+	Name    string        `gorm:"primary_key" json:"name,omitempty"`
+	Desc    string        `json:"desc,omitempty"`
+	Type    AppPluginType `json:"type,omitempty"`
+	Content []byte        `json:"-"`
+	Md5     []byte        `json:"md5,omitempty"`
+	Sha1    []byte        `json:"sha1,omitempty"`
+	// EnvConfigBytes、RunPolicyBytes 首次导入时插件包中的声明, 引用到应用版本时作为插件的默认配置
+	EnvConfigBytes []byte    `json:"-"`
+	RunPolicyBytes []byte    `json:"-"`
+	Sign           []byte    `json:"-"`
+	CreateTime     time.Time `json:"createTime,omitempty"`
+}
+
+func (d *DbPluginLib) SignSrc() []byte {
+	return bytes.Join([][]byte{
+		[]byte(d.Name),
+		[]byte(d.Desc),
+		[]byte(d.Type),
+		d.Content,
+		d.Md5,
+		d.Sha1,
+		d.EnvConfigBytes,
+		d.RunPolicyBytes,
+	}, nil)
+}
+
+// PluginLibUsage 引用插件库中插件的应用版本
+type PluginLibUsage struct {
+	AppName    string `json:"appName,omitempty"`
+	AppVersion string `json:"appVersion,omitempty"`
+}
+
+// PluginLibInfo 插件库中的插件及其使用情况
+type PluginLibInfo struct {
+	*DbPluginLib
+	Usage []*PluginLibUsage `json:"usage"`
+}
@@ -0,0 +1,11 @@
+package vos
+
+// AppPortConfig 应用端口配置
+type AppPortConfig struct {
+	// Name 端口名称
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// Port 固定端口, 为0时从端口范围配置中自动分配
+	Port int `json:"port,omitempty" yaml:"port,omitempty"`
+	// Env 注入的环境变量名称, 默认为PORT_加大写的端口名称
+	Env string `json:"env,omitempty" yaml:"env,omitempty"`
+}
@@ -0,0 +1,25 @@
+package vos
+
+import "time"
+
+// DbAppProcess 运行中应用的进程信息, 服务重启后用于接管仍在运行的进程
+type DbAppProcess struct {
+	// RunId 单次运行的标识, 应用、插件及输出中转进程的环境变量中均包含该标识
+	RunId      string `gorm:"primary_key" json:"runId,omitempty"`
+	AppName    string `json:"appName,omitempty"`
+	AppVersion string `json:"appVersion,omitempty"`
+	Pid        int    `json:"pid,omitempty"`
+	// StartTime 进程启动时间(/proc/<pid>/stat中的starttime), 用于识别进程号被复用
+	StartTime uint64 `json:"startTime,omitempty"`
+	// Pgid 应用进程组
+	Pgid int `json:"pgid,omitempty"`
+	// RelayPid 输出中转进程
+	RelayPid       int    `json:"relayPid,omitempty"`
+	RelayStartTime uint64 `json:"relayStartTime,omitempty"`
+	// SpoolDir 输出中转目录
+	SpoolDir   string    `json:"spoolDir,omitempty"`
+	RunDir     string    `json:"runDir,omitempty"`
+	JavaCmd    string    `json:"javaCmd,omitempty"`
+	PortsBytes []byte    `json:"-"`
+	CreateTime time.Time `json:"createTime,omitempty"`
+}
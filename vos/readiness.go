@@ -0,0 +1,13 @@
+package vos
+
+// AppReadinessConfig 应用就绪检测配置, 程序进程启动后根据日志判断应用是否真正完成启动
+type AppReadinessConfig struct {
+	// ReadyLogPattern 应用就绪的日志正则, 如 "Started .* in"
+	ReadyLogPattern string `json:"readyLogPattern,omitempty" yaml:"readyLogPattern,omitempty"`
+	// FailLogPattern 应用启动失败的日志正则, 就绪前匹配到时视为启动失败
+	FailLogPattern string `json:"failLogPattern,omitempty" yaml:"failLogPattern,omitempty"`
+	// StartTimeout 等待就绪的超时时间, 单位秒, 超时视为启动失败
+	StartTimeout int64 `json:"startTimeout,omitempty" yaml:"startTimeout,omitempty"`
+	// Wait 启动时是否等待应用就绪后再返回结果
+	Wait bool `json:"wait,omitempty" yaml:"wait,omitempty"`
+}
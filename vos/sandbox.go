@@ -0,0 +1,17 @@
+package vos
+
+// AppSandboxConfig 应用沙箱隔离配置, 基于linux命名空间实现, 需要以root用户运行服务
+type AppSandboxConfig struct {
+	// MountNs 使用独立的挂载命名空间, 提供私有的/tmp并隐藏其他应用的运行目录
+	MountNs bool `json:"mountNs,omitempty" yaml:"mountNs,omitempty"`
+	// ReadOnlyPaths 以只读方式挂载的主机路径, 需要开启挂载命名空间
+	ReadOnlyPaths []string `json:"readOnlyPaths,omitempty" yaml:"readOnlyPaths,omitempty"`
+	// PidNs 使用独立的进程命名空间
+	PidNs bool `json:"pidNs,omitempty" yaml:"pidNs,omitempty"`
+	// NetNs 使用独立的网络命名空间, 仅可访问回环网卡
+	NetNs bool `json:"netNs,omitempty" yaml:"netNs,omitempty"`
+	// NoNewPrivs 禁止进程通过setuid等方式获取新的权限
+	NoNewPrivs bool `json:"noNewPrivs,omitempty" yaml:"noNewPrivs,omitempty"`
+	// DropCaps 丢弃进程的全部capabilities
+	DropCaps bool `json:"dropCaps,omitempty" yaml:"dropCaps,omitempty"`
+}
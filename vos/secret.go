@@ -0,0 +1,11 @@
+package vos
+
+import "time"
+
+// DbSecret 加密存储的密钥信息, 值使用节点密钥进行sm4加密
+type DbSecret struct {
+	Name          string    `gorm:"primary_key" json:"name,omitempty"`
+	Value         []byte    `json:"-"`
+	CreateTime    time.Time `json:"createTime,omitempty"`
+	EndUpdateTime time.Time `json:"endUpdateTime,omitempty"`
+}
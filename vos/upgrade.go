@@ -0,0 +1,39 @@
+package vos
+
+import "time"
+
+type AppUpgradeStatus string
+
+const (
+	AppUpgradeStatusWaitReady AppUpgradeStatus = "等待就绪"
+	AppUpgradeStatusVerify    AppUpgradeStatus = "正在验证"
+	AppUpgradeStatusSuccess   AppUpgradeStatus = "升级成功"
+	AppUpgradeStatusFail      AppUpgradeStatus = "升级失败"
+	AppUpgradeStatusRollback  AppUpgradeStatus = "已回滚"
+)
+
+// AppUpgradeInfo 应用升级参数
+type AppUpgradeInfo struct {
+	// StartInfo 目标版本的启动信息
+	StartInfo *DbAppStartInfo `json:"startInfo,omitempty"`
+	// ReadyLogPattern 目标版本就绪的日志正则
+	ReadyLogPattern string `json:"readyLogPattern,omitempty"`
+	// ProbeAddr 健康探测地址, http(s)://开头时使用GET请求, 否则视为 IP:PORT 进行tcp探测
+	ProbeAddr string `json:"probeAddr,omitempty"`
+	// ReadyTimeout 等待就绪的超时时间, 单位秒
+	ReadyTimeout int64 `json:"readyTimeout,omitempty"`
+	// VerifyTime 停止旧版本后的验证时间, 单位秒
+	VerifyTime int64 `json:"verifyTime,omitempty"`
+}
+
+// DbAppUpgradeRecord 应用升级记录
+type DbAppUpgradeRecord struct {
+	Id          string           `gorm:"primary_key" json:"id,omitempty"`
+	AppName     string           `json:"appName,omitempty"`
+	FromVersion string           `json:"fromVersion,omitempty"`
+	ToVersion   string           `json:"toVersion,omitempty"`
+	Status      AppUpgradeStatus `json:"status,omitempty"`
+	Msg         string           `json:"msg,omitempty"`
+	StartTime   time.Time        `json:"startTime,omitempty"`
+	EndTime     time.Time        `json:"endTime,omitempty"`
+}
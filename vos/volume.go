@@ -0,0 +1,21 @@
+package vos
+
+// AppVolumeConfig 应用持久化数据卷配置
+type AppVolumeConfig struct {
+	// Name 数据卷名称, 同一应用的不同版本使用相同名称的数据卷共享数据
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// Path 数据卷在运行目录中的相对路径
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+}
+
+// AppVolumeInfo 数据卷信息
+type AppVolumeInfo struct {
+	AppName string `json:"appName,omitempty"`
+	Name    string `json:"name,omitempty"`
+	// Dir 数据卷在主机上的存储目录
+	Dir string `json:"dir,omitempty"`
+	// Size 数据卷占用的字节数
+	Size int64 `json:"size"`
+	// InUse 是否被运行中的应用使用
+	InUse bool `json:"inUse,omitempty"`
+}